@@ -2,17 +2,37 @@ package main
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	logger "github.com/sirupsen/logrus"
-	"github.com/wellywell/bonusy/internal/accrual"
+	"github.com/wellywell/bonusy/internal/auth"
 	"github.com/wellywell/bonusy/internal/compress"
 	"github.com/wellywell/bonusy/internal/config"
 	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/events"
 	"github.com/wellywell/bonusy/internal/handlers"
+	"github.com/wellywell/bonusy/internal/merchant"
+	"github.com/wellywell/bonusy/internal/notify"
+	"github.com/wellywell/bonusy/internal/oidc"
 	"github.com/wellywell/bonusy/internal/order"
+	"github.com/wellywell/bonusy/internal/points"
+	"github.com/wellywell/bonusy/internal/ratelimit"
+	"github.com/wellywell/bonusy/internal/request"
 	"github.com/wellywell/bonusy/internal/router"
 )
 
+// В проде подобрать интервал под объём партий
+const pointsSettleInterval = time.Hour
+
+// как часто перепроверять уже начисленный заказ
+const accrualRecheckInterval = time.Hour
+
+const oidcTimeout = 10 * time.Second
+
+// дольше самого длинного окна лимитов запросов, включая лимит API ключа
+const rateLimitMaxWindow = time.Hour
+
 func main() {
 	conf, err := config.NewConfig()
 	if err != nil {
@@ -20,22 +40,78 @@ func main() {
 	}
 
 	logger.Info("Database on", conf.DatabaseDSN)
-	database, err := db.NewDatabase(conf.DatabaseDSN)
+	database, err := db.NewDatabase(conf.DatabaseDSN, conf.PointsPolicy())
 	if err != nil {
 		panic(err)
 	}
-	client := accrual.NewAccrualClient(conf.AccrualSystemAddress)
-
 	ctx, cancel := context.WithCancel(context.Background())
 
+	merchants, err := merchant.NewRegistry(ctx, database, conf.AccrualSystemAddress, conf.OrderValidation)
+	if err != nil {
+		panic(err)
+	}
+	accrualClients := func(merchantID int) order.AccrualClient {
+		return merchants.AccrualClient(merchantID)
+	}
+
 	checkOrdersQueue := order.GenerateStatusTasks(ctx, database)
-	UpdateUnprocessedOrdersQueue := order.CheckAccrualOrders(ctx, checkOrdersQueue, client)
+	UpdateUnprocessedOrdersQueue := order.CheckAccrualOrders(ctx, checkOrdersQueue, accrualClients)
+
+	broker := events.NewBroker()
+	var publisher order.Publisher = broker
+	if conf.OrderEventsPGNotify {
+		publisher = events.NewPGPublisher(database)
+		events.Relay(ctx, database, broker)
+	}
+
+	order.UpdateStatuses(ctx, UpdateUnprocessedOrdersQueue, database, publisher)
 
-	order.UpdateStatuses(ctx, UpdateUnprocessedOrdersQueue, database)
+	if window := conf.AccrualRecheckWindow(); window > 0 {
+		recheckQueue := order.GenerateRecheckTasks(ctx, database, window, accrualRecheckInterval)
+		order.UpdateStatuses(ctx, order.CheckAccrualOrders(ctx, recheckQueue, accrualClients), database, publisher)
+	}
+
+	points.RunSettlement(ctx, database, pointsSettleInterval)
 
-	handlerSet := handlers.NewHandlerSet(conf.Secret, conf.AuthCookieExpiresIn, database)
+	cookie, err := conf.CookieOptions()
+	if err != nil {
+		panic(err)
+	}
+
+	proxies, err := conf.TrustedProxyPrefixes()
+	if err != nil {
+		panic(err)
+	}
+
+	hasher, err := auth.NewHasher(conf.HashPolicy())
+	if err != nil {
+		panic(err)
+	}
+
+	var oidcProvider *oidc.Provider
+	if conf.OIDCIssuer != "" {
+		oidcProvider, err = oidc.NewProvider(ctx, conf.OIDC(), &http.Client{Timeout: oidcTimeout})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var notifier notify.Notifier = notify.LogNotifier{}
+	if conf.NotifyFile != "" {
+		notifier = notify.NewFileNotifier(conf.NotifyFile)
+	}
+
+	handlerSet := handlers.NewHandlerSet(conf.Secret, cookie, database, broker,
+		conf.LoginPolicy(), conf.PasswordPolicy(), hasher, notifier, conf.Withdraw2FAThreshold, oidcProvider, conf.APIKeyRateLimit)
+
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	if conf.RateLimitPostgres {
+		limits = ratelimit.NewPGStore(database)
+		ratelimit.RunCleanup(ctx, database, rateLimitMaxWindow)
+	}
 
-	r := router.NewRouter(conf, handlerSet, compress.RequestUngzipper{})
+	r := router.NewRouter(conf, handlerSet, merchants, database, database, ratelimit.NewLimiter(limits),
+		request.TrustedProxies{Prefixes: proxies}, compress.RequestUngzipper{})
 
 	err = r.ListenAndServe()
 	if err != nil {
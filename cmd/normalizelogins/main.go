@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/db"
+)
+
+// Утилита приводит логины, заведённые до нормализации, к виду, в котором их ищет сервис.
+// Запускается один раз после миграции; если логины совпадают, ничего не меняет и перечисляет их
+//
+//	normalizelogins -d postgres://...
+func main() {
+	dsn := flag.String("d", os.Getenv("DATABASE_URI"), "Database DSN")
+	flag.Parse()
+
+	if *dsn == "" || flag.NArg() != 0 {
+		log.Fatal("usage: normalizelogins -d DSN")
+	}
+
+	database, err := db.OpenDatabase(*dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := database.NormalizeLogins(context.Background(), auth.NormalizeLogin); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("logins normalized")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wellywell/bonusy/internal/db"
+)
+
+// Утилита для поддержки: печатает историю статусов заказов
+//
+//	orderhistory -d postgres://... 49927398716 79927398713
+func main() {
+	dsn := flag.String("d", os.Getenv("DATABASE_URI"), "Database DSN")
+	merchantCode := flag.String("m", "default", "Merchant code")
+	showPayload := flag.Bool("p", false, "Print raw accrual payload")
+	flag.Parse()
+
+	if *dsn == "" || flag.NArg() == 0 {
+		log.Fatal("usage: orderhistory -d DSN [-m MERCHANT] [-p] ORDER...")
+	}
+
+	database, err := db.OpenDatabase(*dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "ORDER\tSTATUS\tSOURCE\tCHANGED AT")
+
+	for _, number := range flag.Args() {
+		history, err := database.GetOrderStatusHistoryByNumber(context.Background(), *merchantCode, number)
+		if err != nil {
+			if errors.Is(err, db.ErrOrderNotFound) {
+				fmt.Fprintf(w, "%s\tnot found\t\t\n", number)
+				continue
+			}
+			w.Flush()
+			log.Fatal(err)
+		}
+		for _, change := range history {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", number, change.Status, change.Source, change.ChangedAt.Format(time.RFC3339))
+			if *showPayload && change.Payload != nil {
+				fmt.Fprintf(w, "\t%s\t\t\n", change.Payload)
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// Утилита для назначения роли пользователю, например первого администратора магазина
+//
+//	setrole -d postgres://... -m default alice admin
+func main() {
+	dsn := flag.String("d", os.Getenv("DATABASE_URI"), "Database DSN")
+	merchantCode := flag.String("m", "default", "Merchant code")
+	flag.Parse()
+
+	if *dsn == "" || flag.NArg() != 2 {
+		log.Fatal("usage: setrole -d DSN [-m MERCHANT] LOGIN ROLE")
+	}
+
+	database, err := db.OpenDatabase(*dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	login, role := flag.Arg(0), types.UserRole(flag.Arg(1))
+	if err := database.SetUserRole(context.Background(), *merchantCode, auth.NormalizeLogin(login), role); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%s is now %s", login, role)
+}
@@ -19,6 +19,8 @@ type OrderStatus struct {
 	Order   string       `json:"order"`
 	Status  types.Status `json:"status"`
 	Accrual float64      `json:"accrual"`
+	// Raw - исходный ответ системы начислений, сохраняется в истории заказа
+	Raw json.RawMessage `json:"-"`
 }
 
 type ErrThrottle struct {
@@ -58,6 +60,7 @@ func (c *AccrualClient) GetOrderStatus(orderNum string) (*OrderStatus, error) {
 		if err != nil {
 			return nil, fmt.Errorf("json parsing error %w", err)
 		}
+		status.Raw = body
 		return &status, nil
 
 	case http.StatusNoContent:
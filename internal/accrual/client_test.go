@@ -57,6 +57,10 @@ func TestClient(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 			}
+			if res != nil {
+				assert.JSONEq(t, tc.body, string(res.Raw))
+				res.Raw = nil
+			}
 
 			assert.Equal(t, tc.expectedResult, res)
 
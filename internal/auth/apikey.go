@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wellywell/bonusy/internal/ratelimit"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+var ErrInvalidScope = errors.New("invalid api key scope")
+
+const (
+	apiKeyPrefix = "bk_"
+	// заголовок, в котором ключ магазина называет пользователя
+	OnBehalfOfHeader = "X-On-Behalf-Of"
+	// окно, за которое считается лимит запросов ключа
+	apiKeyRateWindow = time.Minute
+)
+
+// APIKeyScopes - права, которые можно выдать ключу: просмотр счёта и загрузка заказов.
+// Списания и переводы доступны только из сессии пользователя
+var APIKeyScopes = []Permission{PermAccountRead, PermOrdersWrite}
+
+type APIKeyStore interface {
+	GetAPIKey(ctx context.Context, prefix string) (*types.APIKey, error)
+	TouchAPIKey(ctx context.Context, keyID int) error
+}
+
+// GenerateAPIKey возвращает ключ для выдачи клиенту и его открытую часть для поиска в базе
+func GenerateAPIKey() (key string, prefix string, err error) {
+	raw := make([]byte, 8+32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(raw[:8])
+	return apiKeyPrefix + prefix + "_" + hex.EncodeToString(raw[8:]), prefix, nil
+}
+
+// ParseAPIKey достаёт открытую часть из ключа вида bk_<prefix>_<secret>
+func ParseAPIKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 16 || len(secret) != 64 {
+		return "", false
+	}
+	return prefix, true
+}
+
+// HashAPIKey - ключ случайный и длинный, поэтому медленный хеш, как для паролей, не нужен
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateScopes проверяет права ключа; роль владельца должна давать каждое из них
+func ValidateScopes(role types.UserRole, scopes []string) error {
+	if len(scopes) == 0 {
+		return ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(APIKeyScopes, Permission(scope)) || !HasPermission(role, Permission(scope)) {
+			return ErrInvalidScope
+		}
+	}
+	return nil
+}
+
+// APIKeyMiddleware аутентифицирует запросы по ключу из X-API-Key или Authorization: Bearer.
+// Запросы без ключа передаются дальше, к AuthenticateMiddleware
+type APIKeyMiddleware struct {
+	keys    APIKeyStore
+	users   UserStore
+	limiter *ratelimit.Limiter
+}
+
+func NewAPIKeyMiddleware(keys APIKeyStore, users UserStore, limiter *ratelimit.Limiter) *APIKeyMiddleware {
+	return &APIKeyMiddleware{keys: keys, users: users, limiter: limiter}
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(token, apiKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+func (m *APIKeyMiddleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFromRequest(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		prefix, ok := ParseAPIKey(key)
+		if !ok {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		apiKey, err := m.keys.GetAPIKey(r.Context(), prefix)
+		if err != nil || subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(apiKey.Hash)) != 1 {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		rule := ratelimit.Rule{Name: "api_key", Limit: apiKey.RateLimit, Window: apiKeyRateWindow}
+		if wait := m.limiter.Allow(r.Context(), rule, strconv.Itoa(apiKey.ID)); wait > 0 {
+			ratelimit.TooManyRequests(w, wait)
+			return
+		}
+
+		login := apiKey.Login
+		if apiKey.UserID == nil {
+			login = NormalizeLogin(r.Header.Get(OnBehalfOfHeader))
+			if login == "" {
+				http.Error(w, OnBehalfOfHeader+" header required", http.StatusBadRequest)
+				return
+			}
+		}
+		user, err := m.users.GetUser(r.Context(), apiKey.MerchantID, login)
+		if err != nil || user.Status == types.UserDeleted {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if user.Status != types.UserActive {
+			http.Error(w, "User is locked", http.StatusForbidden)
+			return
+		}
+
+		// время использования не критично, ошибка записи не должна отклонять запрос
+		_ = m.keys.TouchAPIKey(r.Context(), apiKey.ID)
+
+		scopes := make([]Permission, 0, len(apiKey.Scopes))
+		for _, scope := range apiKey.Scopes {
+			scopes = append(scopes, Permission(scope))
+		}
+		claims := &Claims{
+			Username:     user.Login,
+			MerchantID:   user.MerchantID,
+			Role:         user.Role,
+			TokenVersion: user.TokenVersion,
+			APIKeyID:     apiKey.ID,
+			Scopes:       scopes,
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey, claims)))
+	})
+}
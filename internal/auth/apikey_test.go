@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wellywell/bonusy/internal/auth/mocks"
+	"github.com/wellywell/bonusy/internal/ratelimit"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func TestParseAPIKey(t *testing.T) {
+
+	key, prefix, err := GenerateAPIKey()
+	assert.NoError(t, err)
+
+	parsed, ok := ParseAPIKey(key)
+	assert.True(t, ok)
+	assert.Equal(t, prefix, parsed)
+
+	for _, bad := range []string{"", prefix, "bk_" + prefix, "xx_" + key[3:], key[:len(key)-1]} {
+		_, ok := ParseAPIKey(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		role    types.UserRole
+		scopes  []string
+		wantErr bool
+	}{
+		{"account", types.RoleUser, []string{"account:read", "orders:write"}, false},
+		{"withdrawals not for keys", types.RoleUser, []string{"account:write"}, true},
+		{"empty", types.RoleUser, nil, true},
+		{"unknown", types.RoleUser, []string{"everything"}, true},
+		{"not for keys", types.RoleAdmin, []string{"users:write"}, true},
+		{"no role", "", []string{"account:read"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateScopes(tt.role, tt.scopes)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidScope)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+
+	key, prefix, err := GenerateAPIKey()
+	assert.NoError(t, err)
+	userID := 5
+	userKey := &types.APIKey{ID: 1, MerchantID: 1, UserID: &userID, Hash: HashAPIKey(key), Scopes: []string{"orders:write"}, RateLimit: 10, Login: "user"}
+	merchantKey := &types.APIKey{ID: 2, MerchantID: 1, Hash: HashAPIKey(key), Scopes: []string{"orders:write"}, RateLimit: 10}
+	wrongKey := key[:len(key)-1] + "0"
+	if wrongKey == key {
+		wrongKey = key[:len(key)-1] + "1"
+	}
+	active := &types.User{ID: 5, Login: "user", MerchantID: 1, Role: types.RoleUser, Status: types.UserActive}
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		onBehalfOf string
+		apiKey     *types.APIKey
+		keyErr     error
+		user       *types.User
+		wantStatus int
+	}{
+		{"no key", "", "", "", nil, nil, nil, http.StatusNoContent},
+		{"user key", "X-API-Key", key, "", userKey, nil, active, http.StatusOK},
+		{"bearer", "Authorization", "Bearer " + key, "", userKey, nil, active, http.StatusOK},
+		{"merchant key", "X-API-Key", key, "User", merchantKey, nil, active, http.StatusOK},
+		{"merchant key without user", "X-API-Key", key, "", merchantKey, nil, nil, http.StatusBadRequest},
+		{"malformed", "X-API-Key", "bk_123", "", nil, nil, nil, http.StatusUnauthorized},
+		{"revoked", "X-API-Key", key, "", nil, fmt.Errorf("not found"), nil, http.StatusUnauthorized},
+		{"wrong secret", "X-API-Key", wrongKey, "", userKey, nil, nil, http.StatusUnauthorized},
+		{"locked user", "X-API-Key", key, "", userKey, nil, &types.User{ID: 5, Status: types.UserLocked}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := mocks.NewAPIKeyStore(t)
+			users := mocks.NewUserStore(t)
+			if tt.apiKey != nil || tt.keyErr != nil {
+				keys.EXPECT().GetAPIKey(mock.Anything, prefix).Return(tt.apiKey, tt.keyErr).Once()
+			}
+			if tt.user != nil {
+				users.EXPECT().GetUser(mock.Anything, 1, "user").Return(tt.user, nil).Once()
+			}
+			if tt.user != nil && tt.user.Status == types.UserActive {
+				keys.EXPECT().TouchAPIKey(mock.Anything, tt.apiKey.ID).Return(nil).Once()
+			}
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, ok := GetAuthenticatedClaims(r)
+				if !ok {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				assert.Equal(t, "user", claims.Username)
+				assert.Equal(t, []Permission{PermOrdersWrite}, claims.Scopes)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			if tt.onBehalfOf != "" {
+				req.Header.Set(OnBehalfOfHeader, tt.onBehalfOf)
+			}
+			w := httptest.NewRecorder()
+
+			NewAPIKeyMiddleware(keys, users, ratelimit.NewLimiter(ratelimit.NewMemoryStore())).Handle(next).ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+
+	key, prefix, err := GenerateAPIKey()
+	assert.NoError(t, err)
+	userID := 5
+	apiKey := &types.APIKey{ID: 1, MerchantID: 1, UserID: &userID, Hash: HashAPIKey(key), Scopes: []string{"account:read"}, RateLimit: 1, Login: "user"}
+
+	keys := mocks.NewAPIKeyStore(t)
+	users := mocks.NewUserStore(t)
+	keys.EXPECT().GetAPIKey(mock.Anything, prefix).Return(apiKey, nil).Twice()
+	users.EXPECT().GetUser(mock.Anything, 1, "user").Return(&types.User{ID: 5, Login: "user", Status: types.UserActive}, nil).Once()
+	keys.EXPECT().TouchAPIKey(mock.Anything, 1).Return(nil).Once()
+
+	handler := NewAPIKeyMiddleware(keys, users, ratelimit.NewLimiter(ratelimit.NewMemoryStore())).Handle(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code)
+	}
+}
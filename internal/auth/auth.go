@@ -1,15 +1,201 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+type HashAlgorithm string
+
+const (
+	HashBcrypt   HashAlgorithm = "bcrypt"
+	HashArgon2id HashAlgorithm = "argon2id"
+)
+
+var (
+	ErrHasherBusy       = errors.New("too many password hashing requests")
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrMalformedHash    = errors.New("malformed password hash")
+)
+
+const (
+	argonSaltLen = 16
+	argonKeyLen  = 32
+)
+
+// HashPolicy - алгоритм и параметры хеширования паролей
+type HashPolicy struct {
+	Algorithm  HashAlgorithm
+	BcryptCost int
+
+	ArgonTime      uint32
+	ArgonMemoryKiB uint32
+	ArgonThreads   uint8
+
+	// MaxConcurrent - сколько хешей считается одновременно, остальные ждут не дольше QueueTimeout
+	MaxConcurrent int
+	QueueTimeout  time.Duration
+}
+
+// Hasher хеширует и проверяет пароли, ограничивая число одновременных вычислений
+type Hasher struct {
+	policy HashPolicy
+	slots  chan struct{}
+
+	dummyHash     string
+	dummyHashErr  error
+	dummyHashOnce sync.Once
+}
+
+func NewHasher(policy HashPolicy) (*Hasher, error) {
+	switch policy.Algorithm {
+	case HashBcrypt:
+		if policy.BcryptCost < bcrypt.MinCost || policy.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case HashArgon2id:
+		if policy.ArgonTime == 0 || policy.ArgonMemoryKiB == 0 || policy.ArgonThreads == 0 {
+			return nil, fmt.Errorf("argon2id time, memory and threads must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, policy.Algorithm)
+	}
+	if policy.MaxConcurrent < 1 {
+		return nil, fmt.Errorf("max concurrent hashing must be positive")
+	}
+	return &Hasher{policy: policy, slots: make(chan struct{}, policy.MaxConcurrent)}, nil
+}
+
+// acquire занимает слот для вычисления; если слота нет дольше QueueTimeout - ErrHasherBusy
+func (h *Hasher) acquire(ctx context.Context) (func(), error) {
+	timer := time.NewTimer(h.policy.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case h.slots <- struct{}{}:
+		return func() { <-h.slots }, nil
+	case <-timer.C:
+		return nil, ErrHasherBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (h *Hasher) Hash(ctx context.Context, password string) (string, error) {
+	release, err := h.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return h.hash(password)
 }
 
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+func (h *Hasher) hash(password string) (string, error) {
+	if h.policy.Algorithm == HashBcrypt {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.policy.BcryptCost)
+		return string(bytes), err
+	}
+
+	salt := make([]byte, argonSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.policy.ArgonTime, h.policy.ArgonMemoryKiB, h.policy.ArgonThreads, argonKeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		h.policy.ArgonMemoryKiB, h.policy.ArgonTime, h.policy.ArgonThreads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify проверяет пароль по хешу любого поддерживаемого алгоритма.
+// rehash - хеш посчитан не с текущими параметрами и после успешного входа его стоит пересчитать
+func (h *Hasher) Verify(ctx context.Context, password string, hash string) (ok bool, rehash bool, err error) {
+	release, err := h.acquire(ctx)
+	if err != nil {
+		return false, false, err
+	}
+	defer release()
+
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+		actual := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return false, false, nil
+		}
+		current := h.policy.Algorithm == HashArgon2id && params.time == h.policy.ArgonTime &&
+			params.memory == h.policy.ArgonMemoryKiB && params.threads == h.policy.ArgonThreads
+		return true, !current, nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		return false, false, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+	// хеш с большей стоимостью не пересчитываем, чтобы снижение настройки не ослабляло уже сохранённые хеши
+	return true, h.policy.Algorithm != HashBcrypt || cost < h.policy.BcryptCost, nil
+}
+
+// VerifyDummy тратит на проверку столько же времени, сколько Verify,
+// чтобы по времени ответа нельзя было понять, существует ли пользователь
+func (h *Hasher) VerifyDummy(ctx context.Context, password string) error {
+	h.dummyHashOnce.Do(func() {
+		h.dummyHash, h.dummyHashErr = h.hash("dummy password")
+	})
+	if h.dummyHashErr != nil {
+		return h.dummyHashErr
+	}
+	_, _, err := h.Verify(ctx, password, h.dummyHash)
+	return err
+}
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// parseArgon2id разбирает хеш в формате PHC: $argon2id$v=19$m=65536,t=1,p=2$<соль>$<ключ>
+func parseArgon2id(hash string) (*argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrMalformedHash
+	}
+
+	var params argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrMalformedHash
+	}
+	return &params, salt, key, nil
 }
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testBcrypt = HashPolicy{Algorithm: HashBcrypt, BcryptCost: 4, MaxConcurrent: 2, QueueTimeout: time.Second}
+	testArgon  = HashPolicy{Algorithm: HashArgon2id, ArgonTime: 1, ArgonMemoryKiB: 64, ArgonThreads: 1, MaxConcurrent: 2, QueueTimeout: time.Second}
+)
+
+func TestHasherVerify(t *testing.T) {
+
+	bcryptHasher, err := NewHasher(testBcrypt)
+	assert.NoError(t, err)
+	argonHasher, err := NewHasher(testArgon)
+	assert.NoError(t, err)
+	strongerArgon := testArgon
+	strongerArgon.ArgonTime = 2
+	strongerArgonHasher, err := NewHasher(strongerArgon)
+	assert.NoError(t, err)
+	strongerBcrypt := testBcrypt
+	strongerBcrypt.BcryptCost = 5
+	strongerBcryptHasher, err := NewHasher(strongerBcrypt)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	bcryptHash, err := bcryptHasher.Hash(ctx, "passw")
+	assert.NoError(t, err)
+	strongerBcryptHash, err := strongerBcryptHasher.Hash(ctx, "passw")
+	assert.NoError(t, err)
+	argonHash, err := argonHasher.Hash(ctx, "passw")
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=64,t=1,p=1\$[^$]+\$[^$]+$`, argonHash)
+
+	tests := []struct {
+		name       string
+		hasher     *Hasher
+		password   string
+		hash       string
+		wantOK     bool
+		wantRehash bool
+		wantErr    bool
+	}{
+		{"bcrypt", bcryptHasher, "passw", bcryptHash, true, false, false},
+		{"bcrypt wrong password", bcryptHasher, "other", bcryptHash, false, false, false},
+		{"argon2id", argonHasher, "passw", argonHash, true, false, false},
+		{"argon2id wrong password", argonHasher, "other", argonHash, false, false, false},
+		{"bcrypt to argon2id", argonHasher, "passw", bcryptHash, true, true, false},
+		{"argon2id to bcrypt", bcryptHasher, "passw", argonHash, true, true, false},
+		{"argon2id params changed", strongerArgonHasher, "passw", argonHash, true, true, false},
+		{"bcrypt cost raised", strongerBcryptHasher, "passw", bcryptHash, true, true, false},
+		{"bcrypt cost lowered", bcryptHasher, "passw", strongerBcryptHash, true, false, false},
+		{"malformed", argonHasher, "passw", "$argon2id$v=19$m=64$abc", false, false, true},
+		{"empty hash", bcryptHasher, "passw", "", false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := tt.hasher.Verify(ctx, tt.password, tt.hash)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrMalformedHash)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantRehash, rehash)
+		})
+	}
+}
+
+func TestHasherBusy(t *testing.T) {
+
+	policy := testBcrypt
+	policy.MaxConcurrent = 1
+	policy.QueueTimeout = 10 * time.Millisecond
+	hasher, err := NewHasher(policy)
+	assert.NoError(t, err)
+
+	release, err := hasher.acquire(context.Background())
+	assert.NoError(t, err)
+
+	_, err = hasher.Hash(context.Background(), "passw")
+	assert.ErrorIs(t, err, ErrHasherBusy)
+
+	release()
+	_, err = hasher.Hash(context.Background(), "passw")
+	assert.NoError(t, err)
+}
+
+func TestNewHasherInvalid(t *testing.T) {
+
+	_, err := NewHasher(HashPolicy{Algorithm: "md5", MaxConcurrent: 1})
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+
+	_, err = NewHasher(HashPolicy{Algorithm: HashBcrypt, BcryptCost: 100, MaxConcurrent: 1})
+	assert.Error(t, err)
+
+	_, err = NewHasher(HashPolicy{Algorithm: HashArgon2id, MaxConcurrent: 1})
+	assert.Error(t, err)
+}
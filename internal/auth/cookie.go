@@ -2,29 +2,56 @@ package auth
 
 import (
 	"net/http"
+
+	"github.com/wellywell/bonusy/internal/types"
 )
 
 const userCookie = "_user"
 
-func VerifyUser(r *http.Request, secret []byte) (string, error) {
+// CookieOptions - атрибуты авторизационной куки; HttpOnly и Path=/ выставляются всегда
+type CookieOptions struct {
+	MaxAge   int
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+}
+
+func VerifyUser(r *http.Request, secret []byte) (*Claims, error) {
 	cookie, err := r.Cookie(userCookie)
 	if err == nil {
-		user, err := GetUser(cookie.Value, secret)
+		claims, err := GetClaims(cookie.Value, secret)
 		if err != nil {
-			return user, err
+			return nil, err
 		}
-		return user, nil
+		return claims, nil
+	}
+	return nil, err
+}
+
+func (o CookieOptions) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     userCookie,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     "/",
+		Domain:   o.Domain,
+		Secure:   o.Secure,
+		HttpOnly: true,
+		SameSite: o.SameSite,
 	}
-	return "", err
 }
 
-func SetAuthCookie(username string, w http.ResponseWriter, secret []byte, TTLSeconds int) error {
+func SetAuthCookie(user *types.User, sessionID int, w http.ResponseWriter, secret []byte, opts CookieOptions) error {
 
-	token, err := BuildJWTString(username, secret)
+	token, err := BuildJWTString(user, sessionID, secret)
 	if err != nil {
 		return err
 	}
-	cookie := &http.Cookie{Name: userCookie, Value: token, MaxAge: TTLSeconds}
-	http.SetCookie(w, cookie)
+	http.SetCookie(w, opts.cookie(token, opts.MaxAge))
 	return nil
 }
+
+// ClearAuthCookie удаляет куку; атрибуты должны совпадать с выданной, иначе браузер её не заменит
+func ClearAuthCookie(w http.ResponseWriter, opts CookieOptions) {
+	http.SetCookie(w, opts.cookie("", -1))
+}
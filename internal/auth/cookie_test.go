@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func TestSetAuthCookie(t *testing.T) {
+
+	opts := CookieOptions{MaxAge: 60, Secure: true, SameSite: http.SameSiteLaxMode, Domain: "example.com"}
+
+	w := httptest.NewRecorder()
+	err := SetAuthCookie(&types.User{Login: "user", MerchantID: 1}, 3, w, []byte("secret"), opts)
+	assert.NoError(t, err)
+
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, userCookie, cookie.Name)
+	assert.Equal(t, 60, cookie.MaxAge)
+	assert.Equal(t, "/", cookie.Path)
+	assert.Equal(t, "example.com", cookie.Domain)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	claims, err := VerifyUser(req, []byte("secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, claims.SessionID)
+
+	w = httptest.NewRecorder()
+	ClearAuthCookie(w, opts)
+	cleared := w.Result().Cookies()[0]
+	assert.Equal(t, "", cleared.Value)
+	assert.Equal(t, -1, cleared.MaxAge)
+	assert.Equal(t, "/", cleared.Path)
+	assert.True(t, cleared.Secure)
+}
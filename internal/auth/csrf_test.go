@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+
+	middleware := CSRFMiddleware{TrustedOrigins: []string{"https://shop.example.com/"}}
+
+	tests := []struct {
+		name       string
+		method     string
+		cookie     bool
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"safe method", http.MethodGet, true, map[string]string{"Origin": "https://evil.com"}, http.StatusOK},
+		{"no cookie", http.MethodPost, false, map[string]string{"Origin": "https://evil.com"}, http.StatusOK},
+		{"not a browser", http.MethodPost, true, nil, http.StatusOK},
+		{"same host", http.MethodPost, true, map[string]string{"Origin": "https://api.example.com"}, http.StatusOK},
+		{"trusted origin", http.MethodDelete, true, map[string]string{"Origin": "https://shop.example.com"}, http.StatusOK},
+		{"trusted origin other scheme", http.MethodPost, true, map[string]string{"Origin": "http://shop.example.com"}, http.StatusForbidden},
+		{"cross site origin", http.MethodPost, true, map[string]string{"Origin": "https://evil.com"}, http.StatusForbidden},
+		{"null origin", http.MethodPost, true, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"cross site referer", http.MethodPost, true, map[string]string{"Referer": "https://evil.com/page"}, http.StatusForbidden},
+		{"same site referer", http.MethodPost, true, map[string]string{"Referer": "https://api.example.com/page"}, http.StatusOK},
+		{"fetch metadata cross site", http.MethodPost, true, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"fetch metadata same origin", http.MethodPost, true, map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(tt.method, "https://api.example.com/api/user/balance/withdraw", nil)
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: userCookie, Value: "token"})
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			middleware.Handle(next).ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
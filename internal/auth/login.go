@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrInvalidLogin = errors.New("invalid login")
+
+const (
+	MinLoginLength = 3
+	MaxLoginLength = 64
+)
+
+// NormalizeLogin приводит логин к каноническому виду, чтобы "Alice" и " alice" были одним пользователем
+func NormalizeLogin(login string) string {
+	login = strings.TrimSpace(norm.NFKC.String(login))
+	// после приведения регистра строка может перестать быть нормализованной
+	return norm.NFKC.String(cases.Fold().String(login))
+}
+
+// ValidateLogin проверяет нормализованный логин нового пользователя: буквы, цифры и . _ - @
+func ValidateLogin(login string) error {
+	length := utf8.RuneCountInString(login)
+	if length < MinLoginLength || length > MaxLoginLength {
+		return fmt.Errorf("%w: must be %d to %d characters", ErrInvalidLogin, MinLoginLength, MaxLoginLength)
+	}
+	for _, r := range login {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-@", r) {
+			continue
+		}
+		return fmt.Errorf("%w: may contain only letters, digits and . _ - @", ErrInvalidLogin)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeLogin(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		login string
+		want  string
+	}{
+		{"already normal", "alice", "alice"},
+		{"case", "Alice", "alice"},
+		{"spaces", "  alice \t", "alice"},
+		{"fullwidth", "ａｌｉｃｅ", "alice"},
+		{"ideographic space", "　alice", "alice"},
+		{"ligature", "ﬁona", "fiona"},
+		{"sharp s", "Straße", "strasse"},
+		{"cyrillic", "Вася", "вася"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, NormalizeLogin(tt.login))
+		})
+	}
+}
+
+func TestValidateLogin(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		login   string
+		wantErr bool
+	}{
+		{"ok", "alice", false},
+		{"email", "alice.smith@example.com", false},
+		{"unicode", "вася_1", false},
+		{"too short", "al", true},
+		{"too long", strings.Repeat("a", MaxLoginLength+1), true},
+		{"space inside", "alice smith", true},
+		{"control", "alice\x00", true},
+		{"symbols", "alice<script>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLogin(tt.login)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidLogin)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -3,27 +3,61 @@ package auth
 import (
 	"context"
 	"net/http"
+
+	"github.com/wellywell/bonusy/internal/types"
 )
 
+type UserStore interface {
+	GetUser(ctx context.Context, merchantID int, username string) (*types.User, error)
+	TouchSession(ctx context.Context, sessionID int, userID int) error
+}
+
 type AuthenticateMiddleware struct {
 	Secret []byte
+	// Users - откуда брать статус пользователя и сессии; токен заблокированного или удалённого пользователя,
+	// выданный до смены пароля или для отозванной сессии, не принимается
+	Users UserStore
 }
 
 type key string
 
-const contextKey key = "username"
+const contextKey key = "claims"
 
 func (m AuthenticateMiddleware) Handle(next http.Handler) http.Handler {
 
 	authenticate := func(w http.ResponseWriter, r *http.Request) {
 
-		user, err := VerifyUser(r, m.Secret)
+		// запрос уже аутентифицирован по API ключу
+		if _, ok := GetAuthenticatedClaims(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := VerifyUser(r, m.Secret)
 		if err != nil {
 			http.Error(w, "User not authenticated", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), contextKey, user)
+		if m.Users != nil {
+			user, err := m.Users.GetUser(r.Context(), claims.MerchantID, NormalizeLogin(claims.Username))
+			if err != nil || user.Status == types.UserDeleted || user.TokenVersion != claims.TokenVersion {
+				http.Error(w, "User not authenticated", http.StatusUnauthorized)
+				return
+			}
+			if user.Status != types.UserActive {
+				http.Error(w, "User is locked", http.StatusForbidden)
+				return
+			}
+			if err := m.Users.TouchSession(r.Context(), claims.SessionID, user.ID); err != nil {
+				http.Error(w, "User not authenticated", http.StatusUnauthorized)
+				return
+			}
+			// токен мог быть выдан до нормализации логина
+			claims.Username = user.Login
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey, claims)
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 
@@ -32,7 +66,14 @@ func (m AuthenticateMiddleware) Handle(next http.Handler) http.Handler {
 }
 
 func GetAuthenticatedUser(req *http.Request) (string, bool) {
-	user, ok := req.Context().Value(contextKey).(string)
-	return user, ok
+	claims, ok := GetAuthenticatedClaims(req)
+	if !ok {
+		return "", false
+	}
+	return claims.Username, true
+}
 
+func GetAuthenticatedClaims(req *http.Request) (*Claims, bool) {
+	claims, ok := req.Context().Value(contextKey).(*Claims)
+	return claims, ok
 }
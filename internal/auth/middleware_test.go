@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wellywell/bonusy/internal/auth/mocks"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func TestAuthenticateMiddleware(t *testing.T) {
+
+	secret := []byte("secret")
+
+	tests := []struct {
+		name       string
+		user       *types.User
+		err        error
+		sessionErr error
+		wantStatus int
+	}{
+		{"active", &types.User{ID: 5, Status: types.UserActive}, nil, nil, http.StatusOK},
+		{"locked", &types.User{ID: 5, Status: types.UserLocked}, nil, nil, http.StatusForbidden},
+		{"deleted", &types.User{ID: 5, Status: types.UserDeleted}, nil, nil, http.StatusUnauthorized},
+		{"not found", nil, fmt.Errorf("not found"), nil, http.StatusUnauthorized},
+		{"password changed", &types.User{ID: 5, Status: types.UserActive, TokenVersion: 1}, nil, nil, http.StatusUnauthorized},
+		{"session revoked", &types.User{ID: 5, Status: types.UserActive}, nil, fmt.Errorf("revoked"), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := mocks.NewUserStore(t)
+			users.EXPECT().GetUser(mock.Anything, 1, "user").Return(tt.user, tt.err).Once()
+			if tt.user != nil && tt.user.Status == types.UserActive && tt.user.TokenVersion == 0 {
+				users.EXPECT().TouchSession(mock.Anything, 3, 5).Return(tt.sessionErr).Once()
+			}
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			token, err := BuildJWTString(&types.User{Login: "user", MerchantID: 1, Role: types.RoleUser}, 3, secret)
+			assert.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: userCookie, Value: token})
+			w := httptest.NewRecorder()
+
+			AuthenticateMiddleware{Secret: secret, Users: users}.Handle(next).ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
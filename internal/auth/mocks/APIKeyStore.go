@@ -0,0 +1,143 @@
+// Code generated by mockery v2.43.2. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+	types "github.com/wellywell/bonusy/internal/types"
+)
+
+// APIKeyStore is an autogenerated mock type for the APIKeyStore type
+type APIKeyStore struct {
+	mock.Mock
+}
+
+type APIKeyStore_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *APIKeyStore) EXPECT() *APIKeyStore_Expecter {
+	return &APIKeyStore_Expecter{mock: &_m.Mock}
+}
+
+// GetAPIKey provides a mock function with given fields: ctx, prefix
+func (_m *APIKeyStore) GetAPIKey(ctx context.Context, prefix string) (*types.APIKey, error) {
+	ret := _m.Called(ctx, prefix)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetAPIKey")
+	}
+
+	var r0 *types.APIKey
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*types.APIKey, error)); ok {
+		return rf(ctx, prefix)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *types.APIKey); ok {
+		r0 = rf(ctx, prefix)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*types.APIKey)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, prefix)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// APIKeyStore_GetAPIKey_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetAPIKey'
+type APIKeyStore_GetAPIKey_Call struct {
+	*mock.Call
+}
+
+// GetAPIKey is a helper method to define mock.On call
+//   - ctx context.Context
+//   - prefix string
+func (_e *APIKeyStore_Expecter) GetAPIKey(ctx interface{}, prefix interface{}) *APIKeyStore_GetAPIKey_Call {
+	return &APIKeyStore_GetAPIKey_Call{Call: _e.mock.On("GetAPIKey", ctx, prefix)}
+}
+
+func (_c *APIKeyStore_GetAPIKey_Call) Run(run func(ctx context.Context, prefix string)) *APIKeyStore_GetAPIKey_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string))
+	})
+	return _c
+}
+
+func (_c *APIKeyStore_GetAPIKey_Call) Return(_a0 *types.APIKey, _a1 error) *APIKeyStore_GetAPIKey_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *APIKeyStore_GetAPIKey_Call) RunAndReturn(run func(context.Context, string) (*types.APIKey, error)) *APIKeyStore_GetAPIKey_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// TouchAPIKey provides a mock function with given fields: ctx, keyID
+func (_m *APIKeyStore) TouchAPIKey(ctx context.Context, keyID int) error {
+	ret := _m.Called(ctx, keyID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TouchAPIKey")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, keyID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// APIKeyStore_TouchAPIKey_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'TouchAPIKey'
+type APIKeyStore_TouchAPIKey_Call struct {
+	*mock.Call
+}
+
+// TouchAPIKey is a helper method to define mock.On call
+//   - ctx context.Context
+//   - keyID int
+func (_e *APIKeyStore_Expecter) TouchAPIKey(ctx interface{}, keyID interface{}) *APIKeyStore_TouchAPIKey_Call {
+	return &APIKeyStore_TouchAPIKey_Call{Call: _e.mock.On("TouchAPIKey", ctx, keyID)}
+}
+
+func (_c *APIKeyStore_TouchAPIKey_Call) Run(run func(ctx context.Context, keyID int)) *APIKeyStore_TouchAPIKey_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int))
+	})
+	return _c
+}
+
+func (_c *APIKeyStore_TouchAPIKey_Call) Return(_a0 error) *APIKeyStore_TouchAPIKey_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *APIKeyStore_TouchAPIKey_Call) RunAndReturn(run func(context.Context, int) error) *APIKeyStore_TouchAPIKey_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewAPIKeyStore creates a new instance of APIKeyStore. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewAPIKeyStore(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *APIKeyStore {
+	mock := &APIKeyStore{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
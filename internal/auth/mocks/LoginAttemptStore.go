@@ -0,0 +1,151 @@
+// Code generated by mockery v2.43.2. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+	time "time"
+
+	mock "github.com/stretchr/testify/mock"
+
+	types "github.com/wellywell/bonusy/internal/types"
+)
+
+// LoginAttemptStore is an autogenerated mock type for the LoginAttemptStore type
+type LoginAttemptStore struct {
+	mock.Mock
+}
+
+type LoginAttemptStore_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *LoginAttemptStore) EXPECT() *LoginAttemptStore_Expecter {
+	return &LoginAttemptStore_Expecter{mock: &_m.Mock}
+}
+
+// GetLoginFailures provides a mock function with given fields: ctx, merchantID, username, ip, since
+func (_m *LoginAttemptStore) GetLoginFailures(ctx context.Context, merchantID int, username string, ip string, since time.Time) (*types.LoginFailures, error) {
+	ret := _m.Called(ctx, merchantID, username, ip, since)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetLoginFailures")
+	}
+
+	var r0 *types.LoginFailures
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, string, time.Time) (*types.LoginFailures, error)); ok {
+		return rf(ctx, merchantID, username, ip, since)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, string, time.Time) *types.LoginFailures); ok {
+		r0 = rf(ctx, merchantID, username, ip, since)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*types.LoginFailures)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, string, string, time.Time) error); ok {
+		r1 = rf(ctx, merchantID, username, ip, since)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LoginAttemptStore_GetLoginFailures_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetLoginFailures'
+type LoginAttemptStore_GetLoginFailures_Call struct {
+	*mock.Call
+}
+
+// GetLoginFailures is a helper method to define mock.On call
+//   - ctx context.Context
+//   - merchantID int
+//   - username string
+//   - ip string
+//   - since time.Time
+func (_e *LoginAttemptStore_Expecter) GetLoginFailures(ctx interface{}, merchantID interface{}, username interface{}, ip interface{}, since interface{}) *LoginAttemptStore_GetLoginFailures_Call {
+	return &LoginAttemptStore_GetLoginFailures_Call{Call: _e.mock.On("GetLoginFailures", ctx, merchantID, username, ip, since)}
+}
+
+func (_c *LoginAttemptStore_GetLoginFailures_Call) Run(run func(ctx context.Context, merchantID int, username string, ip string, since time.Time)) *LoginAttemptStore_GetLoginFailures_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int), args[2].(string), args[3].(string), args[4].(time.Time))
+	})
+	return _c
+}
+
+func (_c *LoginAttemptStore_GetLoginFailures_Call) Return(_a0 *types.LoginFailures, _a1 error) *LoginAttemptStore_GetLoginFailures_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *LoginAttemptStore_GetLoginFailures_Call) RunAndReturn(run func(context.Context, int, string, string, time.Time) (*types.LoginFailures, error)) *LoginAttemptStore_GetLoginFailures_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RecordLoginAttempt provides a mock function with given fields: ctx, merchantID, username, ip, success
+func (_m *LoginAttemptStore) RecordLoginAttempt(ctx context.Context, merchantID int, username string, ip string, success bool) error {
+	ret := _m.Called(ctx, merchantID, username, ip, success)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RecordLoginAttempt")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, string, bool) error); ok {
+		r0 = rf(ctx, merchantID, username, ip, success)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LoginAttemptStore_RecordLoginAttempt_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RecordLoginAttempt'
+type LoginAttemptStore_RecordLoginAttempt_Call struct {
+	*mock.Call
+}
+
+// RecordLoginAttempt is a helper method to define mock.On call
+//   - ctx context.Context
+//   - merchantID int
+//   - username string
+//   - ip string
+//   - success bool
+func (_e *LoginAttemptStore_Expecter) RecordLoginAttempt(ctx interface{}, merchantID interface{}, username interface{}, ip interface{}, success interface{}) *LoginAttemptStore_RecordLoginAttempt_Call {
+	return &LoginAttemptStore_RecordLoginAttempt_Call{Call: _e.mock.On("RecordLoginAttempt", ctx, merchantID, username, ip, success)}
+}
+
+func (_c *LoginAttemptStore_RecordLoginAttempt_Call) Run(run func(ctx context.Context, merchantID int, username string, ip string, success bool)) *LoginAttemptStore_RecordLoginAttempt_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int), args[2].(string), args[3].(string), args[4].(bool))
+	})
+	return _c
+}
+
+func (_c *LoginAttemptStore_RecordLoginAttempt_Call) Return(_a0 error) *LoginAttemptStore_RecordLoginAttempt_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *LoginAttemptStore_RecordLoginAttempt_Call) RunAndReturn(run func(context.Context, int, string, string, bool) error) *LoginAttemptStore_RecordLoginAttempt_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewLoginAttemptStore creates a new instance of LoginAttemptStore. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewLoginAttemptStore(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *LoginAttemptStore {
+	mock := &LoginAttemptStore{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,145 @@
+// Code generated by mockery v2.43.2. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+	types "github.com/wellywell/bonusy/internal/types"
+)
+
+// UserStore is an autogenerated mock type for the UserStore type
+type UserStore struct {
+	mock.Mock
+}
+
+type UserStore_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *UserStore) EXPECT() *UserStore_Expecter {
+	return &UserStore_Expecter{mock: &_m.Mock}
+}
+
+// GetUser provides a mock function with given fields: ctx, merchantID, username
+func (_m *UserStore) GetUser(ctx context.Context, merchantID int, username string) (*types.User, error) {
+	ret := _m.Called(ctx, merchantID, username)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetUser")
+	}
+
+	var r0 *types.User
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string) (*types.User, error)); ok {
+		return rf(ctx, merchantID, username)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, string) *types.User); ok {
+		r0 = rf(ctx, merchantID, username)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*types.User)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, string) error); ok {
+		r1 = rf(ctx, merchantID, username)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UserStore_GetUser_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetUser'
+type UserStore_GetUser_Call struct {
+	*mock.Call
+}
+
+// GetUser is a helper method to define mock.On call
+//   - ctx context.Context
+//   - merchantID int
+//   - username string
+func (_e *UserStore_Expecter) GetUser(ctx interface{}, merchantID interface{}, username interface{}) *UserStore_GetUser_Call {
+	return &UserStore_GetUser_Call{Call: _e.mock.On("GetUser", ctx, merchantID, username)}
+}
+
+func (_c *UserStore_GetUser_Call) Run(run func(ctx context.Context, merchantID int, username string)) *UserStore_GetUser_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *UserStore_GetUser_Call) Return(_a0 *types.User, _a1 error) *UserStore_GetUser_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *UserStore_GetUser_Call) RunAndReturn(run func(context.Context, int, string) (*types.User, error)) *UserStore_GetUser_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// TouchSession provides a mock function with given fields: ctx, sessionID, userID
+func (_m *UserStore) TouchSession(ctx context.Context, sessionID int, userID int) error {
+	ret := _m.Called(ctx, sessionID, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for TouchSession")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) error); ok {
+		r0 = rf(ctx, sessionID, userID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UserStore_TouchSession_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'TouchSession'
+type UserStore_TouchSession_Call struct {
+	*mock.Call
+}
+
+// TouchSession is a helper method to define mock.On call
+//   - ctx context.Context
+//   - sessionID int
+//   - userID int
+func (_e *UserStore_Expecter) TouchSession(ctx interface{}, sessionID interface{}, userID interface{}) *UserStore_TouchSession_Call {
+	return &UserStore_TouchSession_Call{Call: _e.mock.On("TouchSession", ctx, sessionID, userID)}
+}
+
+func (_c *UserStore_TouchSession_Call) Run(run func(ctx context.Context, sessionID int, userID int)) *UserStore_TouchSession_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int), args[2].(int))
+	})
+	return _c
+}
+
+func (_c *UserStore_TouchSession_Call) Return(_a0 error) *UserStore_TouchSession_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *UserStore_TouchSession_Call) RunAndReturn(run func(context.Context, int, int) error) *UserStore_TouchSession_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewUserStore creates a new instance of UserStore. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewUserStore(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *UserStore {
+	mock := &UserStore{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var ErrWeakPassword = errors.New("weak password")
+
+// bcrypt не учитывает байты после 72-го
+const maxPasswordBytes = 72
+
+// PasswordPolicy - требования к новым паролям
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses - сколько разных классов символов нужно: строчные, заглавные, цифры, остальные
+	MinClasses int
+}
+
+// Validate проверяет пароль; в ошибке - понятная пользователю причина
+func (p PasswordPolicy) Validate(login string, password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: must be at most %d bytes", ErrWeakPassword, maxPasswordBytes)
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		return fmt.Errorf("%w: must contain at least %d of lowercase, uppercase, digits and symbols", ErrWeakPassword, p.MinClasses)
+	}
+	if login != "" && strings.EqualFold(login, password) {
+		return fmt.Errorf("%w: must differ from login", ErrWeakPassword)
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			classes++
+		}
+	}
+	return classes
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+
+	policy := PasswordPolicy{MinLength: 8, MinClasses: 2}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		wantErr  bool
+	}{
+		{"ok", "user", "correct horse 1", false},
+		{"too short", "user", "ab1", true},
+		{"one class", "user", "abcdefghij", true},
+		{"unicode letters", "user", "пароль-длинный", false},
+		{"same as login", "Longlogin1", "longlogin1", true},
+		{"too long", "user", "a1234567890123456789012345678901234567890123456789012345678901234567890123", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.login, tt.password)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrWeakPassword)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+type Permission string
+
+const (
+	// PermAccountRead - просмотр своего баланса, заказов и списаний
+	PermAccountRead Permission = "account:read"
+	// PermAccountWrite - списания, переводы и управление своей учётной записью
+	PermAccountWrite Permission = "account:write"
+	// PermOrdersWrite - загрузка своих заказов
+	PermOrdersWrite Permission = "orders:write"
+	// PermUsersRead - просмотр чужих пользователей и журнала действий над ними
+	PermUsersRead Permission = "users:read"
+	// PermUsersWrite - блокировка и разблокировка пользователей
+	PermUsersWrite Permission = "users:write"
+	// PermBalanceAdjust - ручная корректировка баланса
+	PermBalanceAdjust Permission = "balance:adjust"
+	// PermOrdersManage - перепроверка и передача заказов
+	PermOrdersManage Permission = "orders:manage"
+	// PermRolesManage - назначение ролей
+	PermRolesManage Permission = "roles:manage"
+	// PermAPIKeysManage - выпуск и отзыв ключей магазина
+	PermAPIKeysManage Permission = "api_keys:manage"
+)
+
+var rolePermissions = map[types.UserRole][]Permission{
+	types.RoleUser: {PermAccountRead, PermAccountWrite, PermOrdersWrite},
+	// поддержка видит пользователей, но ничего не меняет
+	types.RoleSupport: {PermAccountRead, PermAccountWrite, PermOrdersWrite, PermUsersRead},
+	types.RoleAdmin: {PermAccountRead, PermAccountWrite, PermOrdersWrite, PermUsersRead, PermUsersWrite,
+		PermBalanceAdjust, PermOrdersManage, PermRolesManage, PermAPIKeysManage},
+}
+
+func HasPermission(role types.UserRole, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission пропускает запрос, только если роль из токена даёт все перечисленные права,
+// а для запроса по API ключу они ещё и входят в права ключа. Должен стоять после AuthenticateMiddleware
+func RequirePermission(perms ...Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetAuthenticatedClaims(r)
+			if !ok {
+				http.Error(w, "User not authenticated", http.StatusUnauthorized)
+				return
+			}
+			for _, perm := range perms {
+				if !HasPermission(claims.Role, perm) || (claims.APIKeyID != 0 && !slices.Contains(claims.Scopes, perm)) {
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func TestRequirePermission(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		claims     *Claims
+		perms      []Permission
+		wantStatus int
+	}{
+		{"not authenticated", nil, []Permission{PermAccountRead}, http.StatusUnauthorized},
+		{"user reads own account", &Claims{Role: types.RoleUser}, []Permission{PermAccountRead}, http.StatusOK},
+		{"user looks up users", &Claims{Role: types.RoleUser}, []Permission{PermUsersRead}, http.StatusForbidden},
+		{"support looks up users", &Claims{Role: types.RoleSupport}, []Permission{PermUsersRead}, http.StatusOK},
+		{"support adjusts balance", &Claims{Role: types.RoleSupport}, []Permission{PermBalanceAdjust}, http.StatusForbidden},
+		{"admin needs all", &Claims{Role: types.RoleAdmin}, []Permission{PermUsersWrite, PermOrdersManage}, http.StatusOK},
+		{"token without role", &Claims{}, []Permission{PermAccountRead}, http.StatusForbidden},
+		{"api key within scopes", &Claims{Role: types.RoleUser, APIKeyID: 1, Scopes: []Permission{PermAccountRead}}, []Permission{PermAccountRead}, http.StatusOK},
+		{"api key outside scopes", &Claims{Role: types.RoleUser, APIKeyID: 1, Scopes: []Permission{PermAccountRead}}, []Permission{PermAccountWrite}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), contextKey, tt.claims))
+			}
+			w := httptest.NewRecorder()
+
+			RequirePermission(tt.perms...)(next).ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
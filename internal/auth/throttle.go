@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// LoginPolicy - ограничения на неудачные попытки входа
+type LoginPolicy struct {
+	// MaxAttempts - сколько неудачных попыток подряд разрешено для одного логина до блокировки
+	MaxAttempts int
+	// MaxIPAttempts - сколько неудачных попыток разрешено с одного адреса за Window
+	MaxIPAttempts int
+	// Lockout - первая блокировка, каждая следующая неудачная попытка удваивает её
+	Lockout time.Duration
+	// MaxLockout - предел блокировки
+	MaxLockout time.Duration
+	// Window - за какой срок учитываются неудачные попытки
+	Window time.Duration
+}
+
+type LoginAttemptStore interface {
+	RecordLoginAttempt(ctx context.Context, merchantID int, username string, ip string, success bool) error
+	GetLoginFailures(ctx context.Context, merchantID int, username string, ip string, since time.Time) (*types.LoginFailures, error)
+}
+
+type LoginThrottle struct {
+	policy LoginPolicy
+	store  LoginAttemptStore
+	now    func() time.Time
+}
+
+func NewLoginThrottle(store LoginAttemptStore, policy LoginPolicy) *LoginThrottle {
+	return &LoginThrottle{policy: policy, store: store, now: time.Now}
+}
+
+// Check возвращает, сколько осталось ждать до следующей попытки входа, 0 - можно входить
+func (t *LoginThrottle) Check(ctx context.Context, merchantID int, username string, ip string) (time.Duration, error) {
+	now := t.now()
+	failures, err := t.store.GetLoginFailures(ctx, merchantID, username, ip, now.Add(-t.policy.Window))
+	if err != nil {
+		return 0, err
+	}
+
+	wait := t.remaining(now, failures.Account, failures.AccountLast, t.policy.MaxAttempts)
+	return max(wait, t.remaining(now, failures.IP, failures.IPLast, t.policy.MaxIPAttempts)), nil
+}
+
+func (t *LoginThrottle) Record(ctx context.Context, merchantID int, username string, ip string, success bool) error {
+	return t.store.RecordLoginAttempt(ctx, merchantID, username, ip, success)
+}
+
+func (t *LoginThrottle) remaining(now time.Time, failures int, last *time.Time, limit int) time.Duration {
+	if last == nil {
+		return 0
+	}
+	wait := last.Add(lockoutDelay(failures, limit, t.policy.Lockout, t.policy.MaxLockout)).Sub(now)
+	return max(wait, 0)
+}
+
+func lockoutDelay(failures int, limit int, base time.Duration, maxDelay time.Duration) time.Duration {
+	if limit <= 0 || failures < limit {
+		return 0
+	}
+	delay := base
+	for range failures - limit {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return min(delay, maxDelay)
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/auth/mocks"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func Test_lockoutDelay(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{"under limit", 2, 0},
+		{"limit reached", 3, 30 * time.Second},
+		{"doubles", 5, 2 * time.Minute},
+		{"capped", 20, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, lockoutDelay(tt.failures, 3, 30*time.Second, 10*time.Minute))
+		})
+	}
+}
+
+func TestLoginThrottleCheck(t *testing.T) {
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+
+	policy := LoginPolicy{MaxAttempts: 3, MaxIPAttempts: 10, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+
+	tests := []struct {
+		name     string
+		failures types.LoginFailures
+		want     time.Duration
+	}{
+		{"no failures", types.LoginFailures{}, 0},
+		{"few failures", types.LoginFailures{Account: 2, AccountLast: ago(time.Second)}, 0},
+		{"account locked", types.LoginFailures{Account: 3, AccountLast: ago(10 * time.Second)}, 50 * time.Second},
+		{"account lock expired", types.LoginFailures{Account: 3, AccountLast: ago(2 * time.Minute)}, 0},
+		{"ip locked", types.LoginFailures{Account: 1, AccountLast: ago(time.Second), IP: 11, IPLast: ago(time.Minute)}, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := mocks.NewLoginAttemptStore(t)
+			failures := tt.failures
+			store.EXPECT().GetLoginFailures(context.Background(), 1, "user", "127.0.0.1", now.Add(-time.Hour)).Return(&failures, nil).Once()
+
+			throttle := NewLoginThrottle(store, policy)
+			throttle.now = func() time.Time { return now }
+
+			wait, err := throttle.Check(context.Background(), 1, "user", "127.0.0.1")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, wait)
+		})
+	}
+}
@@ -1,22 +1,39 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/wellywell/bonusy/internal/types"
 )
 
 type Claims struct {
 	jwt.RegisteredClaims
-	Username string
+	Username   string
+	MerchantID int
+	Role       types.UserRole
+	// TokenVersion меняется при смене пароля, старые токены перестают приниматься
+	TokenVersion int
+	// SessionID - запись в user_session, по которой сессию можно отозвать
+	SessionID int
+	// APIKeyID и Scopes заполняются только для запроса по API ключу и в токен не попадают
+	APIKeyID int          `json:"-"`
+	Scopes   []Permission `json:"-"`
 }
 
-func BuildJWTString(user string, secret []byte) (string, error) {
+func BuildJWTString(user *types.User, sessionID int, secret []byte) (string, error) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{},
 
-		Username: user,
+		Username:     user.Login,
+		MerchantID:   user.MerchantID,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+		SessionID:    sessionID,
 	})
 
 	tokenString, err := token.SignedString(secret)
@@ -28,6 +45,14 @@ func BuildJWTString(user string, secret []byte) (string, error) {
 }
 
 func GetUser(tokenString string, secret []byte) (string, error) {
+	claims, err := GetClaims(tokenString, secret)
+	if err != nil {
+		return "", err
+	}
+	return claims.Username, nil
+}
+
+func GetClaims(tokenString string, secret []byte) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims,
 		func(t *jwt.Token) (interface{}, error) {
@@ -37,12 +62,118 @@ func GetUser(tokenString string, secret []byte) (string, error) {
 			return secret, nil
 		})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", fmt.Errorf("token invalid")
+		return nil, fmt.Errorf("token invalid")
 	}
 
-	return claims.Username, nil
+	return claims, nil
+}
+
+// TwoFactorClaims - промежуточный токен между вводом пароля и кода второго фактора.
+// Подписан производным ключом, поэтому вместо обычного токена не принимается
+type TwoFactorClaims struct {
+	jwt.RegisteredClaims
+	UserID     int
+	Username   string
+	MerchantID int
+}
+
+// derivedKey - отдельный ключ для каждого вида промежуточных токенов, чтобы их нельзя было подставить друг вместо друга
+func derivedKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func BuildTwoFactorToken(user *types.User, secret []byte, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, TwoFactorClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl))},
+
+		UserID:     user.ID,
+		Username:   user.Login,
+		MerchantID: user.MerchantID,
+	})
+	return token.SignedString(derivedKey(secret, "two-factor"))
+}
+
+func GetTwoFactorClaims(tokenString string, secret []byte) (*TwoFactorClaims, error) {
+	claims := &TwoFactorClaims{}
+	if err := parseDerived(tokenString, claims, derivedKey(secret, "two-factor")); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// OIDCFlowClaims - состояние входа через внешнего провайдера между редиректами
+type OIDCFlowClaims struct {
+	jwt.RegisteredClaims
+	State      string
+	Nonce      string
+	Verifier   string
+	MerchantID int
+	// LinkUserID - вход начат вошедшим пользователем, учётную запись провайдера нужно привязать к нему
+	LinkUserID int
+	// ReauthUserID - повторный вход пользователя без пароля из сессии ReauthSessionID, чтобы подтвердить действие
+	ReauthUserID    int
+	ReauthSessionID int
+}
+
+func BuildOIDCFlowToken(flow OIDCFlowClaims, secret []byte, ttl time.Duration) (string, error) {
+	flow.RegisteredClaims = jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl))}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, flow)
+	return token.SignedString(derivedKey(secret, "oidc-flow"))
+}
+
+func GetOIDCFlowClaims(tokenString string, secret []byte) (*OIDCFlowClaims, error) {
+	claims := &OIDCFlowClaims{}
+	if err := parseDerived(tokenString, claims, derivedKey(secret, "oidc-flow")); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ReauthClaims - подтверждение свежим входом у провайдера вместо пароля, действует только в той же сессии
+type ReauthClaims struct {
+	jwt.RegisteredClaims
+	UserID    int
+	SessionID int
+}
+
+func BuildReauthToken(userID int, sessionID int, secret []byte, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, ReauthClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl))},
+
+		UserID:    userID,
+		SessionID: sessionID,
+	})
+	return token.SignedString(derivedKey(secret, "reauth"))
+}
+
+func GetReauthClaims(tokenString string, secret []byte) (*ReauthClaims, error) {
+	claims := &ReauthClaims{}
+	if err := parseDerived(tokenString, claims, derivedKey(secret, "reauth")); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func parseDerived(tokenString string, claims jwt.Claims, key []byte) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return key, nil
+		})
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid {
+		return fmt.Errorf("token invalid")
+	}
+	return nil
 }
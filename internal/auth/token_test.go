@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOIDCFlowToken(t *testing.T) {
+
+	secret := []byte("secret")
+	flow := OIDCFlowClaims{State: "state", Nonce: "nonce", Verifier: "verifier", MerchantID: 1}
+
+	token, err := BuildOIDCFlowToken(flow, secret, time.Minute)
+	assert.NoError(t, err)
+
+	claims, err := GetOIDCFlowClaims(token, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, "state", claims.State)
+	assert.Equal(t, "verifier", claims.Verifier)
+
+	_, err = GetTwoFactorClaims(token, secret)
+	assert.Error(t, err)
+	_, err = GetOIDCFlowClaims(token, []byte("other"))
+	assert.Error(t, err)
+}
+
+func TestReauthToken(t *testing.T) {
+
+	secret := []byte("secret")
+
+	token, err := BuildReauthToken(1, 2, secret, time.Minute)
+	assert.NoError(t, err)
+
+	claims, err := GetReauthClaims(token, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, claims.UserID)
+	assert.Equal(t, 2, claims.SessionID)
+
+	_, err = GetOIDCFlowClaims(token, secret)
+	assert.Error(t, err)
+	_, err = GetClaims(token, secret)
+	assert.Error(t, err)
+
+	expired, err := BuildReauthToken(1, 2, secret, -time.Minute)
+	assert.NoError(t, err)
+	_, err = GetReauthClaims(expired, secret)
+	assert.Error(t, err)
+}
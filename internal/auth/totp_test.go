@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// секрет "12345678901234567890" из приложения B к RFC 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestVerifyTOTP(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		code     string
+		now      time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"rfc 59", "287082", time.Unix(59, 0), 1, true},
+		{"rfc 1111111109", "081804", time.Unix(1111111109, 0), 37037036, true},
+		{"rfc 1234567890", "005924", time.Unix(1234567890, 0), 41152263, true},
+		{"previous step accepted", "081804", time.Unix(1111111109+30, 0), 37037036, true},
+		{"two steps late", "081804", time.Unix(1111111109+60, 0), 0, false},
+		{"wrong code", "123456", time.Unix(59, 0), 0, false},
+		{"wrong length", "28708", time.Unix(59, 0), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := VerifyTOTP(rfcSecret, tt.code, tt.now)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantStep, step)
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	assert.NoError(t, err)
+	step, ok := VerifyTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, totpStep(now), step)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+
+	uri, err := url.Parse(TOTPProvisioningURI("Coffee Shop", "alice", rfcSecret))
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Coffee Shop:alice", uri.Path)
+	assert.Equal(t, rfcSecret, uri.Query().Get("secret"))
+	assert.Equal(t, "Coffee Shop", uri.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+
+	codes, err := GenerateRecoveryCodes()
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	for _, code := range codes {
+		assert.Regexp(t, `^[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}$`, code)
+		assert.True(t, IsRecoveryCode(code))
+		assert.Equal(t, HashRecoveryCode(code), HashRecoveryCode(" "+strings.ToUpper(code)))
+	}
+	assert.False(t, IsRecoveryCode("123456"))
+}
+
+func TestTwoFactorToken(t *testing.T) {
+
+	user := &types.User{ID: 7, Login: "alice", MerchantID: 1}
+	secret := []byte("secret")
+
+	token, err := BuildTwoFactorToken(user, secret, time.Minute)
+	assert.NoError(t, err)
+
+	claims, err := GetTwoFactorClaims(token, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, claims.UserID)
+	assert.Equal(t, "alice", claims.Username)
+	assert.Equal(t, 1, claims.MerchantID)
+
+	// промежуточный токен не годится как обычный и наоборот
+	_, err = GetClaims(token, secret)
+	assert.Error(t, err)
+	session, err := BuildJWTString(user, 1, secret)
+	assert.NoError(t, err)
+	_, err = GetTwoFactorClaims(session, secret)
+	assert.Error(t, err)
+
+	expired, err := BuildTwoFactorToken(user, secret, -time.Minute)
+	assert.NoError(t, err)
+	_, err = GetTwoFactorClaims(expired, secret)
+	assert.Error(t, err)
+}
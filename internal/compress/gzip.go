@@ -2,13 +2,18 @@ package compress
 
 import (
 	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
 )
 
-type RequestUngzipper struct {
-	reader *gzip.Reader
-}
+var ErrInvalidGzip = errors.New("invalid gzip body")
+
+// RequestUngzipper распаковывает тела с Content-Encoding: gzip. Размер распакованного тела
+// ограничивают следующие за ним middleware, распаковка идёт по мере чтения
+type RequestUngzipper struct{}
 
 func (u RequestUngzipper) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -18,18 +23,37 @@ func (u RequestUngzipper) Handle(next http.Handler) http.Handler {
 			return
 		}
 
-		var err error
-		if u.reader == nil {
-			u.reader, err = gzip.NewReader(r.Body)
-		} else {
-			err = u.reader.Reset(r.Body)
-		}
+		// свой reader на каждый запрос: запросы обрабатываются параллельно
+		reader, err := gzip.NewReader(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Invalid gzip body", http.StatusBadRequest)
 			return
 		}
-		r.Body = u.reader
-		defer u.reader.Close()
+		defer reader.Close()
+
+		r.Body = gzipBody{reader: reader, body: r.Body}
+		// длина сжатого тела к распакованному не относится
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+		r.Header.Del("Content-Encoding")
 		next.ServeHTTP(w, r)
 	})
 }
+
+// gzipBody отличает повреждённые сжатые данные от прочих ошибок чтения
+type gzipBody struct {
+	reader *gzip.Reader
+	body   io.ReadCloser
+}
+
+func (b gzipBody) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	if err != nil && err != io.EOF {
+		return n, fmt.Errorf("%w: %w", ErrInvalidGzip, err)
+	}
+	return n, err
+}
+
+func (b gzipBody) Close() error {
+	return b.body.Close()
+}
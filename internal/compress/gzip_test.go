@@ -0,0 +1,106 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// echo возвращает распакованное тело; превышение лимита - 413, повреждённые данные - 400
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrInvalidGzip):
+		w.WriteHeader(http.StatusBadRequest)
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		w.Write(body)
+	}
+})
+
+func limit(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestRequestUngzipper(t *testing.T) {
+
+	valid := gzipped(t, []byte(`{"login": "user"}`))
+	// 16 МБ нулей сжимаются примерно в 16 КБ
+	bomb := gzipped(t, make([]byte, 16<<20))
+	truncated := valid[:len(valid)-6]
+
+	tests := []struct {
+		name       string
+		body       []byte
+		encoding   string
+		wantStatus int
+		wantBody   string
+	}{
+		{"plain", []byte("plain"), "", http.StatusOK, "plain"},
+		{"gzip", valid, "gzip", http.StatusOK, `{"login": "user"}`},
+		{"bomb", bomb, "gzip", http.StatusRequestEntityTooLarge, ""},
+		{"not gzip", []byte("plain"), "gzip", http.StatusBadRequest, ""},
+		{"truncated", truncated, "gzip", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			w := httptest.NewRecorder()
+
+			RequestUngzipper{}.Handle(limit(1<<20, echo)).ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequestUngzipperParallel(t *testing.T) {
+
+	handler := RequestUngzipper{}.Handle(echo)
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := fmt.Sprintf("order %d", i)
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipped(t, []byte(want))))
+			req.Header.Set("Content-Encoding", "gzip")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, want, w.Body.String())
+		}()
+	}
+	wg.Wait()
+}
@@ -4,8 +4,16 @@ import (
 	"crypto/rand"
 	"flag"
 	"fmt"
+	"net/http"
+	"net/netip"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/oidc"
+	"github.com/wellywell/bonusy/internal/types"
 )
 
 /*
@@ -15,9 +23,47 @@ import (
 */
 
 type ServerConfig struct {
-	RunAddress           string `env:"RUN_ADDRESS"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	DatabaseDSN          string `env:"DATABASE_URI"`
+	RunAddress           string  `env:"RUN_ADDRESS"`
+	AccrualSystemAddress string  `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	DatabaseDSN          string  `env:"DATABASE_URI"`
+	OrderEventsPGNotify  bool    `env:"ORDER_EVENTS_PG_NOTIFY"`
+	OrderValidation      string  `env:"ORDER_VALIDATION"`
+	PointsExpireMonths   int     `env:"POINTS_EXPIRE_MONTHS"`
+	PointsExpiryNotice   int     `env:"POINTS_EXPIRY_NOTICE_DAYS"`
+	PointsHoldDays       int     `env:"POINTS_HOLD_DAYS"`
+	AccrualRecheckDays   int     `env:"ACCRUAL_RECHECK_DAYS"`
+	TransferMaxSum       float64 `env:"TRANSFER_MAX_SUM"`
+	TransferDailyLimit   float64 `env:"TRANSFER_DAILY_LIMIT"`
+	LoginMaxAttempts     int     `env:"LOGIN_MAX_ATTEMPTS"`
+	LoginMaxIPAttempts   int     `env:"LOGIN_MAX_IP_ATTEMPTS"`
+	LoginLockoutSeconds  int     `env:"LOGIN_LOCKOUT_SECONDS"`
+	PasswordMinLength    int     `env:"PASSWORD_MIN_LENGTH"`
+	PasswordMinClasses   int     `env:"PASSWORD_MIN_CLASSES"`
+	PasswordHash         string  `env:"PASSWORD_HASH"`
+	PasswordHashCost     int     `env:"PASSWORD_HASH_COST"`
+	Argon2Time           int     `env:"ARGON2_TIME"`
+	Argon2MemoryKiB      int     `env:"ARGON2_MEMORY_KIB"`
+	Argon2Threads        int     `env:"ARGON2_THREADS"`
+	PasswordHashParallel int     `env:"PASSWORD_HASH_PARALLEL"`
+	PasswordHashWaitMs   int     `env:"PASSWORD_HASH_WAIT_MS"`
+	NotifyFile           string  `env:"NOTIFY_FILE"`
+	Withdraw2FAThreshold float64 `env:"WITHDRAW_2FA_THRESHOLD"`
+	CookieInsecure       bool    `env:"COOKIE_INSECURE"`
+	CookieSameSite       string  `env:"COOKIE_SAMESITE"`
+	CookieDomain         string  `env:"COOKIE_DOMAIN"`
+	CSRFTrustedOrigins   string  `env:"CSRF_TRUSTED_ORIGINS"`
+	TrustedProxies       string  `env:"TRUSTED_PROXIES"`
+	OIDCIssuer           string  `env:"OIDC_ISSUER"`
+	OIDCClientID         string  `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret     string  `env:"OIDC_CLIENT_SECRET"`
+	OIDCRedirectURL      string  `env:"OIDC_REDIRECT_URL"`
+	OIDCAfterLoginURL    string  `env:"OIDC_AFTER_LOGIN_URL"`
+	APIKeyRateLimit      int     `env:"API_KEY_RATE_LIMIT"`
+	RateLimitIP          int     `env:"RATE_LIMIT_IP"`
+	RateLimitAuth        int     `env:"RATE_LIMIT_AUTH"`
+	RateLimitUser        int     `env:"RATE_LIMIT_USER"`
+	RateLimitAdmin       int     `env:"RATE_LIMIT_ADMIN"`
+	RateLimitPostgres    bool    `env:"RATE_LIMIT_POSTGRES"`
 	Secret               []byte
 	AuthCookieExpiresIn  int
 }
@@ -34,6 +80,44 @@ func NewConfig() (*ServerConfig, error) {
 	flag.StringVar(&commandLineParams.RunAddress, "a", "localhost:8080", "Base address to listen on")
 	flag.StringVar(&commandLineParams.AccrualSystemAddress, "r", "", "Accrual system address")
 	flag.StringVar(&commandLineParams.DatabaseDSN, "d", "", "Database DSN")
+	flag.StringVar(&commandLineParams.OrderValidation, "order-validation", "luhn", "Order number validation, e.g. luhn;prefix=12,34;length=8-20")
+	flag.IntVar(&commandLineParams.PointsExpireMonths, "points-expire-months", 0, "Months after accrual when points expire, 0 - never")
+	flag.IntVar(&commandLineParams.PointsExpiryNotice, "points-expiry-notice-days", 30, "Days before expiry when points are shown as expiring soon")
+	flag.IntVar(&commandLineParams.PointsHoldDays, "points-hold-days", 0, "Days accrued points stay pending before they can be spent, 0 - no hold")
+	flag.IntVar(&commandLineParams.AccrualRecheckDays, "accrual-recheck-days", 0, "Days after processing when orders are rechecked for reversals, 0 - never")
+	flag.Float64Var(&commandLineParams.TransferMaxSum, "transfer-max-sum", 0, "Max sum of a single points transfer, 0 - unlimited")
+	flag.Float64Var(&commandLineParams.TransferDailyLimit, "transfer-daily-limit", 0, "Max sum a user can transfer per day, 0 - unlimited")
+	flag.IntVar(&commandLineParams.LoginMaxAttempts, "login-max-attempts", 5, "Failed logins in a row before the account is temporarily locked")
+	flag.IntVar(&commandLineParams.LoginMaxIPAttempts, "login-max-ip-attempts", 50, "Failed logins from one address per hour before it is temporarily blocked")
+	flag.IntVar(&commandLineParams.LoginLockoutSeconds, "login-lockout-seconds", 30, "First login lockout, doubled with every next failure")
+	flag.IntVar(&commandLineParams.PasswordMinLength, "password-min-length", 8, "Minimal password length")
+	flag.IntVar(&commandLineParams.PasswordMinClasses, "password-min-classes", 2, "How many of lowercase, uppercase, digits and symbols a password must contain")
+	flag.StringVar(&commandLineParams.PasswordHash, "password-hash", "bcrypt", "Password hash algorithm: bcrypt or argon2id")
+	flag.IntVar(&commandLineParams.PasswordHashCost, "password-hash-cost", 14, "bcrypt cost")
+	flag.IntVar(&commandLineParams.Argon2Time, "argon2-time", 1, "argon2id iterations")
+	flag.IntVar(&commandLineParams.Argon2MemoryKiB, "argon2-memory-kib", 64*1024, "argon2id memory in KiB")
+	flag.IntVar(&commandLineParams.Argon2Threads, "argon2-threads", 2, "argon2id parallelism")
+	flag.IntVar(&commandLineParams.PasswordHashParallel, "password-hash-parallel", runtime.NumCPU(), "Max passwords hashed at once, the rest wait or get 503")
+	flag.IntVar(&commandLineParams.PasswordHashWaitMs, "password-hash-wait-ms", 500, "How long a request waits for a free hashing slot before 503")
+	flag.StringVar(&commandLineParams.NotifyFile, "notify-file", "", "Write user notifications to this file instead of the log, for development")
+	flag.Float64Var(&commandLineParams.Withdraw2FAThreshold, "withdraw-2fa-threshold", 0, "Withdrawals and transfers above this sum per day require a two-factor code, 0 - never")
+	flag.BoolVar(&commandLineParams.CookieInsecure, "cookie-insecure", false, "Send the auth cookie over plain HTTP too, for local development only")
+	flag.StringVar(&commandLineParams.CookieSameSite, "cookie-samesite", "lax", "SameSite of the auth cookie: lax, strict or none")
+	flag.StringVar(&commandLineParams.CookieDomain, "cookie-domain", "", "Domain of the auth cookie, empty - the request host only")
+	flag.StringVar(&commandLineParams.CSRFTrustedOrigins, "csrf-trusted-origins", "", "Comma separated origins allowed to send authenticated requests besides the API host")
+	flag.StringVar(&commandLineParams.TrustedProxies, "trusted-proxies", "", "Comma separated addresses or CIDRs of proxies whose X-Forwarded-For is trusted")
+	flag.IntVar(&commandLineParams.APIKeyRateLimit, "api-key-rate-limit", 60, "Requests per minute allowed for an API key by default, also the maximum for user keys")
+	flag.IntVar(&commandLineParams.RateLimitIP, "rate-limit-ip", 600, "Requests per minute from one IP to any route, -1 - no limit")
+	flag.IntVar(&commandLineParams.RateLimitAuth, "rate-limit-auth", 20, "Requests per minute from one IP to login, registration and password reset, -1 - no limit")
+	flag.IntVar(&commandLineParams.RateLimitUser, "rate-limit-user", 300, "Requests per minute of one user to /api/user, -1 - no limit")
+	flag.IntVar(&commandLineParams.RateLimitAdmin, "rate-limit-admin", 120, "Requests per minute of one staff member to /api/admin, -1 - no limit")
+	flag.BoolVar(&commandLineParams.RateLimitPostgres, "rate-limit-postgres", false, "Keep rate limit counters in Postgres, shared by all replicas")
+	flag.StringVar(&commandLineParams.OIDCIssuer, "oidc-issuer", "", "OpenID Connect provider for external login, empty - disabled")
+	flag.StringVar(&commandLineParams.OIDCClientID, "oidc-client-id", "", "OpenID Connect client id")
+	flag.StringVar(&commandLineParams.OIDCClientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&commandLineParams.OIDCRedirectURL, "oidc-redirect-url", "", "Public URL of /api/user/oidc/callback registered at the provider")
+	flag.StringVar(&commandLineParams.OIDCAfterLoginURL, "oidc-after-login-url", "", "Where to send the browser after external login")
+	flag.BoolVar(&commandLineParams.OrderEventsPGNotify, "order-events-pg-notify", false, "Share order events between replicas via Postgres LISTEN/NOTIFY")
 	flag.Parse()
 
 	if params.RunAddress == "" {
@@ -45,6 +129,120 @@ func NewConfig() (*ServerConfig, error) {
 	if params.DatabaseDSN == "" {
 		params.DatabaseDSN = commandLineParams.DatabaseDSN
 	}
+	if params.OrderValidation == "" {
+		params.OrderValidation = commandLineParams.OrderValidation
+	}
+	if params.PointsExpireMonths == 0 {
+		params.PointsExpireMonths = commandLineParams.PointsExpireMonths
+	}
+	if params.PointsExpiryNotice == 0 {
+		params.PointsExpiryNotice = commandLineParams.PointsExpiryNotice
+	}
+	if params.PointsHoldDays == 0 {
+		params.PointsHoldDays = commandLineParams.PointsHoldDays
+	}
+	if params.AccrualRecheckDays == 0 {
+		params.AccrualRecheckDays = commandLineParams.AccrualRecheckDays
+	}
+	if params.TransferMaxSum == 0 {
+		params.TransferMaxSum = commandLineParams.TransferMaxSum
+	}
+	if params.TransferDailyLimit == 0 {
+		params.TransferDailyLimit = commandLineParams.TransferDailyLimit
+	}
+	if params.LoginMaxAttempts == 0 {
+		params.LoginMaxAttempts = commandLineParams.LoginMaxAttempts
+	}
+	if params.LoginMaxIPAttempts == 0 {
+		params.LoginMaxIPAttempts = commandLineParams.LoginMaxIPAttempts
+	}
+	if params.LoginLockoutSeconds == 0 {
+		params.LoginLockoutSeconds = commandLineParams.LoginLockoutSeconds
+	}
+	if params.PasswordMinLength == 0 {
+		params.PasswordMinLength = commandLineParams.PasswordMinLength
+	}
+	if params.PasswordMinClasses == 0 {
+		params.PasswordMinClasses = commandLineParams.PasswordMinClasses
+	}
+	if params.PasswordHash == "" {
+		params.PasswordHash = commandLineParams.PasswordHash
+	}
+	if params.PasswordHashCost == 0 {
+		params.PasswordHashCost = commandLineParams.PasswordHashCost
+	}
+	if params.Argon2Time == 0 {
+		params.Argon2Time = commandLineParams.Argon2Time
+	}
+	if params.Argon2MemoryKiB == 0 {
+		params.Argon2MemoryKiB = commandLineParams.Argon2MemoryKiB
+	}
+	if params.Argon2Threads == 0 {
+		params.Argon2Threads = commandLineParams.Argon2Threads
+	}
+	if params.PasswordHashParallel == 0 {
+		params.PasswordHashParallel = commandLineParams.PasswordHashParallel
+	}
+	if params.PasswordHashWaitMs == 0 {
+		params.PasswordHashWaitMs = commandLineParams.PasswordHashWaitMs
+	}
+	if params.NotifyFile == "" {
+		params.NotifyFile = commandLineParams.NotifyFile
+	}
+	if params.Withdraw2FAThreshold == 0 {
+		params.Withdraw2FAThreshold = commandLineParams.Withdraw2FAThreshold
+	}
+	if !params.CookieInsecure {
+		params.CookieInsecure = commandLineParams.CookieInsecure
+	}
+	if params.CookieSameSite == "" {
+		params.CookieSameSite = commandLineParams.CookieSameSite
+	}
+	if params.CookieDomain == "" {
+		params.CookieDomain = commandLineParams.CookieDomain
+	}
+	if params.CSRFTrustedOrigins == "" {
+		params.CSRFTrustedOrigins = commandLineParams.CSRFTrustedOrigins
+	}
+	if params.TrustedProxies == "" {
+		params.TrustedProxies = commandLineParams.TrustedProxies
+	}
+	if params.APIKeyRateLimit == 0 {
+		params.APIKeyRateLimit = commandLineParams.APIKeyRateLimit
+	}
+	if params.RateLimitIP == 0 {
+		params.RateLimitIP = commandLineParams.RateLimitIP
+	}
+	if params.RateLimitAuth == 0 {
+		params.RateLimitAuth = commandLineParams.RateLimitAuth
+	}
+	if params.RateLimitUser == 0 {
+		params.RateLimitUser = commandLineParams.RateLimitUser
+	}
+	if params.RateLimitAdmin == 0 {
+		params.RateLimitAdmin = commandLineParams.RateLimitAdmin
+	}
+	if !params.RateLimitPostgres {
+		params.RateLimitPostgres = commandLineParams.RateLimitPostgres
+	}
+	if params.OIDCIssuer == "" {
+		params.OIDCIssuer = commandLineParams.OIDCIssuer
+	}
+	if params.OIDCClientID == "" {
+		params.OIDCClientID = commandLineParams.OIDCClientID
+	}
+	if params.OIDCClientSecret == "" {
+		params.OIDCClientSecret = commandLineParams.OIDCClientSecret
+	}
+	if params.OIDCRedirectURL == "" {
+		params.OIDCRedirectURL = commandLineParams.OIDCRedirectURL
+	}
+	if params.OIDCAfterLoginURL == "" {
+		params.OIDCAfterLoginURL = commandLineParams.OIDCAfterLoginURL
+	}
+	if !params.OrderEventsPGNotify {
+		params.OrderEventsPGNotify = commandLineParams.OrderEventsPGNotify
+	}
 
 	secret := make([]byte, 10)
 	_, err = rand.Read(secret)
@@ -57,3 +255,110 @@ func NewConfig() (*ServerConfig, error) {
 
 	return &params, nil
 }
+
+func (c *ServerConfig) LoginPolicy() auth.LoginPolicy {
+	return auth.LoginPolicy{
+		MaxAttempts:   c.LoginMaxAttempts,
+		MaxIPAttempts: c.LoginMaxIPAttempts,
+		Lockout:       time.Duration(c.LoginLockoutSeconds) * time.Second,
+		MaxLockout:    15 * time.Minute,
+		Window:        time.Hour,
+	}
+}
+
+func (c *ServerConfig) PasswordPolicy() auth.PasswordPolicy {
+	return auth.PasswordPolicy{MinLength: c.PasswordMinLength, MinClasses: c.PasswordMinClasses}
+}
+
+func (c *ServerConfig) CookieOptions() (auth.CookieOptions, error) {
+	opts := auth.CookieOptions{MaxAge: c.AuthCookieExpiresIn, Secure: !c.CookieInsecure, Domain: c.CookieDomain}
+	switch strings.ToLower(c.CookieSameSite) {
+	case "lax":
+		opts.SameSite = http.SameSiteLaxMode
+	case "strict":
+		opts.SameSite = http.SameSiteStrictMode
+	case "none":
+		// браузеры принимают SameSite=None только вместе с Secure
+		if !opts.Secure {
+			return opts, fmt.Errorf("cookie SameSite=None requires a secure cookie")
+		}
+		opts.SameSite = http.SameSiteNoneMode
+	default:
+		return opts, fmt.Errorf("unknown cookie SameSite %s", c.CookieSameSite)
+	}
+	return opts, nil
+}
+
+func (c *ServerConfig) TrustedOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(c.CSRFTrustedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// TrustedProxyPrefixes - подсети прокси, которым можно верить в X-Forwarded-For; адрес без маски - одна машина
+func (c *ServerConfig) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(c.TrustedProxies, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s: %w", item, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s: %w", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (c *ServerConfig) OIDC() oidc.Config {
+	return oidc.Config{
+		Issuer:        c.OIDCIssuer,
+		ClientID:      c.OIDCClientID,
+		ClientSecret:  c.OIDCClientSecret,
+		RedirectURL:   c.OIDCRedirectURL,
+		AfterLoginURL: c.OIDCAfterLoginURL,
+	}
+}
+
+func (c *ServerConfig) HashPolicy() auth.HashPolicy {
+	return auth.HashPolicy{
+		Algorithm:      auth.HashAlgorithm(c.PasswordHash),
+		BcryptCost:     c.PasswordHashCost,
+		ArgonTime:      uint32(c.Argon2Time),
+		ArgonMemoryKiB: uint32(c.Argon2MemoryKiB),
+		ArgonThreads:   uint8(c.Argon2Threads),
+		MaxConcurrent:  c.PasswordHashParallel,
+		QueueTimeout:   time.Duration(c.PasswordHashWaitMs) * time.Millisecond,
+	}
+}
+
+// AccrualRecheckWindow - сколько после начисления заказ перепроверяется на отмену, 0 - не перепроверяется
+func (c *ServerConfig) AccrualRecheckWindow() time.Duration {
+	return time.Duration(c.AccrualRecheckDays) * 24 * time.Hour
+}
+
+func (c *ServerConfig) PointsPolicy() types.PointsPolicy {
+	return types.PointsPolicy{
+		ExpireMonths: c.PointsExpireMonths,
+		ExpiryNotice: time.Duration(c.PointsExpiryNotice) * 24 * time.Hour,
+		HoldPeriod:   time.Duration(c.PointsHoldDays) * 24 * time.Hour,
+
+		TransferMaxSum:     c.TransferMaxSum,
+		TransferDailyLimit: c.TransferDailyLimit,
+	}
+}
@@ -0,0 +1,296 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func writeAudit(ctx context.Context, e execer, adminID int, action types.AuditAction, targetUserID int, details any) error {
+	payload, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit details %w", err)
+	}
+	query := `
+		INSERT INTO audit_log (admin_id, action, target_user_id, details)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err = e.Exec(ctx, query, adminID, action, targetUserID, json.RawMessage(payload))
+	if err != nil {
+		return fmt.Errorf("failed to write audit %w", err)
+	}
+	return nil
+}
+
+// WriteAudit записывает действие администратора, выполненное вне транзакции базы
+func (d *Database) WriteAudit(ctx context.Context, adminID int, action types.AuditAction, targetUserID int, details any) error {
+	return writeAudit(ctx, d.pool, adminID, action, targetUserID, details)
+}
+
+func (d *Database) GetUserSummary(ctx context.Context, merchantID int, username string) (*types.UserSummary, error) {
+	user, err := d.GetUser(ctx, merchantID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := types.UserSummary{User: *user}
+
+	row := d.pool.QueryRow(ctx, "SELECT COUNT(*) FROM user_order WHERE user_id = $1", user.ID)
+	if err := row.Scan(&summary.Orders); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	balance, err := d.GetUserBalance(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	summary.Balance = *balance
+	return &summary, nil
+}
+
+func (d *Database) GetUserAudit(ctx context.Context, userID int) ([]types.AuditEntry, error) {
+	query := `
+		SELECT a.id, a.admin_id, u.username AS admin, a.action, a.target_user_id, a.details, a.created_at
+		FROM audit_log a
+		JOIN auth_user u ON u.id = a.admin_id
+		WHERE a.target_user_id = $1
+		ORDER BY a.id
+	`
+	rows, err := d.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	results, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.AuditEntry])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return results, nil
+}
+
+// AdjustBalance вручную начисляет (sum > 0) или списывает (sum < 0) доступные баллы пользователя
+func (d *Database) AdjustBalance(ctx context.Context, adminID int, userID int, sum float64, reason string) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := settleUserLots(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if sum > 0 {
+		// ручное начисление доступно сразу, без холда
+		if err := d.creditBalance(ctx, tx, userID, nil, sum, 0); err != nil {
+			return err
+		}
+	} else {
+		query := `
+			UPDATE balance
+			SET current = current - $1
+			WHERE user_id = $2 AND current >= $1
+			RETURNING 1
+		`
+		var success int
+		if err := tx.QueryRow(ctx, query, -sum, userID).Scan(&success); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("%w", ErrNotEnoughBalance)
+			}
+			return fmt.Errorf("unexpected DB error %w", err)
+		}
+		if _, err := consumeLots(ctx, tx, userID, -sum); err != nil {
+			return err
+		}
+	}
+
+	details := map[string]any{"sum": sum, "reason": reason}
+	if err := writeAudit(ctx, tx, adminID, types.AuditBalanceAdjust, userID, details); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// GetMerchantOrder ищет заказ среди заказов всех пользователей магазина
+func (d *Database) GetMerchantOrder(ctx context.Context, merchantID int, number string) (*types.OrderRecord, error) {
+	query := `
+		SELECT id, order_number, status, user_id, merchant_id, COALESCE(accrual, 0) AS accrual
+		FROM user_order
+		WHERE merchant_id = $1 AND order_number = $2
+	`
+	rows, err := d.pool.Query(ctx, query, merchantID, number)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	order, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[types.OrderRecord])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w", ErrOrderNotFound)
+		}
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return &order, nil
+}
+
+// ReassignOrder передаёт заказ другому пользователю вместе с начисленными по нему баллами
+func (d *Database) ReassignOrder(ctx context.Context, adminID int, orderID int, toUserID int) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT user_id, order_number, status, COALESCE(accrual, 0)
+		FROM user_order
+		WHERE id = $1
+		FOR UPDATE`
+
+	var fromUserID int
+	var number string
+	var status types.Status
+	var accrual float64
+	if err := tx.QueryRow(ctx, query, orderID).Scan(&fromUserID, &number, &status, &accrual); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w", ErrOrderNotFound)
+		}
+		return fmt.Errorf("%w", err)
+	}
+	if fromUserID == toUserID {
+		return fmt.Errorf("%w", ErrSameOwner)
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE user_order SET user_id = $1 WHERE id = $2", toUserID, orderID); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if status == types.ProcessedStatus && accrual > pointsEpsilon {
+		first, second := fromUserID, toUserID
+		if first > second {
+			first, second = second, first
+		}
+		if err := lockBalance(ctx, tx, first); err != nil {
+			return err
+		}
+		if err := lockBalance(ctx, tx, second); err != nil {
+			return err
+		}
+		if err := clawBack(ctx, tx, fromUserID, orderID, accrual); err != nil {
+			return err
+		}
+		if err := d.creditBalance(ctx, tx, toUserID, &orderID, accrual, 0); err != nil {
+			return err
+		}
+	}
+
+	details := map[string]any{"order": number, "from_user_id": fromUserID, "accrual": accrual}
+	if err := writeAudit(ctx, tx, adminID, types.AuditOrderReassign, toUserID, details); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+func (d *Database) SetUserStatus(ctx context.Context, adminID int, userID int, status types.UserStatus) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// удаление необратимо
+	tag, err := tx.Exec(ctx, "UPDATE auth_user SET status = $1 WHERE id = $2 AND status <> 'deleted'", status, userID)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrUserDeleted)
+	}
+
+	action := types.AuditUserUnlock
+	if status == types.UserLocked {
+		action = types.AuditUserLock
+	}
+	if err := writeAudit(ctx, tx, adminID, action, userID, nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+func (d *Database) SetUserRole(ctx context.Context, merchantCode string, username string, role types.UserRole) error {
+	if !role.Valid() {
+		return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+	}
+
+	query := `
+		UPDATE auth_user
+		SET role = $1
+		FROM merchant
+		WHERE merchant.id = auth_user.merchant_id
+		AND merchant.code = $2 AND auth_user.username = $3
+	`
+	tag, err := d.pool.Exec(ctx, query, role, merchantCode, username)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", &UserNotFoundError{Username: username})
+	}
+	return nil
+}
+
+func (d *Database) ChangeUserRole(ctx context.Context, adminID int, userID int, role types.UserRole) error {
+	if !role.Valid() {
+		return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var previous types.UserRole
+	row := tx.QueryRow(ctx, "UPDATE auth_user SET role = $1 FROM auth_user old WHERE old.id = auth_user.id AND auth_user.id = $2 RETURNING old.role", role, userID)
+	if err := row.Scan(&previous); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	details := map[string]any{"from": previous, "to": role}
+	if err := writeAudit(ctx, tx, adminID, types.AuditRoleChange, userID, details); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
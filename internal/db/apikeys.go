@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+const apiKeyColumns = `
+	k.id, k.merchant_id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.rate_limit, k.created_at, k.last_used_at,
+	COALESCE(u.username, '') AS username
+`
+
+// CreateAPIKey сохраняет ключ; userID nil - ключ магазина
+func (d *Database) CreateAPIKey(ctx context.Context, merchantID int, userID *int, name string, prefix string, hash string, scopes []string, rateLimit int) (int, error) {
+	query := `
+		INSERT INTO api_key (merchant_id, user_id, name, prefix, key_hash, scopes, rate_limit)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+	var id int
+	if err := d.pool.QueryRow(ctx, query, merchantID, userID, name, prefix, hash, scopes, rateLimit).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to create api key %w", err)
+	}
+	return id, nil
+}
+
+// GetAPIKey ищет действующий ключ по открытой части
+func (d *Database) GetAPIKey(ctx context.Context, prefix string) (*types.APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `
+		FROM api_key k
+		LEFT JOIN auth_user u ON u.id = k.user_id
+		WHERE k.prefix = $1 AND k.revoked_at IS NULL
+	`
+	rows, err := d.pool.Query(ctx, query, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	key, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[types.APIKey])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w", ErrAPIKeyNotFound)
+		}
+		return nil, fmt.Errorf("failed unpacking row %w", err)
+	}
+	return &key, nil
+}
+
+// GetAPIKeys возвращает действующие ключи пользователя или, если userID nil, ключи магазина
+func (d *Database) GetAPIKeys(ctx context.Context, merchantID int, userID *int) ([]types.APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `
+		FROM api_key k
+		LEFT JOIN auth_user u ON u.id = k.user_id
+		WHERE k.merchant_id = $1 AND k.user_id IS NOT DISTINCT FROM $2 AND k.revoked_at IS NULL
+		ORDER BY k.created_at DESC
+	`
+	rows, err := d.pool.Query(ctx, query, merchantID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	keys, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.APIKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return keys, nil
+}
+
+func (d *Database) RevokeAPIKey(ctx context.Context, merchantID int, userID *int, keyID int) error {
+	query := `
+		UPDATE api_key
+		SET revoked_at = NOW()
+		WHERE id = $1 AND merchant_id = $2 AND user_id IS NOT DISTINCT FROM $3 AND revoked_at IS NULL
+	`
+	tag, err := d.pool.Exec(ctx, query, keyID, merchantID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrAPIKeyNotFound)
+	}
+	return nil
+}
+
+// TouchAPIKey отмечает время использования ключа; не чаще раза в минуту, чтобы не писать в базу на каждый запрос
+func (d *Database) TouchAPIKey(ctx context.Context, keyID int) error {
+	query := `
+		UPDATE api_key
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+	if _, err := d.pool.Exec(ctx, query, keyID); err != nil {
+		return fmt.Errorf("failed to touch api key %w", err)
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
@@ -13,10 +15,11 @@ import (
 )
 
 type Database struct {
-	pool *pgxpool.Pool
+	pool   *pgxpool.Pool
+	policy types.PointsPolicy
 }
 
-func NewDatabase(connString string) (*Database, error) {
+func NewDatabase(connString string, policy types.PointsPolicy) (*Database, error) {
 
 	err := Migrate(connString)
 
@@ -24,6 +27,17 @@ func NewDatabase(connString string) (*Database, error) {
 		return nil, fmt.Errorf("failed to migrate %w", err)
 	}
 
+	database, err := OpenDatabase(connString)
+	if err != nil {
+		return nil, err
+	}
+	database.policy = policy
+	return database, nil
+}
+
+// OpenDatabase подключается к базе без применения миграций, например для служебных утилит
+func OpenDatabase(connString string) (*Database, error) {
+
 	ctx := context.Background()
 	p, err := pgxpool.New(ctx, connString)
 	if err != nil {
@@ -35,13 +49,13 @@ func NewDatabase(connString string) (*Database, error) {
 	}, nil
 }
 
-func (d *Database) CreateUser(ctx context.Context, username string, password string) error {
+func (d *Database) CreateUser(ctx context.Context, merchantID int, username string, password string) error {
 
 	query := `
-		INSERT INTO auth_user (username, password)
-		VALUES ($1, $2)
+		INSERT INTO auth_user (merchant_id, username, password)
+		VALUES ($1, $2, $3)
 		`
-	_, err := d.pool.Exec(ctx, query, username, password)
+	_, err := d.pool.Exec(ctx, query, merchantID, username, password)
 
 	if err != nil {
 		var pgErr *pgconn.PgError
@@ -53,13 +67,34 @@ func (d *Database) CreateUser(ctx context.Context, username string, password str
 	return nil
 }
 
-func (d *Database) GetUserHashedPassword(ctx context.Context, username string) (string, error) {
+func (d *Database) GetUser(ctx context.Context, merchantID int, username string) (*types.User, error) {
+	query := `
+		SELECT id, username, merchant_id, role, status, token_version
+		FROM auth_user
+		WHERE merchant_id = $1 AND username = $2`
+
+	rows, err := d.pool.Query(ctx, query, merchantID, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	user, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[types.User])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w", &UserNotFoundError{Username: username})
+		}
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return &user, nil
+}
+
+func (d *Database) GetUserHashedPassword(ctx context.Context, merchantID int, username string) (string, error) {
 	query := `
 		SELECT password 
 		FROM auth_user 
-		WHERE username = $1`
+		WHERE merchant_id = $1 AND username = $2`
 
-	row := d.pool.QueryRow(ctx, query, username)
+	row := d.pool.QueryRow(ctx, query, merchantID, username)
 
 	var password string
 
@@ -70,13 +105,13 @@ func (d *Database) GetUserHashedPassword(ctx context.Context, username string) (
 	return password, nil
 }
 
-func (d *Database) GetUserID(ctx context.Context, username string) (int, error) {
+func (d *Database) GetUserID(ctx context.Context, merchantID int, username string) (int, error) {
 	query := `
 		SELECT id 
 		FROM auth_user 
-		WHERE username = $1`
+		WHERE merchant_id = $1 AND username = $2`
 
-	row := d.pool.QueryRow(ctx, query, username)
+	row := d.pool.QueryRow(ctx, query, merchantID, username)
 
 	var id int
 
@@ -88,20 +123,27 @@ func (d *Database) GetUserID(ctx context.Context, username string) (int, error)
 
 }
 
-func (d *Database) InsertUserOrder(ctx context.Context, order string, userID int, status types.Status) error {
-
-	query := `
-	WITH inserted AS
-		(INSERT INTO user_order (user_id, order_number, status)
-		 VALUES ($1, $2, $3)
-		 ON CONFLICT(order_number) DO NOTHING
-		 RETURNING -1)
+// insertUserOrderQuery возвращает -1, если заказ добавлен, иначе id пользователя, загрузившего заказ ранее.
+// Номера заказов уникальны в пределах магазина пользователя
+const insertUserOrderQuery = `
+	WITH owner AS
+		(SELECT merchant_id FROM auth_user WHERE id = $1),
+	inserted AS
+		(INSERT INTO user_order (user_id, merchant_id, order_number, status)
+		 SELECT $1, merchant_id, $2, $3 FROM owner
+		 ON CONFLICT(merchant_id, order_number) DO NOTHING
+		 RETURNING id, status),
+	history AS
+		(INSERT INTO order_status_history (order_id, status, source)
+		 SELECT id, status, 'upload' FROM inserted)
 	SELECT COALESCE (
-		(SELECT * FROM inserted),
-		(SELECT user_id FROM user_order WHERE order_number = $2)
+		(SELECT -1 FROM inserted),
+		(SELECT user_id FROM user_order WHERE order_number = $2 AND merchant_id = (SELECT merchant_id FROM owner))
 	)`
 
-	row := d.pool.QueryRow(ctx, query, userID, order, status)
+func (d *Database) InsertUserOrder(ctx context.Context, order string, userID int, status types.Status) error {
+
+	row := d.pool.QueryRow(ctx, insertUserOrderQuery, userID, order, status)
 
 	var OwnerOfExistingRow int
 	if err := row.Scan(&OwnerOfExistingRow); err != nil {
@@ -120,13 +162,65 @@ func (d *Database) InsertUserOrder(ctx context.Context, order string, userID int
 	}
 }
 
+// InsertUserOrders добавляет заказы одним батчем, результаты возвращаются в порядке заказов
+func (d *Database) InsertUserOrders(ctx context.Context, orders []string, userID int, status types.Status) ([]types.UploadResult, error) {
+
+	batch := &pgx.Batch{}
+	for _, order := range orders {
+		batch.Queue(insertUserOrderQuery, userID, order, status)
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	results := make([]types.UploadResult, 0, len(orders))
+
+	batchResults := tx.SendBatch(ctx, batch)
+	for range orders {
+		var OwnerOfExistingRow int
+		if err := batchResults.QueryRow().Scan(&OwnerOfExistingRow); err != nil {
+			batchResults.Close()
+			return nil, fmt.Errorf("%w", err)
+		}
+		switch OwnerOfExistingRow {
+		case -1:
+			results = append(results, types.UploadAccepted)
+		case userID:
+			results = append(results, types.UploadAlreadyYours)
+		default:
+			results = append(results, types.UploadConflict)
+		}
+	}
+	if err := batchResults.Close(); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return results, nil
+}
+
+// GetUnprocessedOrders также отмечает время последней проверки выбранных заказов
 func (d *Database) GetUnprocessedOrders(ctx context.Context, startID int, limit int) ([]types.OrderRecord, error) {
 	query := `
-	    SELECT id, order_number, status
-		FROM user_order
-		WHERE status not in ('INVALID', 'PROCESSED')
-		AND id > $1
-		ORDER BY id LIMIT $2
+		WITH picked AS
+			(UPDATE user_order
+			 SET checked_at = NOW()
+			 WHERE id IN (
+				SELECT id
+				FROM user_order
+				WHERE status not in ('INVALID', 'PROCESSED')
+				AND id > $1
+				ORDER BY id LIMIT $2)
+			 RETURNING id, order_number, status, user_id, merchant_id, accrual)
+		SELECT id, order_number, status, user_id, merchant_id, COALESCE(accrual, 0) AS accrual
+		FROM picked
+		ORDER BY id
 	`
 	rows, err := d.pool.Query(ctx, query, startID, limit)
 	if err != nil {
@@ -140,7 +234,27 @@ func (d *Database) GetUnprocessedOrders(ctx context.Context, startID int, limit
 	return orders, nil
 }
 
-func (d *Database) InsertWithdrawAndUpdateBalance(ctx context.Context, userID int, order string, sum float64) error {
+// InsertWithdrawAndUpdateBalance списывает sum доступных баллов. Если вместе со списаниями и переводами
+// за сутки выходит больше unconfirmedLimit, возвращает ErrNeedSecondFactor; 0 - без ограничения
+func (d *Database) InsertWithdrawAndUpdateBalance(ctx context.Context, userID int, order string, sum float64, unconfirmedLimit float64) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// списание идёт только из доступных баллов: созревшие партии учитываются, просроченные нет,
+	// даже если фоновая задача до них ещё не дошла
+	if err := lockBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := settleUserLots(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := checkUnconfirmedSpending(ctx, tx, userID, sum, unconfirmedLimit); err != nil {
+		return err
+	}
+
 	query := `
 	    UPDATE balance
 		SET current = current - $1,
@@ -148,12 +262,6 @@ func (d *Database) InsertWithdrawAndUpdateBalance(ctx context.Context, userID in
 		WHERE user_id = $2 AND current >= $1
 		RETURNING 1
 	`
-	tx, err := d.pool.Begin(ctx)
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-	defer tx.Rollback(ctx)
-
 	row := tx.QueryRow(ctx, query, sum, userID)
 
 	var success int
@@ -163,6 +271,11 @@ func (d *Database) InsertWithdrawAndUpdateBalance(ctx context.Context, userID in
 		}
 		return fmt.Errorf("unexpected DB error %w", err)
 	}
+
+	if _, err := consumeLots(ctx, tx, userID, sum); err != nil {
+		return err
+	}
+
 	query = `
 		INSERT INTO withdrawal (user_id, order_name, sum)
 		VALUES ($1, $2, $3)
@@ -179,10 +292,33 @@ func (d *Database) InsertWithdrawAndUpdateBalance(ctx context.Context, userID in
 	return nil
 }
 
-func (d *Database) UpdateUnprocessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64) error {
+// checkUnconfirmedSpending проверяет, что списания и переводы за сутки вместе с sum не больше limit; 0 - без ограничения.
+// Вызывается под lockBalance, чтобы порог нельзя было обойти несколькими одновременными запросами
+func checkUnconfirmedSpending(ctx context.Context, tx pgx.Tx, userID int, sum float64, limit float64) error {
+	if limit <= 0 {
+		return nil
+	}
+	query := `
+		SELECT COALESCE((SELECT SUM(sum) FROM withdrawal
+		                 WHERE user_id = $1 AND processed_at > NOW() - interval '1 day'), 0)
+		     + COALESCE((SELECT SUM(sum) FROM transfer
+		                 WHERE sender_id = $1 AND created_at > NOW() - interval '1 day'), 0)
+	`
+	var spent float64
+	if err := tx.QueryRow(ctx, query, userID).Scan(&spent); err != nil {
+		return fmt.Errorf("unexpected DB error %w", err)
+	}
+	if spent+sum > limit+pointsEpsilon {
+		return fmt.Errorf("%w", ErrNeedSecondFactor)
+	}
+	return nil
+}
+
+func (d *Database) UpdateUnprocessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64, source types.StatusSource, payload []byte) error {
 	query := `
 		UPDATE user_order
-		SET status = $1, accrual = $2
+		SET status = $1, accrual = $2,
+		    processed_at = CASE WHEN $1 = 'PROCESSED' THEN NOW() END
 		WHERE id = $3
 		AND status not in ('INVALID', 'PROCESSED')
 		RETURNING user_id`
@@ -202,16 +338,18 @@ func (d *Database) UpdateUnprocessedOrder(ctx context.Context, orderID int, newS
 	}
 
 	query = `
-		INSERT INTO balance (user_id, current, withdrawn)
-		VALUES ($1, $2, 0)
-		ON CONFLICT(user_id)
-		DO UPDATE SET current = balance.current + $2
+		INSERT INTO order_status_history (order_id, status, source, payload)
+		VALUES ($1, $2, $3, $4)
 	`
-	_, err = tx.Exec(ctx, query, userID, accrual)
+	_, err = tx.Exec(ctx, query, orderID, newStatus, source, json.RawMessage(payload))
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
+	if err := d.creditBalance(ctx, tx, userID, &orderID, accrual, d.policy.HoldPeriod); err != nil {
+		return err
+	}
+
 	err = tx.Commit(ctx)
 	if err != nil {
 		return fmt.Errorf("%w", err)
@@ -219,15 +357,20 @@ func (d *Database) UpdateUnprocessedOrder(ctx context.Context, orderID int, newS
 	return nil
 }
 
-func (d *Database) GetUserWithdrawals(ctx context.Context, userID int) ([]types.Withdrawal, error) {
-	query := `
-		SELECT sum, order_name, processed_at
+func (d *Database) GetUserWithdrawals(ctx context.Context, userID int, filter types.ListFilter) ([]types.Withdrawal, error) {
+	comparison, direction := listOrdering(filter.Sort)
+
+	query := fmt.Sprintf(`
+		SELECT id, sum, order_name, processed_at
 		FROM withdrawal
 		WHERE user_id = $1
-		ORDER BY id
-		LIMIT 1000
-		`
-	rows, err := d.pool.Query(ctx, query, userID)
+		AND ($2 = 0 OR id %s $2)
+		AND ($3::timestamptz IS NULL OR processed_at >= $3)
+		AND ($4::timestamptz IS NULL OR processed_at < $4)
+		ORDER BY id %s
+		LIMIT $5
+		`, comparison, direction)
+	rows, err := d.pool.Query(ctx, query, userID, filter.Cursor, filter.From, filter.To, filter.Limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed collecting rows %w", err)
 	}
@@ -239,16 +382,26 @@ func (d *Database) GetUserWithdrawals(ctx context.Context, userID int) ([]types.
 	return results, nil
 }
 
-func (d *Database) GetUserOrders(ctx context.Context, userID int) ([]types.OrderInfo, error) {
+func (d *Database) GetUserOrders(ctx context.Context, userID int, filter types.ListFilter) ([]types.OrderInfo, error) {
+	comparison, direction := listOrdering(filter.Sort)
 
-	query := `
-		SELECT order_number, status, accrual, uploaded_at
+	var statuses []string
+	for _, status := range filter.Statuses {
+		statuses = append(statuses, string(status))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, order_number, status, accrual, uploaded_at
 		FROM user_order
 		WHERE user_id = $1
-		ORDER BY id
-		LIMIT 1000
-	`
-	rows, err := d.pool.Query(ctx, query, userID)
+		AND ($2 = 0 OR id %s $2)
+		AND ($3::varchar[] IS NULL OR status = ANY($3))
+		AND ($4::timestamptz IS NULL OR uploaded_at >= $4)
+		AND ($5::timestamptz IS NULL OR uploaded_at < $5)
+		ORDER BY id %s
+		LIMIT $6
+	`, comparison, direction)
+	rows, err := d.pool.Query(ctx, query, userID, filter.Cursor, statuses, filter.From, filter.To, filter.Limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed collecting rows %w", err)
 	}
@@ -260,14 +413,117 @@ func (d *Database) GetUserOrders(ctx context.Context, userID int) ([]types.Order
 	return orders, nil
 }
 
+func (d *Database) GetUserOrder(ctx context.Context, userID int, number string) (*types.OrderDetail, error) {
+
+	query := `
+		SELECT id, order_number, status, accrual, uploaded_at, checked_at
+		FROM user_order
+		WHERE user_id = $1 AND order_number = $2
+	`
+	rows, err := d.pool.Query(ctx, query, userID, number)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	order, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[types.OrderDetail])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w", ErrOrderNotFound)
+		}
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+
+	order.History, err = d.GetOrderStatusHistory(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (d *Database) GetOrderStatusHistory(ctx context.Context, orderID int) ([]types.StatusChange, error) {
+
+	query := `
+		SELECT status, source, payload, created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY id
+	`
+	rows, err := d.pool.Query(ctx, query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	history, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.StatusChange])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return history, nil
+}
+
+func (d *Database) GetOrderStatusHistoryByNumber(ctx context.Context, merchantCode string, number string) ([]types.StatusChange, error) {
+
+	query := `
+		SELECT user_order.id
+		FROM user_order
+		JOIN merchant ON merchant.id = user_order.merchant_id
+		WHERE merchant.code = $1 AND user_order.order_number = $2
+	`
+	row := d.pool.QueryRow(ctx, query, merchantCode, number)
+
+	var orderID int
+	if err := row.Scan(&orderID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w", ErrOrderNotFound)
+		}
+		return nil, fmt.Errorf("%w", err)
+	}
+	return d.GetOrderStatusHistory(ctx, orderID)
+}
+
+func (d *Database) GetMerchants(ctx context.Context) ([]types.Merchant, error) {
+
+	query := `
+		SELECT id, code, name, host, accrual_address, order_validation, currency
+		FROM merchant
+		ORDER BY id
+	`
+	rows, err := d.pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	merchants, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.Merchant])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return merchants, nil
+}
+
+// listOrdering возвращает оператор сравнения для курсора и направление сортировки
+func listOrdering(sort types.SortOrder) (string, string) {
+	if sort == types.SortDesc {
+		return "<", "DESC"
+	}
+	return ">", "ASC"
+}
+
 func (d *Database) GetUserBalance(ctx context.Context, userID int) (*types.Balance, error) {
 
+	// партии с истёкшим холдом показываются доступными, не дожидаясь фоновой задачи
 	query := `
-		SELECT current, withdrawn
-		FROM balance
+		SELECT current + due.sum AS current, pending - due.sum AS pending, withdrawn, debt,
+			(SELECT COALESCE(SUM(remaining), 0)
+			 FROM balance_lot
+			 WHERE user_id = $1 AND remaining > 0
+			 AND (NOT pending OR available_at <= NOW())
+			 AND expires_at <= NOW() + $2::interval) AS expiring_soon
+		FROM balance,
+			(SELECT COALESCE(SUM(remaining), 0) AS sum
+			 FROM balance_lot
+			 WHERE user_id = $1 AND pending AND available_at <= NOW()) AS due
 		WHERE user_id = $1
 	`
-	rows, err := d.pool.Query(ctx, query, userID)
+	rows, err := d.pool.Query(ctx, query, userID, d.policy.ExpiryNotice)
 	if err != nil {
 		return nil, fmt.Errorf("failed collecting rows %w", err)
 	}
@@ -281,3 +537,102 @@ func (d *Database) GetUserBalance(ctx context.Context, userID int) (*types.Balan
 	}
 	return &balance, nil
 }
+
+// GetOrdersForRecheck отдаёт начисленные заказы не старше window, которые не проверялись дольше every,
+// и отмечает их проверенными
+func (d *Database) GetOrdersForRecheck(ctx context.Context, window time.Duration, every time.Duration, limit int) ([]types.OrderRecord, error) {
+	query := `
+		WITH picked AS
+			(UPDATE user_order
+			 SET checked_at = NOW()
+			 WHERE id IN (
+				SELECT id
+				FROM user_order
+				WHERE status = 'PROCESSED'
+				AND processed_at > NOW() - $1::interval
+				AND (checked_at IS NULL OR checked_at < NOW() - $2::interval)
+				ORDER BY id LIMIT $3)
+			 RETURNING id, order_number, status, user_id, merchant_id, accrual)
+		SELECT id, order_number, status, user_id, merchant_id, COALESCE(accrual, 0) AS accrual
+		FROM picked
+		ORDER BY id
+	`
+	rows, err := d.pool.Query(ctx, query, window, every, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	orders, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.OrderRecord])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return orders, nil
+}
+
+// UpdateProcessedOrder применяет изменение уже начисленного заказа: разница с прежним начислением
+// доначисляется или списывается с баланса, заказ не в статусе PROCESSED отменяет начисление целиком
+func (d *Database) UpdateProcessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64, source types.StatusSource, payload []byte) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT user_id, COALESCE(accrual, 0)
+		FROM user_order
+		WHERE id = $1 AND status = 'PROCESSED'
+		FOR UPDATE`
+
+	var userID int
+	var previous float64
+	if err := tx.QueryRow(ctx, query, orderID).Scan(&userID, &previous); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w", ErrOrderNotFound)
+		}
+		return fmt.Errorf("%w", err)
+	}
+
+	if newStatus != types.ProcessedStatus {
+		accrual = 0
+	}
+
+	query = `
+		UPDATE user_order
+		SET status = $1, accrual = $2,
+		    processed_at = CASE WHEN $1 = 'PROCESSED' THEN processed_at END
+		WHERE id = $3`
+	if _, err := tx.Exec(ctx, query, newStatus, accrual, orderID); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	query = `
+		INSERT INTO order_status_history (order_id, status, source, payload)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err = tx.Exec(ctx, query, orderID, newStatus, source, json.RawMessage(payload))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := lockBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	delta := accrual - previous
+	switch {
+	case delta > pointsEpsilon:
+		err = d.creditBalance(ctx, tx, userID, &orderID, delta, d.policy.HoldPeriod)
+	case delta < -pointsEpsilon:
+		err = clawBack(ctx, tx, userID, orderID, -delta)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
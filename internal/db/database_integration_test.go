@@ -10,9 +10,12 @@ import (
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/auth"
 	"github.com/wellywell/bonusy/internal/testutils"
+	"github.com/wellywell/bonusy/internal/types"
 )
 
 var DBDSN string
@@ -44,7 +47,7 @@ func runMain(m *testing.M) (int, error) {
 
 func TestGetUnprocessedOrdersEmptyTable(t *testing.T) {
 
-	database, err := NewDatabase(DBDSN)
+	database, err := NewDatabase(DBDSN, types.PointsPolicy{})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -56,3 +59,238 @@ func TestGetUnprocessedOrdersEmptyTable(t *testing.T) {
 	})
 
 }
+
+func TestPointsExpiry(t *testing.T) {
+
+	ctx := context.Background()
+
+	database, err := NewDatabase(DBDSN, types.PointsPolicy{ExpireMonths: 1, ExpiryNotice: 24 * time.Hour})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.NoError(t, database.CreateUser(ctx, 1, "expiry", "hash"))
+	userID, err := database.GetUserID(ctx, 1, "expiry")
+	assert.NoError(t, err)
+
+	for i, order := range []string{"49927398716", "79927398713", "0"} {
+		assert.NoError(t, database.InsertUserOrder(ctx, order, userID, types.NewStatus))
+		records, err := database.GetUnprocessedOrders(ctx, 0, 100)
+		assert.NoError(t, err)
+		assert.NoError(t, database.UpdateUnprocessedOrder(ctx, records[0].OrderID, types.ProcessedStatus, float64(100*(i+1)), types.SourcePoll, nil))
+	}
+
+	// первая партия уже сгорела, вторая сгорает завтра, третья - через месяц
+	_, err = database.pool.Exec(ctx, `UPDATE balance_lot SET expires_at = NOW() - interval '1 hour' WHERE amount = 100`)
+	assert.NoError(t, err)
+	_, err = database.pool.Exec(ctx, `UPDATE balance_lot SET expires_at = NOW() + interval '1 hour' WHERE amount = 200`)
+	assert.NoError(t, err)
+
+	t.Run("expiring soon", func(t *testing.T) {
+		balance, err := database.GetUserBalance(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 600.0, balance.Current)
+		assert.Equal(t, 300.0, balance.ExpiringSoon)
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		users, err := database.SettlePoints(ctx, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, users)
+
+		balance, err := database.GetUserBalance(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 500.0, balance.Current)
+		assert.Equal(t, 200.0, balance.ExpiringSoon)
+
+		var expired float64
+		assert.NoError(t, database.pool.QueryRow(ctx, "SELECT SUM(sum) FROM points_expiration WHERE user_id = $1", userID).Scan(&expired))
+		assert.Equal(t, 100.0, expired)
+	})
+
+	t.Run("withdraw consumes soonest expiring first", func(t *testing.T) {
+		assert.NoError(t, database.InsertWithdrawAndUpdateBalance(ctx, userID, "0", 250, 0))
+
+		balance, err := database.GetUserBalance(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 250.0, balance.Current)
+		assert.Equal(t, 0.0, balance.ExpiringSoon)
+
+		var remaining float64
+		assert.NoError(t, database.pool.QueryRow(ctx, "SELECT remaining FROM balance_lot WHERE amount = 300").Scan(&remaining))
+		assert.Equal(t, 250.0, remaining)
+	})
+}
+
+func TestPointsHold(t *testing.T) {
+
+	ctx := context.Background()
+
+	// все партии сгорают в пределах срока уведомления
+	database, err := NewDatabase(DBDSN, types.PointsPolicy{HoldPeriod: 24 * time.Hour, ExpireMonths: 1, ExpiryNotice: 60 * 24 * time.Hour})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.NoError(t, database.CreateUser(ctx, 1, "hold", "hash"))
+	userID, err := database.GetUserID(ctx, 1, "hold")
+	assert.NoError(t, err)
+
+	for i, order := range []string{"12345674", "4561261212345467"} {
+		assert.NoError(t, database.InsertUserOrder(ctx, order, userID, types.NewStatus))
+		records, err := database.GetUnprocessedOrders(ctx, 0, 100)
+		assert.NoError(t, err)
+		assert.NoError(t, database.UpdateUnprocessedOrder(ctx, records[0].OrderID, types.ProcessedStatus, float64(100*(i+1)), types.SourcePoll, nil))
+	}
+
+	t.Run("accrual is pending", func(t *testing.T) {
+		balance, err := database.GetUserBalance(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 0.0, balance.Current)
+		assert.Equal(t, 300.0, balance.Pending)
+		assert.Equal(t, 0.0, balance.ExpiringSoon)
+
+		err = database.InsertWithdrawAndUpdateBalance(ctx, userID, "2377225624", 50, 0)
+		assert.ErrorIs(t, err, ErrNotEnoughBalance)
+	})
+
+	// холд первой партии закончился
+	_, err = database.pool.Exec(ctx, `UPDATE balance_lot SET available_at = NOW() - interval '1 hour' WHERE user_id = $1 AND amount = 100`, userID)
+	assert.NoError(t, err)
+
+	t.Run("matured lot is available", func(t *testing.T) {
+		balance, err := database.GetUserBalance(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 100.0, balance.Current)
+		assert.Equal(t, 200.0, balance.Pending)
+		assert.Equal(t, 100.0, balance.ExpiringSoon)
+
+		err = database.InsertWithdrawAndUpdateBalance(ctx, userID, "2377225624", 150, 0)
+		assert.ErrorIs(t, err, ErrNotEnoughBalance)
+
+		assert.NoError(t, database.InsertWithdrawAndUpdateBalance(ctx, userID, "2377225624", 60, 0))
+
+		balance, err = database.GetUserBalance(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 40.0, balance.Current)
+		assert.Equal(t, 200.0, balance.Pending)
+		assert.Equal(t, 40.0, balance.ExpiringSoon)
+		assert.Equal(t, 60.0, balance.Withdrawn)
+	})
+}
+
+func TestAccrualReversal(t *testing.T) {
+
+	ctx := context.Background()
+
+	database, err := NewDatabase(DBDSN, types.PointsPolicy{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	assert.NoError(t, database.CreateUser(ctx, 1, "reversal", "hash"))
+	userID, err := database.GetUserID(ctx, 1, "reversal")
+	assert.NoError(t, err)
+
+	accrue := func(order string, sum float64) int {
+		assert.NoError(t, database.InsertUserOrder(ctx, order, userID, types.NewStatus))
+		records, err := database.GetUnprocessedOrders(ctx, 0, 100)
+		assert.NoError(t, err)
+		assert.NoError(t, database.UpdateUnprocessedOrder(ctx, records[0].OrderID, types.ProcessedStatus, sum, types.SourcePoll, nil))
+		return records[0].OrderID
+	}
+
+	orderID := accrue("18", 100)
+	assert.NoError(t, database.InsertWithdrawAndUpdateBalance(ctx, userID, "26", 70, 0))
+
+	t.Run("recheck picks processed order once", func(t *testing.T) {
+		// заказы других тестов тоже начислены недавно
+		picked := func(records []types.OrderRecord) []types.OrderRecord {
+			var res []types.OrderRecord
+			for _, r := range records {
+				if r.OrderID == orderID {
+					res = append(res, r)
+				}
+			}
+			return res
+		}
+
+		records, err := database.GetOrdersForRecheck(ctx, 24*time.Hour, -time.Hour, 100)
+		assert.NoError(t, err)
+		assert.Len(t, picked(records), 1)
+		assert.Equal(t, 100.0, picked(records)[0].Accrual)
+
+		records, err = database.GetOrdersForRecheck(ctx, 24*time.Hour, time.Hour, 100)
+		assert.NoError(t, err)
+		assert.Len(t, picked(records), 0)
+	})
+
+	t.Run("reversal becomes debt", func(t *testing.T) {
+		assert.NoError(t, database.UpdateProcessedOrder(ctx, orderID, types.InvalidStatus, 0, types.SourceRecheck, nil))
+
+		balance, err := database.GetUserBalance(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 0.0, balance.Current)
+		assert.Equal(t, 70.0, balance.Debt)
+
+		err = database.UpdateProcessedOrder(ctx, orderID, types.InvalidStatus, 0, types.SourceRecheck, nil)
+		assert.ErrorIs(t, err, ErrOrderNotFound)
+	})
+
+	t.Run("next accruals repay debt", func(t *testing.T) {
+		accrue("34", 50)
+		accrue("42", 40)
+
+		balance, err := database.GetUserBalance(ctx, userID)
+		assert.NoError(t, err)
+		assert.Equal(t, 20.0, balance.Current)
+		assert.Equal(t, 0.0, balance.Debt)
+	})
+}
+
+func TestNormalizeLogins(t *testing.T) {
+
+	ctx := context.Background()
+
+	database, err := NewDatabase(DBDSN, types.PointsPolicy{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// логины, заведённые до нормализации
+	assert.NoError(t, database.CreateUser(ctx, 1, "Legacy", "hash"))
+	assert.NoError(t, database.CreateUser(ctx, 1, "ＷＩＤＥ", "hash"))
+
+	t.Run("logins normalized", func(t *testing.T) {
+		assert.NoError(t, database.NormalizeLogins(ctx, auth.NormalizeLogin))
+
+		_, err := database.GetUserID(ctx, 1, "legacy")
+		assert.NoError(t, err)
+		_, err = database.GetUserID(ctx, 1, "wide")
+		assert.NoError(t, err)
+		_, err = database.GetUserID(ctx, 1, "Legacy")
+		assert.Error(t, err)
+	})
+
+	t.Run("normalized index", func(t *testing.T) {
+		var exists *UserExistsError
+		assert.ErrorAs(t, database.CreateUser(ctx, 1, "LEGACY", "hash"), &exists)
+		assert.ErrorAs(t, database.CreateUser(ctx, 1, " ｌｅｇａｃｙ", "hash"), &exists)
+	})
+
+	t.Run("collisions reported", func(t *testing.T) {
+		// lower() в индексе не раскрывает ß, а NormalizeLogin раскрывает
+		assert.NoError(t, database.CreateUser(ctx, 1, "strasse", "hash"))
+		assert.NoError(t, database.CreateUser(ctx, 1, "STRAßE", "hash"))
+
+		err := database.NormalizeLogins(ctx, auth.NormalizeLogin)
+		assert.ErrorIs(t, err, ErrLoginCollision)
+		assert.ErrorContains(t, err, `merchant 1: ["strasse" "STRAßE"]`)
+
+		// при совпадениях ничего не переименовывается
+		userID, err := database.GetUserID(ctx, 1, "STRAßE")
+		assert.NoError(t, err)
+		assert.NoError(t, database.DeleteUser(ctx, userID))
+		assert.NoError(t, database.NormalizeLogins(ctx, auth.NormalizeLogin))
+	})
+}
@@ -5,7 +5,24 @@ import (
 	"fmt"
 )
 
-var ErrNotEnoughBalance = errors.New("not enough balance")
+var (
+	ErrNotEnoughBalance  = errors.New("not enough balance")
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrSelfTransfer      = errors.New("cannot transfer to yourself")
+	ErrTransferLimit     = errors.New("transfer limit exceeded")
+	ErrSameOwner         = errors.New("order already belongs to user")
+	ErrUnknownRole       = errors.New("unknown role")
+	ErrUserDeleted       = errors.New("user deleted")
+	ErrResetTokenInvalid = errors.New("reset token invalid or expired")
+	ErrTwoFactorEnabled  = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorInvalid  = errors.New("two-factor code invalid or already used")
+	ErrSessionNotFound   = errors.New("session not found or revoked")
+	ErrIdentityLinked    = errors.New("identity already linked to a user")
+	ErrAPIKeyNotFound    = errors.New("api key not found or revoked")
+	ErrTooManyResets     = errors.New("too many active password resets")
+	ErrLoginCollision    = errors.New("logins collide after normalization")
+	ErrNeedSecondFactor  = errors.New("second factor required for this sum")
+)
 
 type UserExistsError struct {
 	Username string
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+const orderEventsChannel = "order_events"
+
+type orderEventPayload struct {
+	UserID  int          `json:"user_id"`
+	Number  string       `json:"number"`
+	Status  types.Status `json:"status"`
+	Accrual float64      `json:"accrual"`
+}
+
+func (d *Database) NotifyOrderEvent(ctx context.Context, event types.OrderEvent) error {
+
+	payload, err := json.Marshal(orderEventPayload(event))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	_, err = d.pool.Exec(ctx, "SELECT pg_notify($1, $2)", orderEventsChannel, string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to notify %w", err)
+	}
+	return nil
+}
+
+// ListenOrderEvents блокируется, пока не будет отменён контекст или не разорвётся соединение
+func (d *Database) ListenOrderEvents(ctx context.Context, handle func(types.OrderEvent)) error {
+
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection %w", err)
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "LISTEN "+orderEventsChannel)
+	if err != nil {
+		return fmt.Errorf("failed to listen %w", err)
+	}
+	defer conn.Exec(context.Background(), "UNLISTEN "+orderEventsChannel)
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		var payload orderEventPayload
+		err = json.Unmarshal([]byte(notification.Payload), &payload)
+		if err != nil {
+			logger.Errorf("Could not parse order event %s", err.Error())
+			continue
+		}
+		handle(types.OrderEvent(payload))
+	}
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// GetUserByIdentity находит пользователя магазина, к которому привязана учётная запись провайдера
+func (d *Database) GetUserByIdentity(ctx context.Context, merchantID int, issuer string, subject string) (*types.User, error) {
+	query := `
+		SELECT u.id, u.username, u.merchant_id, u.role, u.status, u.token_version
+		FROM user_identity i
+		JOIN auth_user u ON u.id = i.user_id
+		WHERE i.merchant_id = $1 AND i.issuer = $2 AND i.subject = $3`
+
+	rows, err := d.pool.Query(ctx, query, merchantID, issuer, subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	user, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[types.User])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w", &UserNotFoundError{Username: subject})
+		}
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return &user, nil
+}
+
+func (d *Database) LinkIdentity(ctx context.Context, userID int, merchantID int, issuer string, subject string, email string) error {
+	return linkIdentity(ctx, d.pool, userID, merchantID, issuer, subject, email)
+}
+
+func linkIdentity(ctx context.Context, conn execer, userID int, merchantID int, issuer string, subject string, email string) error {
+	query := `
+		INSERT INTO user_identity (user_id, merchant_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := conn.Exec(ctx, query, userID, merchantID, issuer, subject, email)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return fmt.Errorf("%w", ErrIdentityLinked)
+		}
+		return fmt.Errorf("failed to link identity %w", err)
+	}
+	return nil
+}
+
+// CreateUserWithIdentity заводит пользователя без пароля для входа только через провайдера
+func (d *Database) CreateUserWithIdentity(ctx context.Context, merchantID int, username string, issuer string, subject string, email string) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO auth_user (merchant_id, username, password)
+		VALUES ($1, $2, '')
+		RETURNING id
+	`
+	var userID int
+	if err := tx.QueryRow(ctx, query, merchantID, username).Scan(&userID); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+			return fmt.Errorf("%w", &UserExistsError{Username: username})
+		}
+		return fmt.Errorf("failed to create user %w", err)
+	}
+
+	if err := linkIdentity(ctx, tx, userID, merchantID, issuer, subject, email); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE order_status_history;
+ALTER TABLE user_order DROP COLUMN checked_at;
+
+COMMIT;
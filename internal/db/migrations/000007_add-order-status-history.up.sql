@@ -0,0 +1,16 @@
+BEGIN;
+
+ALTER TABLE user_order ADD COLUMN checked_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE order_status_history (id BIGSERIAL PRIMARY KEY, order_id BIGINT NOT NULL, status VARCHAR(20) NOT NULL, created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT fk_order_id
+    FOREIGN KEY(order_id)
+    REFERENCES user_order(id)
+    ON DELETE NO ACTION);
+
+CREATE INDEX order_id_history_idx ON order_status_history(order_id);
+
+INSERT INTO order_status_history (order_id, status, created_at)
+SELECT id, status, uploaded_at FROM user_order;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE order_status_history DROP COLUMN payload;
+ALTER TABLE order_status_history DROP COLUMN source;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE order_status_history ADD COLUMN source VARCHAR(20) NOT NULL DEFAULT 'poll';
+ALTER TABLE order_status_history ADD COLUMN payload JSONB;
+
+UPDATE order_status_history SET source = 'upload' WHERE status = 'NEW';
+
+COMMIT;
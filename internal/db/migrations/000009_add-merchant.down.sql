@@ -0,0 +1,13 @@
+BEGIN;
+
+DROP INDEX number_idx;
+CREATE UNIQUE INDEX number_idx ON user_order(order_number);
+ALTER TABLE user_order DROP COLUMN merchant_id;
+
+DROP INDEX username_index;
+CREATE UNIQUE INDEX username_index ON auth_user(username);
+ALTER TABLE auth_user DROP COLUMN merchant_id;
+
+DROP TABLE merchant;
+
+COMMIT;
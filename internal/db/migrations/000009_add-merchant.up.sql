@@ -0,0 +1,31 @@
+BEGIN;
+
+CREATE TABLE merchant (id BIGSERIAL PRIMARY KEY, code VARCHAR(64) NOT NULL, name VARCHAR(255) NOT NULL DEFAULT '', host VARCHAR(255),
+    accrual_address VARCHAR(255), order_validation VARCHAR(255), currency VARCHAR(32) NOT NULL DEFAULT 'points');
+
+CREATE UNIQUE INDEX merchant_code_idx ON merchant(code);
+CREATE UNIQUE INDEX merchant_host_idx ON merchant(host);
+
+-- магазин по умолчанию, к нему относятся все существующие пользователи и заказы
+INSERT INTO merchant (id, code, name) VALUES (1, 'default', 'Gophermart');
+SELECT setval('merchant_id_seq', 1);
+
+ALTER TABLE auth_user ADD COLUMN merchant_id BIGINT NOT NULL DEFAULT 1,
+    ADD CONSTRAINT fk_merchant_id
+    FOREIGN KEY(merchant_id)
+    REFERENCES merchant(id)
+    ON DELETE NO ACTION;
+
+DROP INDEX username_index;
+CREATE UNIQUE INDEX username_index ON auth_user(merchant_id, username);
+
+ALTER TABLE user_order ADD COLUMN merchant_id BIGINT NOT NULL DEFAULT 1,
+    ADD CONSTRAINT fk_merchant_id
+    FOREIGN KEY(merchant_id)
+    REFERENCES merchant(id)
+    ON DELETE NO ACTION;
+
+DROP INDEX number_idx;
+CREATE UNIQUE INDEX number_idx ON user_order(merchant_id, order_number);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE points_expiration;
+ALTER TABLE balance DROP COLUMN expired;
+DROP TABLE balance_lot;
+
+COMMIT;
@@ -0,0 +1,36 @@
+BEGIN;
+
+-- партии начисленных баллов, списываются в порядке сгорания
+CREATE TABLE balance_lot (id BIGSERIAL PRIMARY KEY, user_id BIGINT NOT NULL, order_id BIGINT, amount DOUBLE PRECISION NOT NULL, remaining DOUBLE PRECISION NOT NULL,
+    accrued_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(), expires_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY(user_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION,
+    CONSTRAINT fk_order_id
+    FOREIGN KEY(order_id)
+    REFERENCES user_order(id)
+    ON DELETE NO ACTION);
+
+CREATE INDEX balance_lot_user_idx ON balance_lot(user_id, expires_at) WHERE remaining > 0;
+CREATE INDEX balance_lot_expires_idx ON balance_lot(expires_at) WHERE remaining > 0;
+
+-- баллы, начисленные до появления сгорания, не сгорают
+INSERT INTO balance_lot (user_id, amount, remaining, expires_at)
+SELECT user_id, current, current, NULL FROM balance WHERE current > 0;
+
+ALTER TABLE balance ADD COLUMN expired DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE points_expiration (id BIGSERIAL PRIMARY KEY, user_id BIGINT NOT NULL, lot_id BIGINT NOT NULL, sum DOUBLE PRECISION NOT NULL, expired_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT fk_user_id
+    FOREIGN KEY(user_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION,
+    CONSTRAINT fk_lot_id
+    FOREIGN KEY(lot_id)
+    REFERENCES balance_lot(id)
+    ON DELETE NO ACTION);
+
+CREATE INDEX user_id_expiration_idx ON points_expiration(user_id);
+
+COMMIT;
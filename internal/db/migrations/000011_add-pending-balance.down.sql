@@ -0,0 +1,10 @@
+BEGIN;
+
+UPDATE balance SET current = current + pending;
+
+DROP INDEX balance_lot_pending_idx;
+ALTER TABLE balance_lot DROP COLUMN available_at;
+ALTER TABLE balance_lot DROP COLUMN pending;
+ALTER TABLE balance DROP COLUMN pending;
+
+COMMIT;
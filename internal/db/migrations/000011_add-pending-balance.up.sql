@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE balance ADD COLUMN pending DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- пока партия в холде, её нельзя потратить
+ALTER TABLE balance_lot ADD COLUMN pending BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE balance_lot ADD COLUMN available_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+
+CREATE INDEX balance_lot_pending_idx ON balance_lot(available_at) WHERE pending;
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE transfer (id BIGSERIAL PRIMARY KEY, sender_id BIGINT NOT NULL, receiver_id BIGINT NOT NULL, sum DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT fk_sender_id
+    FOREIGN KEY(sender_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION,
+    CONSTRAINT fk_receiver_id
+    FOREIGN KEY(receiver_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION);
+
+CREATE INDEX transfer_sender_idx ON transfer(sender_id, created_at);
+CREATE INDEX transfer_receiver_idx ON transfer(receiver_id);
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE balance DROP COLUMN debt;
+
+DROP INDEX user_order_processed_idx;
+ALTER TABLE user_order DROP COLUMN processed_at;
+
+COMMIT;
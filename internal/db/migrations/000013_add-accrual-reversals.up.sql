@@ -0,0 +1,18 @@
+BEGIN;
+
+ALTER TABLE user_order ADD COLUMN processed_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE user_order o
+SET processed_at = h.changed_at
+FROM (SELECT order_id, MAX(created_at) AS changed_at
+      FROM order_status_history
+      WHERE status = 'PROCESSED'
+      GROUP BY order_id) AS h
+WHERE o.id = h.order_id AND o.status = 'PROCESSED';
+
+CREATE INDEX user_order_processed_idx ON user_order(processed_at) WHERE status = 'PROCESSED';
+
+-- баллы, которые не удалось списать при отмене начисления; гасятся следующими начислениями
+ALTER TABLE balance ADD COLUMN debt DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+COMMIT;
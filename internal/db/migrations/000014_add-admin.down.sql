@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE audit_log;
+ALTER TABLE auth_user DROP COLUMN status;
+ALTER TABLE auth_user DROP COLUMN role;
+
+COMMIT;
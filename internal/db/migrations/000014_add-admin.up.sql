@@ -0,0 +1,20 @@
+BEGIN;
+
+-- первого администратора назначает утилита setrole
+ALTER TABLE auth_user ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
+ALTER TABLE auth_user ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active';
+
+CREATE TABLE audit_log (id BIGSERIAL PRIMARY KEY, admin_id BIGINT NOT NULL, action VARCHAR(50) NOT NULL, target_user_id BIGINT NOT NULL, details JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT fk_admin_id
+    FOREIGN KEY(admin_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION,
+    CONSTRAINT fk_target_user_id
+    FOREIGN KEY(target_user_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION);
+
+CREATE INDEX audit_log_target_idx ON audit_log(target_user_id);
+
+COMMIT;
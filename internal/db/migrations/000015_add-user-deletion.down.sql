@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE auth_user DROP CONSTRAINT auth_user_status_check;
+ALTER TABLE auth_user DROP COLUMN deleted_at;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+-- пользователи не удаляются физически: заказы, списания и переводы ссылаются на них
+-- и должны сохраниться, поэтому внешние ключи остаются ON DELETE NO ACTION
+ALTER TABLE auth_user ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE auth_user ADD CONSTRAINT auth_user_status_check CHECK (status IN ('active', 'locked', 'deleted'));
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+-- журнал попыток входа; логин может не существовать, поэтому без ссылки на auth_user
+CREATE TABLE login_attempt (id BIGSERIAL PRIMARY KEY, merchant_id BIGINT NOT NULL, username VARCHAR(255) NOT NULL, ip VARCHAR(64) NOT NULL, success BOOLEAN NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW());
+
+CREATE INDEX login_attempt_user_idx ON login_attempt(merchant_id, username, created_at);
+CREATE INDEX login_attempt_ip_idx ON login_attempt(ip, created_at) WHERE NOT success;
+
+COMMIT;
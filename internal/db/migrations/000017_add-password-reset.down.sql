@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE password_reset;
+ALTER TABLE auth_user DROP COLUMN token_version;
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+ALTER TABLE auth_user ADD COLUMN token_version INT NOT NULL DEFAULT 0;
+
+-- хранится только хеш токена сброса
+CREATE TABLE password_reset (id BIGSERIAL PRIMARY KEY, user_id BIGINT NOT NULL, token_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(), expires_at TIMESTAMP WITH TIME ZONE NOT NULL, used_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY(user_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION);
+
+CREATE INDEX password_reset_user_idx ON password_reset(user_id);
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP INDEX username_normalized_index;
+
+COMMIT;
@@ -0,0 +1,23 @@
+BEGIN;
+
+-- логины, совпадающие после нормализации (NFKC, пробелы по краям, регистр), нужно переименовать вручную
+-- до миграции. К виду, в котором их ищет сервис, логины приводит утилита normalizelogins
+DO $$
+DECLARE
+    collisions TEXT;
+BEGIN
+    SELECT string_agg(format('merchant %s: %s', merchant_id, logins), '; ')
+    INTO collisions
+    FROM (SELECT merchant_id, string_agg(username, ', ' ORDER BY id) AS logins
+          FROM auth_user
+          GROUP BY merchant_id, lower(normalize(btrim(username), NFKC))
+          HAVING COUNT(*) > 1) c;
+
+    IF collisions IS NOT NULL THEN
+        RAISE EXCEPTION 'logins collide after normalization: %', collisions;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX username_normalized_index ON auth_user(merchant_id, lower(normalize(btrim(username), NFKC)));
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP TABLE recovery_code;
+
+ALTER TABLE auth_user DROP COLUMN totp_last_step;
+ALTER TABLE auth_user DROP COLUMN totp_enabled;
+ALTER TABLE auth_user DROP COLUMN totp_secret;
+
+COMMIT;
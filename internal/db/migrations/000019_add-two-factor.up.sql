@@ -0,0 +1,19 @@
+BEGIN;
+
+-- секрет хранится до подтверждения, totp_enabled включается только после первого верного кода;
+-- totp_last_step - последний принятый интервал, код нельзя использовать повторно
+ALTER TABLE auth_user ADD COLUMN totp_secret VARCHAR(64);
+ALTER TABLE auth_user ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE auth_user ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- хранятся только хеши кодов восстановления
+CREATE TABLE recovery_code (id BIGSERIAL PRIMARY KEY, user_id BIGINT NOT NULL, code_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(), used_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY(user_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION);
+
+CREATE UNIQUE INDEX recovery_code_user_idx ON recovery_code(user_id, code_hash);
+
+COMMIT;
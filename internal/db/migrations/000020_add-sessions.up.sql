@@ -0,0 +1,14 @@
+BEGIN;
+
+-- сессия создаётся при каждом входе, её id записывается в токен; отозванная сессия больше не принимается
+CREATE TABLE user_session (id BIGSERIAL PRIMARY KEY, user_id BIGINT NOT NULL, device VARCHAR(64) NOT NULL, ip VARCHAR(64) NOT NULL,
+    user_agent VARCHAR(512) NOT NULL, created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(), last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL, revoked_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY(user_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION);
+
+CREATE INDEX user_session_active_idx ON user_session(user_id) WHERE revoked_at IS NULL;
+
+COMMIT;
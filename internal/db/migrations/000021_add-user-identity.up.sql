@@ -0,0 +1,15 @@
+BEGIN;
+
+-- учётная запись внешнего провайдера (OIDC), привязанная к пользователю магазина
+CREATE TABLE user_identity (id BIGSERIAL PRIMARY KEY, user_id BIGINT NOT NULL, merchant_id BIGINT NOT NULL,
+    issuer VARCHAR(255) NOT NULL, subject VARCHAR(255) NOT NULL, email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT fk_user_id
+    FOREIGN KEY(user_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION);
+
+CREATE UNIQUE INDEX user_identity_subject_idx ON user_identity(merchant_id, issuer, subject);
+CREATE INDEX user_identity_user_idx ON user_identity(user_id);
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+-- ключ для запросов сервер-сервер; user_id NULL - ключ магазина, действует от имени пользователя из заголовка.
+-- Хранится только sha256 ключа, prefix - его открытая часть для поиска
+CREATE TABLE api_key (id BIGSERIAL PRIMARY KEY, merchant_id BIGINT NOT NULL, user_id BIGINT, name VARCHAR(64) NOT NULL,
+    prefix VARCHAR(32) NOT NULL, key_hash VARCHAR(64) NOT NULL, scopes TEXT[] NOT NULL, rate_limit INT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(), last_used_at TIMESTAMP WITH TIME ZONE, revoked_at TIMESTAMP WITH TIME ZONE,
+    CONSTRAINT fk_merchant_id
+    FOREIGN KEY(merchant_id)
+    REFERENCES merchant(id)
+    ON DELETE NO ACTION,
+    CONSTRAINT fk_user_id
+    FOREIGN KEY(user_id)
+    REFERENCES auth_user(id)
+    ON DELETE NO ACTION);
+
+CREATE UNIQUE INDEX api_key_prefix_idx ON api_key(prefix);
+CREATE INDEX api_key_owner_idx ON api_key(merchant_id, user_id) WHERE revoked_at IS NULL;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- счётчики запросов, общие для реплик; UNLOGGED - потеря при сбое базы лишь сбрасывает окна
+CREATE UNLOGGED TABLE rate_limit (key VARCHAR(512) PRIMARY KEY, window_start TIMESTAMP WITH TIME ZONE NOT NULL, hits INT NOT NULL);
+
+COMMIT;
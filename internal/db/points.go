@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// погрешность при сравнении сумм в DOUBLE PRECISION
+const pointsEpsilon = 1e-9
+
+// creditBalance начисляет баллы пользователю: с холдом сумма попадает в ожидающие,
+// иначе сразу в доступные, и заводится партия со сроком сгорания по текущей политике
+func (d *Database) creditBalance(ctx context.Context, tx pgx.Tx, userID int, orderID *int, amount float64, holdPeriod time.Duration) error {
+	hold := holdPeriod > 0
+
+	// сначала гасится долг от отменённых начислений
+	var debt float64
+	err := tx.QueryRow(ctx, "SELECT debt FROM balance WHERE user_id = $1 FOR UPDATE", userID).Scan(&debt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to get debt %w", err)
+	}
+	if debt > 0 && amount > 0 {
+		repay := min(debt, amount)
+		_, err = tx.Exec(ctx, "UPDATE balance SET debt = debt - $1 WHERE user_id = $2", repay, userID)
+		if err != nil {
+			return fmt.Errorf("failed to repay debt %w", err)
+		}
+		amount -= repay
+	}
+
+	query := `
+		INSERT INTO balance (user_id, current, withdrawn, pending)
+		VALUES ($1, CASE WHEN $3 THEN 0 ELSE $2 END, 0, CASE WHEN $3 THEN $2 ELSE 0 END)
+		ON CONFLICT(user_id)
+		DO UPDATE SET current = balance.current + EXCLUDED.current,
+		              pending = balance.pending + EXCLUDED.pending
+	`
+	_, err = tx.Exec(ctx, query, userID, amount, hold)
+	if err != nil {
+		return fmt.Errorf("failed to credit balance %w", err)
+	}
+
+	if amount <= 0 {
+		return nil
+	}
+
+	query = `
+		INSERT INTO balance_lot (user_id, order_id, amount, remaining, pending, available_at, expires_at)
+		VALUES ($1, $2, $3, $3, $4, NOW() + $5::interval,
+			CASE WHEN $6::int > 0 THEN NOW() + make_interval(months => $6::int) END)
+	`
+	_, err = tx.Exec(ctx, query, userID, orderID, amount, hold, holdPeriod, d.policy.ExpireMonths)
+	if err != nil {
+		return fmt.Errorf("failed to add lot %w", err)
+	}
+	return nil
+}
+
+// clawBack списывает отменённое начисление: сначала из партий самого заказа, включая ожидающие,
+// затем из доступных партий в порядке сгорания. Что списать не удалось, становится долгом
+func clawBack(ctx context.Context, tx pgx.Tx, userID int, orderID int, sum float64) error {
+	query := `
+		SELECT id, remaining, pending
+		FROM balance_lot
+		WHERE user_id = $1 AND remaining > 0
+		AND (order_id = $2 OR NOT pending)
+		ORDER BY order_id = $2 DESC NULLS LAST, expires_at NULLS LAST, id
+		FOR UPDATE
+	`
+	rows, err := tx.Query(ctx, query, userID, orderID)
+	if err != nil {
+		return fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	type lot struct {
+		ID        int     `db:"id"`
+		Remaining float64 `db:"remaining"`
+		Pending   bool    `db:"pending"`
+	}
+	lots, err := pgx.CollectRows(rows, pgx.RowToStructByName[lot])
+	if err != nil {
+		return fmt.Errorf("failed unpacking rows %w", err)
+	}
+
+	var fromCurrent, fromPending float64
+	for _, l := range lots {
+		if sum <= pointsEpsilon {
+			break
+		}
+		take := min(l.Remaining, sum)
+		_, err = tx.Exec(ctx, "UPDATE balance_lot SET remaining = remaining - $1 WHERE id = $2", take, l.ID)
+		if err != nil {
+			return fmt.Errorf("failed to claw back lot %w", err)
+		}
+		if l.Pending {
+			fromPending += take
+		} else {
+			fromCurrent += take
+		}
+		sum -= take
+	}
+	if sum <= pointsEpsilon {
+		sum = 0
+	}
+
+	query = `
+		UPDATE balance
+		SET current = current - $1,
+		    pending = pending - $2,
+		    debt = debt + $3
+		WHERE user_id = $4
+	`
+	_, err = tx.Exec(ctx, query, fromCurrent, fromPending, sum, userID)
+	if err != nil {
+		return fmt.Errorf("failed to claw back balance %w", err)
+	}
+	return nil
+}
+
+// lotPart - часть партии, списанная при тратах
+type lotPart struct {
+	Amount    float64
+	ExpiresAt *time.Time
+}
+
+// consumeLots списывает сумму с партий пользователя, начиная с тех, что сгорают раньше.
+// Строка баланса пользователя должна быть уже заблокирована в транзакции
+func consumeLots(ctx context.Context, tx pgx.Tx, userID int, sum float64) ([]lotPart, error) {
+	query := `
+		SELECT id, remaining, expires_at
+		FROM balance_lot
+		WHERE user_id = $1 AND remaining > 0 AND NOT pending
+		ORDER BY expires_at NULLS LAST, id
+		FOR UPDATE
+	`
+	rows, err := tx.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	type lot struct {
+		ID        int        `db:"id"`
+		Remaining float64    `db:"remaining"`
+		ExpiresAt *time.Time `db:"expires_at"`
+	}
+	lots, err := pgx.CollectRows(rows, pgx.RowToStructByName[lot])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+
+	var parts []lotPart
+	for _, l := range lots {
+		if sum <= pointsEpsilon {
+			break
+		}
+		take := min(l.Remaining, sum)
+		_, err = tx.Exec(ctx, "UPDATE balance_lot SET remaining = remaining - $1 WHERE id = $2", take, l.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to consume lot %w", err)
+		}
+		parts = append(parts, lotPart{Amount: take, ExpiresAt: l.ExpiresAt})
+		sum -= take
+	}
+	return parts, nil
+}
+
+// expireUserLots списывает с баланса просроченные партии пользователя.
+// Строка баланса пользователя должна быть уже заблокирована в транзакции
+func expireUserLots(ctx context.Context, tx pgx.Tx, userID int) error {
+	query := `
+		WITH picked AS
+			(SELECT id, user_id, remaining
+			 FROM balance_lot
+			 WHERE user_id = $1 AND remaining > 0 AND NOT pending AND expires_at <= NOW()
+			 FOR UPDATE),
+		cleared AS
+			(UPDATE balance_lot
+			 SET remaining = 0
+			 FROM picked
+			 WHERE balance_lot.id = picked.id),
+		logged AS
+			(INSERT INTO points_expiration (user_id, lot_id, sum)
+			 SELECT user_id, id, remaining FROM picked)
+		UPDATE balance
+		SET current = current - total.sum,
+		    expired = expired + total.sum
+		FROM (SELECT SUM(remaining) AS sum FROM picked) AS total
+		WHERE balance.user_id = $1 AND total.sum IS NOT NULL
+	`
+	_, err := tx.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to expire lots %w", err)
+	}
+	return nil
+}
+
+// matureUserLots переводит партии с истёкшим холдом в доступные баллы.
+// Строка баланса пользователя должна быть уже заблокирована в транзакции
+func matureUserLots(ctx context.Context, tx pgx.Tx, userID int) error {
+	query := `
+		WITH picked AS
+			(SELECT id, remaining
+			 FROM balance_lot
+			 WHERE user_id = $1 AND pending AND available_at <= NOW()
+			 FOR UPDATE),
+		matured AS
+			(UPDATE balance_lot
+			 SET pending = false
+			 FROM picked
+			 WHERE balance_lot.id = picked.id)
+		UPDATE balance
+		SET current = current + total.sum,
+		    pending = pending - total.sum
+		FROM (SELECT SUM(remaining) AS sum FROM picked) AS total
+		WHERE balance.user_id = $1 AND total.sum IS NOT NULL
+	`
+	_, err := tx.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to mature lots %w", err)
+	}
+	return nil
+}
+
+// settleUserLots приводит баланс пользователя в актуальное состояние: снимает холд и сжигает просроченное
+func settleUserLots(ctx context.Context, tx pgx.Tx, userID int) error {
+	if err := matureUserLots(ctx, tx, userID); err != nil {
+		return err
+	}
+	return expireUserLots(ctx, tx, userID)
+}
+
+// lockBalance блокирует строку баланса пользователя до конца транзакции.
+// Все изменения баланса и партий идут через эту блокировку, чтобы не было взаимоблокировок
+func lockBalance(ctx context.Context, tx pgx.Tx, userID int) error {
+	_, err := tx.Exec(ctx, "SELECT 1 FROM balance WHERE user_id = $1 FOR UPDATE", userID)
+	if err != nil {
+		return fmt.Errorf("failed to lock balance %w", err)
+	}
+	return nil
+}
+
+// SettlePoints снимает холд и сжигает просроченные баллы не более чем limit пользователей,
+// возвращает количество обработанных пользователей
+func (d *Database) SettlePoints(ctx context.Context, limit int) (int, error) {
+	query := `
+		SELECT user_id FROM balance_lot
+		WHERE pending AND available_at <= NOW()
+		UNION
+		SELECT user_id FROM balance_lot
+		WHERE remaining > 0 AND NOT pending AND expires_at <= NOW()
+		LIMIT $1
+	`
+	rows, err := d.pool.Query(ctx, query, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed collecting rows %w", err)
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, fmt.Errorf("failed unpacking rows %w", err)
+	}
+
+	for _, userID := range users {
+		err := d.settleUser(ctx, userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(users), nil
+}
+
+func (d *Database) settleUser(ctx context.Context, userID int) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := settleUserLots(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
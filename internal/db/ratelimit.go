@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// IncrementRateLimit учитывает запрос по ключу; окно начинается с первого запроса после конца предыдущего.
+// Возвращает число запросов в окне и время до его конца по часам базы, одним для всех реплик
+func (d *Database) IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	query := `
+		INSERT INTO rate_limit (key, window_start, hits)
+		VALUES ($1, NOW(), 1)
+		ON CONFLICT (key) DO UPDATE SET
+			hits = CASE WHEN rate_limit.window_start > NOW() - make_interval(secs => $2) THEN rate_limit.hits + 1 ELSE 1 END,
+			window_start = CASE WHEN rate_limit.window_start > NOW() - make_interval(secs => $2) THEN rate_limit.window_start ELSE NOW() END
+		RETURNING hits, EXTRACT(EPOCH FROM window_start + make_interval(secs => $2) - NOW())::float8
+	`
+	var hits int
+	var remaining float64
+	if err := d.pool.QueryRow(ctx, query, key, window.Seconds()).Scan(&hits, &remaining); err != nil {
+		return 0, 0, fmt.Errorf("failed to increment rate limit %w", err)
+	}
+	return hits, time.Duration(remaining * float64(time.Second)), nil
+}
+
+func (d *Database) DeleteRateLimitsBefore(ctx context.Context, before time.Time) error {
+	if _, err := d.pool.Exec(ctx, "DELETE FROM rate_limit WHERE window_start < $1", before); err != nil {
+		return fmt.Errorf("failed to delete rate limits %w", err)
+	}
+	return nil
+}
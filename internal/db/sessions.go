@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func (d *Database) CreateSession(ctx context.Context, userID int, device string, ip string, userAgent string, expiresAt time.Time) (int, error) {
+	query := `
+		INSERT INTO user_session (user_id, device, ip, user_agent, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	var id int
+	if err := d.pool.QueryRow(ctx, query, userID, device, ip, userAgent, expiresAt).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to create session %w", err)
+	}
+	return id, nil
+}
+
+// TouchSession проверяет, что сессия пользователя действует, и отмечает время последнего запроса
+func (d *Database) TouchSession(ctx context.Context, sessionID int, userID int) error {
+	query := `
+		UPDATE user_session
+		SET last_seen_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING id
+	`
+	var id int
+	if err := d.pool.QueryRow(ctx, query, sessionID, userID).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w", ErrSessionNotFound)
+		}
+		return fmt.Errorf("failed to touch session %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetUserSessions(ctx context.Context, userID int) ([]types.Session, error) {
+	query := `
+		SELECT id, device, ip, user_agent, created_at, last_seen_at
+		FROM user_session
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+	`
+	rows, err := d.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	sessions, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.Session])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return sessions, nil
+}
+
+func (d *Database) RevokeSession(ctx context.Context, userID int, sessionID int) error {
+	query := `
+		UPDATE user_session
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+	tag, err := d.pool.Exec(ctx, query, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrSessionNotFound)
+	}
+	return nil
+}
+
+// RevokeOtherSessions завершает все сессии пользователя, кроме текущей, и отзывает его ключи API
+func (d *Database) RevokeOtherSessions(ctx context.Context, userID int, currentID int) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := revokeSessions(ctx, tx, userID, currentID); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// revokeSessions завершает сессии пользователя, кроме exceptID (0 - все), и отзывает его ключи API:
+// ключ мог выпустить тот, кто захватил одну из сессий
+func revokeSessions(ctx context.Context, tx pgx.Tx, userID int, exceptID int) error {
+	query := `
+		UPDATE user_session
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`
+	if _, err := tx.Exec(ctx, query, userID, exceptID); err != nil {
+		return fmt.Errorf("failed to revoke sessions %w", err)
+	}
+	if _, err := tx.Exec(ctx, "UPDATE api_key SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+		return fmt.Errorf("failed to revoke api keys %w", err)
+	}
+	return nil
+}
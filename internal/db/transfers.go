@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// TransferPoints переводит sum доступных баллов от senderID пользователю receiver того же мерчанта.
+// Получатель получает списанные у отправителя партии с их сроками сгорания. Порог unconfirmedLimit -
+// как у InsertWithdrawAndUpdateBalance
+func (d *Database) TransferPoints(ctx context.Context, merchantID int, senderID int, receiver string, sum float64, unconfirmedLimit float64) error {
+	receiverID, err := d.GetUserID(ctx, merchantID, receiver)
+	if err != nil {
+		return err
+	}
+	if receiverID == senderID {
+		return fmt.Errorf("%w", ErrSelfTransfer)
+	}
+	if d.policy.TransferMaxSum > 0 && sum > d.policy.TransferMaxSum {
+		return fmt.Errorf("%w", ErrTransferLimit)
+	}
+
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// балансы блокируются всегда в одном порядке, чтобы встречные переводы не взаимоблокировались
+	first, second := senderID, receiverID
+	if first > second {
+		first, second = second, first
+	}
+	if err := lockBalance(ctx, tx, first); err != nil {
+		return err
+	}
+	if err := lockBalance(ctx, tx, second); err != nil {
+		return err
+	}
+	if err := settleUserLots(ctx, tx, senderID); err != nil {
+		return err
+	}
+	if err := checkUnconfirmedSpending(ctx, tx, senderID, sum, unconfirmedLimit); err != nil {
+		return err
+	}
+
+	if d.policy.TransferDailyLimit > 0 {
+		query := `
+			SELECT COALESCE(SUM(sum), 0)
+			FROM transfer
+			WHERE sender_id = $1 AND created_at > NOW() - interval '1 day'
+		`
+		var sent float64
+		if err := tx.QueryRow(ctx, query, senderID).Scan(&sent); err != nil {
+			return fmt.Errorf("unexpected DB error %w", err)
+		}
+		if sent+sum > d.policy.TransferDailyLimit+pointsEpsilon {
+			return fmt.Errorf("%w", ErrTransferLimit)
+		}
+	}
+
+	query := `
+		UPDATE balance
+		SET current = current - $1
+		WHERE user_id = $2 AND current >= $1
+		RETURNING 1
+	`
+	var success int
+	if err := tx.QueryRow(ctx, query, sum, senderID).Scan(&success); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w", ErrNotEnoughBalance)
+		}
+		return fmt.Errorf("unexpected DB error %w", err)
+	}
+
+	parts, err := consumeLots(ctx, tx, senderID, sum)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO balance (user_id, current, withdrawn)
+		VALUES ($1, $2, 0)
+		ON CONFLICT(user_id)
+		DO UPDATE SET current = balance.current + EXCLUDED.current
+	`
+	if _, err := tx.Exec(ctx, query, receiverID, sum); err != nil {
+		return fmt.Errorf("failed to credit balance %w", err)
+	}
+
+	query = `
+		INSERT INTO balance_lot (user_id, amount, remaining, expires_at)
+		VALUES ($1, $2, $2, $3)
+	`
+	for _, p := range parts {
+		if _, err := tx.Exec(ctx, query, receiverID, p.Amount, p.ExpiresAt); err != nil {
+			return fmt.Errorf("failed to add lot %w", err)
+		}
+	}
+
+	query = `
+		INSERT INTO transfer (sender_id, receiver_id, sum)
+		VALUES ($1, $2, $3)
+	`
+	if _, err := tx.Exec(ctx, query, senderID, receiverID, sum); err != nil {
+		return fmt.Errorf("unexpected DB error %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// GetUserTransfers возвращает входящие и исходящие переводы пользователя
+func (d *Database) GetUserTransfers(ctx context.Context, userID int, filter types.ListFilter) ([]types.Transfer, error) {
+	comparison, direction := listOrdering(filter.Sort)
+
+	query := fmt.Sprintf(`
+		SELECT t.id,
+			CASE WHEN t.sender_id = $1 THEN 'out' ELSE 'in' END AS direction,
+			u.username AS login,
+			t.sum, t.created_at
+		FROM transfer t
+		JOIN auth_user u ON u.id = CASE WHEN t.sender_id = $1 THEN t.receiver_id ELSE t.sender_id END
+		WHERE (t.sender_id = $1 OR t.receiver_id = $1)
+		AND ($2 = 0 OR t.id %s $2)
+		AND ($3::timestamptz IS NULL OR t.created_at >= $3)
+		AND ($4::timestamptz IS NULL OR t.created_at < $4)
+		ORDER BY t.id %s
+		LIMIT $5
+		`, comparison, direction)
+	rows, err := d.pool.Query(ctx, query, userID, filter.Cursor, filter.From, filter.To, filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	results, err := pgx.CollectRows(rows, pgx.RowToStructByName[types.Transfer])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return results, nil
+}
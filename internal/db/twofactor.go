@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func (d *Database) GetTwoFactor(ctx context.Context, userID int) (*types.TwoFactor, error) {
+	query := `
+		SELECT COALESCE(totp_secret, '') AS totp_secret, totp_enabled, totp_last_step
+		FROM auth_user
+		WHERE id = $1
+	`
+	rows, err := d.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	twoFactor, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[types.TwoFactor])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w", &UserNotFoundError{})
+		}
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return &twoFactor, nil
+}
+
+// SetTwoFactorSecret сохраняет новый секрет до подтверждения; уже включённую защиту так не заменить
+func (d *Database) SetTwoFactorSecret(ctx context.Context, userID int, secret string) error {
+	query := `
+		UPDATE auth_user
+		SET totp_secret = $2
+		WHERE id = $1 AND NOT totp_enabled
+	`
+	tag, err := d.pool.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to set totp secret %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrTwoFactorEnabled)
+	}
+	return nil
+}
+
+// EnableTwoFactor включает защиту после первого верного кода и заменяет коды восстановления
+func (d *Database) EnableTwoFactor(ctx context.Context, userID int, step int64, recoveryHashes []string) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE auth_user
+		SET totp_enabled = TRUE, totp_last_step = $2
+		WHERE id = $1 AND NOT totp_enabled AND totp_secret IS NOT NULL
+	`
+	tag, err := tx.Exec(ctx, query, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to enable totp %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrTwoFactorEnabled)
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int, hashes []string) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_code WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes %w", err)
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec(ctx, "INSERT INTO recovery_code (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return fmt.Errorf("failed to insert recovery code %w", err)
+		}
+	}
+	return nil
+}
+
+// UseTwoFactorStep отмечает интервал TOTP использованным; повторный или более старый код не принимается
+func (d *Database) UseTwoFactorStep(ctx context.Context, userID int, step int64) error {
+	query := `
+		UPDATE auth_user
+		SET totp_last_step = $2
+		WHERE id = $1 AND totp_enabled AND totp_last_step < $2
+	`
+	tag, err := d.pool.Exec(ctx, query, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to use totp step %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrTwoFactorInvalid)
+	}
+	return nil
+}
+
+func (d *Database) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	query := `
+		UPDATE recovery_code
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	tag, err := d.pool.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrTwoFactorInvalid)
+	}
+	return nil
+}
+
+func (d *Database) DisableTwoFactor(ctx context.Context, userID int) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE auth_user
+		SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to disable totp %w", err)
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
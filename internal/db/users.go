@@ -0,0 +1,354 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// DeletedLoginPrefix - префикс логина удалённого пользователя, такие логины нельзя зарегистрировать
+const DeletedLoginPrefix = "deleted-"
+
+// DeleteUser обезличивает пользователя: логин заменяется на служебный, пароль стирается.
+// Заказы, баланс, списания и переводы остаются привязанными к id для отчётности
+func (d *Database) DeleteUser(ctx context.Context, userID int) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := deleteUser(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// AdminDeleteUser удаляет пользователя по запросу, поступившему в поддержку
+func (d *Database) AdminDeleteUser(ctx context.Context, adminID int, userID int) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := deleteUser(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := writeAudit(ctx, tx, adminID, types.AuditUserDelete, userID, nil); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+func deleteUser(ctx context.Context, tx pgx.Tx, userID int) error {
+	// попытки входа хранят логин и адрес, их нужно удалить, пока логин ещё не заменён
+	query := `
+		DELETE FROM login_attempt
+		WHERE (merchant_id, username) = (SELECT merchant_id, username FROM auth_user WHERE id = $1)
+	`
+	if _, err := tx.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to delete login attempts %w", err)
+	}
+
+	query = `
+		UPDATE auth_user
+		SET username = $2 || id,
+		    password = '',
+		    totp_secret = NULL,
+		    totp_enabled = FALSE,
+		    status = 'deleted',
+		    deleted_at = NOW()
+		WHERE id = $1 AND status <> 'deleted'
+	`
+	tag, err := tx.Exec(ctx, query, userID, DeletedLoginPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to delete user %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrUserDeleted)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM user_identity WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete identities %w", err)
+	}
+	if _, err := tx.Exec(ctx, "UPDATE api_key SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+		return fmt.Errorf("failed to revoke api keys %w", err)
+	}
+	// вместе с сессиями удаляются адреса и устройства, с которых входил пользователь
+	if _, err := tx.Exec(ctx, "DELETE FROM user_session WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to delete sessions %w", err)
+	}
+	return nil
+}
+
+func (d *Database) RecordLoginAttempt(ctx context.Context, merchantID int, username string, ip string, success bool) error {
+	query := `
+		INSERT INTO login_attempt (merchant_id, username, ip, success)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := d.pool.Exec(ctx, query, merchantID, username, ip, success)
+	if err != nil {
+		return fmt.Errorf("failed to record login attempt %w", err)
+	}
+	return nil
+}
+
+// GetLoginFailures считает неудачные попытки входа после since: по логину - только после последнего успешного входа
+func (d *Database) GetLoginFailures(ctx context.Context, merchantID int, username string, ip string, since time.Time) (*types.LoginFailures, error) {
+	query := `
+		WITH account AS
+			(SELECT COUNT(*) AS account, MAX(created_at) AS account_last
+			 FROM login_attempt
+			 WHERE merchant_id = $1 AND username = $2 AND NOT success
+			 AND created_at >= GREATEST($4, (SELECT MAX(created_at)
+			                                 FROM login_attempt
+			                                 WHERE merchant_id = $1 AND username = $2 AND success))),
+		by_ip AS
+			(SELECT COUNT(*) AS ip, MAX(created_at) AS ip_last
+			 FROM login_attempt
+			 WHERE ip = $3 AND NOT success AND created_at >= $4)
+		SELECT account, account_last, ip, ip_last
+		FROM account, by_ip
+	`
+	rows, err := d.pool.Query(ctx, query, merchantID, username, ip, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	failures, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[types.LoginFailures])
+	if err != nil {
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return &failures, nil
+}
+
+// UpdatePassword меняет пароль и версию токенов пользователя, все выданные токены перестают действовать
+func (d *Database) UpdatePassword(ctx context.Context, userID int, hashedPassword string) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := updatePassword(ctx, tx, userID, hashedPassword); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+func updatePassword(ctx context.Context, tx pgx.Tx, userID int, hashedPassword string) error {
+	query := `
+		UPDATE auth_user
+		SET password = $1, token_version = token_version + 1
+		WHERE id = $2 AND status <> 'deleted'
+	`
+	tag, err := tx.Exec(ctx, query, hashedPassword, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrUserDeleted)
+	}
+
+	// после смены пароля ранее запрошенные сбросы и открытые сессии недействительны
+	_, err = tx.Exec(ctx, "UPDATE password_reset SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke resets %w", err)
+	}
+	return revokeSessions(ctx, tx, userID, 0)
+}
+
+// RehashPassword заменяет хеш того же пароля, посчитанный со старыми параметрами; токены не отзываются.
+// Если пароль успели сменить, ничего не делает
+func (d *Database) RehashPassword(ctx context.Context, merchantID int, username string, oldHash string, newHash string) error {
+	query := `
+		UPDATE auth_user
+		SET password = $1
+		WHERE merchant_id = $2 AND username = $3 AND password = $4
+	`
+	_, err := d.pool.Exec(ctx, query, newHash, merchantID, username, oldHash)
+	if err != nil {
+		return fmt.Errorf("failed to rehash password %w", err)
+	}
+	return nil
+}
+
+// CreatePasswordReset сохраняет токен сброса, если у пользователя меньше maxActive неиспользованных действующих токенов
+func (d *Database) CreatePasswordReset(ctx context.Context, userID int, tokenHash string, expiresAt time.Time, maxActive int) error {
+	query := `
+		INSERT INTO password_reset (user_id, token_hash, expires_at)
+		SELECT $1, $2, $3
+		WHERE (SELECT COUNT(*)
+		       FROM password_reset
+		       WHERE user_id = $1 AND used_at IS NULL AND expires_at > NOW()) < $4
+	`
+	tag, err := d.pool.Exec(ctx, query, userID, tokenHash, expiresAt, maxActive)
+	if err != nil {
+		return fmt.Errorf("failed to create password reset %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w", ErrTooManyResets)
+	}
+	return nil
+}
+
+// GetPasswordResetUser возвращает пользователя, которому выдан действующий токен сброса
+func (d *Database) GetPasswordResetUser(ctx context.Context, tokenHash string) (*types.User, error) {
+	query := `
+		SELECT auth_user.id, username, merchant_id, role, status, token_version
+		FROM password_reset
+		JOIN auth_user ON auth_user.id = password_reset.user_id
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`
+
+	rows, err := d.pool.Query(ctx, query, tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed collecting rows %w", err)
+	}
+
+	user, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[types.User])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w", ErrResetTokenInvalid)
+		}
+		return nil, fmt.Errorf("failed unpacking rows %w", err)
+	}
+	return &user, nil
+}
+
+// ResetPassword по действующему токену сброса устанавливает новый пароль, токен одноразовый
+func (d *Database) ResetPassword(ctx context.Context, tokenHash string, hashedPassword string) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT user_id
+		FROM password_reset
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`
+	var userID int
+	if err := tx.QueryRow(ctx, query, tokenHash).Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w", ErrResetTokenInvalid)
+		}
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := updatePassword(ctx, tx, userID, hashedPassword); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// NormalizeLogins приводит к виду normalize логины, заведённые до нормализации. Если после приведения
+// логины совпадут, ничего не меняет и возвращает ErrLoginCollision: такие логины переименовывают вручную
+func (d *Database) NormalizeLogins(ctx context.Context, normalize func(string) string) error {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// латиница в нижнем регистре, цифры и знаки ASCII при нормализации не меняются, такие логины не читаем
+	rows, err := tx.Query(ctx, `
+		SELECT id, merchant_id, username
+		FROM auth_user
+		WHERE status <> 'deleted' AND username !~ '^[a-z0-9._@-]+$'
+		ORDER BY id
+		FOR UPDATE`)
+	if err != nil {
+		return fmt.Errorf("failed to select logins %w", err)
+	}
+
+	type login struct {
+		merchantID int
+		username   string
+	}
+	type rename struct {
+		id   int
+		from login
+		to   login
+	}
+	var renames []rename
+	var id int
+	var from login
+	_, err = pgx.ForEachRow(rows, []any{&id, &from.merchantID, &from.username}, func() error {
+		to := login{merchantID: from.merchantID, username: normalize(from.username)}
+		if to != from {
+			renames = append(renames, rename{id: id, from: from, to: to})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read logins %w", err)
+	}
+
+	// логины, которые после приведения совпадут между собой или с уже нормализованным логином
+	var order []login
+	same := make(map[login][]string)
+	for _, r := range renames {
+		if _, ok := same[r.to]; !ok {
+			order = append(order, r.to)
+			var taken bool
+			err := tx.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM auth_user WHERE merchant_id = $1 AND username = $2)",
+				r.to.merchantID, r.to.username).Scan(&taken)
+			if err != nil {
+				return fmt.Errorf("failed to check login %w", err)
+			}
+			if taken {
+				same[r.to] = []string{r.to.username}
+			}
+		}
+		same[r.to] = append(same[r.to], r.from.username)
+	}
+	var collisions []string
+	for _, l := range order {
+		if len(same[l]) > 1 {
+			collisions = append(collisions, fmt.Sprintf("merchant %d: %q", l.merchantID, same[l]))
+		}
+	}
+	if len(collisions) > 0 {
+		return fmt.Errorf("%w: %s", ErrLoginCollision, strings.Join(collisions, "; "))
+	}
+
+	for _, r := range renames {
+		_, err := tx.Exec(ctx, "UPDATE auth_user SET username = $1 WHERE id = $2", r.to.username, r.id)
+		if err != nil {
+			return fmt.Errorf("failed to normalize login %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"sync"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// Размер буфера подписчика. Если клиент не успевает вычитывать события,
+// новые события для него отбрасываются, чтобы не блокировать обработку заказов
+const subscriberBuffer = 16
+
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan types.OrderEvent]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[int]map[chan types.OrderEvent]struct{}),
+	}
+}
+
+func (b *Broker) Subscribe(userID int) (<-chan types.OrderEvent, func()) {
+	ch := make(chan types.OrderEvent, subscriberBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan types.OrderEvent]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subscribers[userID], ch)
+			if len(b.subscribers[userID]) == 0 {
+				delete(b.subscribers, userID)
+			}
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+func (b *Broker) Publish(event types.OrderEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			logger.Warningf("Subscriber of user %d is too slow, dropping event for order %s", event.UserID, event.Number)
+		}
+	}
+}
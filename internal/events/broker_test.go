@@ -0,0 +1,52 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func TestBroker(t *testing.T) {
+
+	b := NewBroker()
+
+	t.Run("only own events", func(t *testing.T) {
+		events, unsubscribe := b.Subscribe(1)
+		defer unsubscribe()
+
+		b.Publish(types.OrderEvent{UserID: 2, Number: "0", Status: types.ProcessedStatus})
+		b.Publish(types.OrderEvent{UserID: 1, Number: "49927398716", Status: types.ProcessedStatus, Accrual: 10})
+
+		assert.Equal(t, types.OrderEvent{UserID: 1, Number: "49927398716", Status: types.ProcessedStatus, Accrual: 10}, <-events)
+		assert.Len(t, events, 0)
+	})
+
+	t.Run("several subscribers", func(t *testing.T) {
+		first, unsubscribeFirst := b.Subscribe(1)
+		second, unsubscribeSecond := b.Subscribe(1)
+		defer unsubscribeSecond()
+
+		b.Publish(types.OrderEvent{UserID: 1, Number: "0", Status: types.InvalidStatus})
+		assert.Equal(t, types.InvalidStatus, (<-first).Status)
+		assert.Equal(t, types.InvalidStatus, (<-second).Status)
+
+		unsubscribeFirst()
+		unsubscribeFirst()
+		_, ok := <-first
+		assert.False(t, ok)
+
+		b.Publish(types.OrderEvent{UserID: 1, Number: "0", Status: types.ProcessedStatus})
+		assert.Equal(t, types.ProcessedStatus, (<-second).Status)
+	})
+
+	t.Run("slow subscriber does not block", func(t *testing.T) {
+		events, unsubscribe := b.Subscribe(3)
+		defer unsubscribe()
+
+		for range subscriberBuffer + 5 {
+			b.Publish(types.OrderEvent{UserID: 3, Number: "0", Status: types.ProcessingStatus})
+		}
+		assert.Len(t, events, subscriberBuffer)
+	})
+}
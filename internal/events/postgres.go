@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+const relayRetryInterval = 5 * time.Second
+
+type Store interface {
+	NotifyOrderEvent(ctx context.Context, event types.OrderEvent) error
+	ListenOrderEvents(ctx context.Context, handle func(types.OrderEvent)) error
+}
+
+// PGPublisher рассылает события через NOTIFY, чтобы их получили все реплики,
+// включая текущую (через Relay)
+type PGPublisher struct {
+	store Store
+}
+
+func NewPGPublisher(store Store) *PGPublisher {
+	return &PGPublisher{store: store}
+}
+
+func (p *PGPublisher) Publish(event types.OrderEvent) {
+	err := p.store.NotifyOrderEvent(context.Background(), event)
+	if err != nil {
+		logger.Errorf("Could not notify order event %s", err.Error())
+	}
+}
+
+// Relay пересылает события, полученные через LISTEN, в локальный брокер
+func Relay(ctx context.Context, store Store, broker *Broker) {
+	go func(ctx context.Context) {
+		for {
+			err := store.ListenOrderEvents(ctx, broker.Publish)
+			if err != nil && ctx.Err() == nil {
+				logger.Errorf("Listening order events failed, will retry: %s", err.Error())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(relayRetryInterval):
+			}
+		}
+	}(ctx)
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/accrual"
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// handleAuthorizeStaff находит сотрудника, выполняющего запрос. Права роли проверяет auth.RequirePermission,
+// статус пользователя - auth.AuthenticateMiddleware, здесь же отсекаются токены, выданные до смены роли
+func (h *HandlerSet) handleAuthorizeStaff(w http.ResponseWriter, req *http.Request) (*types.User, error) {
+	claims, ok := auth.GetAuthenticatedClaims(req)
+	if !ok {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return nil, fmt.Errorf("authentication error")
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.MerchantID != m.ID {
+		http.Error(w, "User not authenticated",
+			http.StatusUnauthorized)
+		return nil, fmt.Errorf("token issued for merchant %d", claims.MerchantID)
+	}
+
+	staff, err := h.database.GetUser(req.Context(), m.ID, claims.Username)
+	if err != nil {
+		http.Error(w, "User not found",
+			http.StatusUnauthorized)
+		return nil, err
+	}
+	if staff.Role != claims.Role {
+		http.Error(w, "Role changed, log in again",
+			http.StatusUnauthorized)
+		return nil, fmt.Errorf("user %d role changed", staff.ID)
+	}
+	return staff, nil
+}
+
+// adminTargetUser находит пользователя из пути запроса в магазине администратора
+func (h *HandlerSet) adminTargetUser(w http.ResponseWriter, req *http.Request, admin *types.User) (*types.User, error) {
+	user, err := h.database.GetUser(req.Context(), admin.MerchantID, auth.NormalizeLogin(chi.URLParam(req, "login")))
+	if err != nil {
+		var notFound *db.UserNotFoundError
+		if errors.As(err, &notFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return nil, err
+		}
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return nil, err
+	}
+	return user, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	response, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Could not serialize result",
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	_, err = w.Write(response)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+	}
+}
+
+func (h *HandlerSet) HandleAdminGetUser(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+
+	summary, err := h.database.GetUserSummary(req.Context(), admin.MerchantID, auth.NormalizeLogin(chi.URLParam(req, "login")))
+	if err != nil {
+		var notFound *db.UserNotFoundError
+		if errors.As(err, &notFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+	if err := h.database.WriteAudit(req.Context(), admin.ID, types.AuditUserView, summary.ID, nil); err != nil {
+		logger.Error(err)
+	}
+	writeJSON(w, summary)
+}
+
+func (h *HandlerSet) HandleAdminGetUserAudit(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+	user, err := h.adminTargetUser(w, req, admin)
+	if err != nil {
+		return
+	}
+
+	entries, err := h.database.GetUserAudit(req.Context(), user.ID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+func (h *HandlerSet) HandleAdminAdjustBalance(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Sum    float64 `json:"sum"`
+		Reason string  `json:"reason"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Sum == 0 || data.Reason == "" {
+		http.Error(w, "Sum and reason are required",
+			http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := h.adminTargetUser(w, req, admin)
+	if err != nil {
+		return
+	}
+
+	err = h.database.AdjustBalance(req.Context(), admin.ID, user.ID, data.Sum, data.Reason)
+	if err != nil && errors.Is(err, db.ErrNotEnoughBalance) {
+		http.Error(w, "Not enough balance",
+			http.StatusPaymentRequired)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerSet) handleAdminSetUserStatus(w http.ResponseWriter, req *http.Request, status types.UserStatus) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+	user, err := h.adminTargetUser(w, req, admin)
+	if err != nil {
+		return
+	}
+	if user.ID == admin.ID {
+		http.Error(w, "Cannot change own status", http.StatusBadRequest)
+		return
+	}
+
+	err = h.database.SetUserStatus(req.Context(), admin.ID, user.ID, status)
+	if err != nil && errors.Is(err, db.ErrUserDeleted) {
+		http.Error(w, "User is deleted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerSet) HandleAdminDeleteUser(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+	user, err := h.adminTargetUser(w, req, admin)
+	if err != nil {
+		return
+	}
+	if user.ID == admin.ID {
+		http.Error(w, "Cannot delete yourself", http.StatusBadRequest)
+		return
+	}
+
+	err = h.database.AdminDeleteUser(req.Context(), admin.ID, user.ID)
+	if err != nil && errors.Is(err, db.ErrUserDeleted) {
+		http.Error(w, "User is deleted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerSet) HandleAdminLockUser(w http.ResponseWriter, req *http.Request) {
+	h.handleAdminSetUserStatus(w, req, types.UserLocked)
+}
+
+func (h *HandlerSet) HandleAdminUnlockUser(w http.ResponseWriter, req *http.Request) {
+	h.handleAdminSetUserStatus(w, req, types.UserActive)
+}
+
+// HandleAdminRecheckOrder запрашивает статус заказа в системе начислений вне очереди
+func (h *HandlerSet) HandleAdminRecheckOrder(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	order, err := h.database.GetMerchantOrder(req.Context(), m.ID, chi.URLParam(req, "number"))
+	if err != nil {
+		if errors.Is(err, db.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+	if order.Status == types.InvalidStatus {
+		http.Error(w, "Order is invalid", http.StatusConflict)
+		return
+	}
+
+	result, err := m.Accrual.GetOrderStatus(order.OrderNum)
+	if err != nil {
+		var errThrottle *accrual.ErrThrottle
+		switch {
+		case errors.As(err, &errThrottle):
+			w.Header().Set("Retry-After", strconv.Itoa(errThrottle.RetryAfter))
+			http.Error(w, "Accrual system is busy", http.StatusTooManyRequests)
+		case errors.Is(err, accrual.ErrOrderNotExists):
+			http.Error(w, "Order not registered in accrual system", http.StatusNotFound)
+		default:
+			logger.Error(err)
+			http.Error(w, "Accrual system error", http.StatusBadGateway)
+		}
+		return
+	}
+
+	if result.Status != order.Status || result.Accrual != order.Accrual {
+		if order.Status == types.ProcessedStatus {
+			err = h.database.UpdateProcessedOrder(req.Context(), order.OrderID, result.Status, result.Accrual, types.SourceAdmin, result.Raw)
+		} else {
+			err = h.database.UpdateUnprocessedOrder(req.Context(), order.OrderID, result.Status, result.Accrual, types.SourceAdmin, result.Raw)
+		}
+		if err != nil {
+			logger.Error(err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		h.broker.Publish(types.OrderEvent{
+			UserID:  order.UserID,
+			Number:  order.OrderNum,
+			Status:  result.Status,
+			Accrual: result.Accrual,
+		})
+	}
+
+	details := map[string]any{"order": order.OrderNum, "from": order.Status, "to": result.Status, "accrual": result.Accrual}
+	if err := h.database.WriteAudit(req.Context(), admin.ID, types.AuditOrderRecheck, order.UserID, details); err != nil {
+		logger.Error(err)
+	}
+
+	writeJSON(w, result)
+}
+
+func (h *HandlerSet) HandleAdminReassignOrder(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Login string `json:"login"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Login == "" {
+		http.Error(w, "Could not parse body",
+			http.StatusUnprocessableEntity)
+		return
+	}
+
+	order, err := h.database.GetMerchantOrder(req.Context(), admin.MerchantID, chi.URLParam(req, "number"))
+	if err != nil {
+		if errors.Is(err, db.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.database.GetUser(req.Context(), admin.MerchantID, auth.NormalizeLogin(data.Login))
+	if err != nil {
+		var notFound *db.UserNotFoundError
+		if errors.As(err, &notFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.database.ReassignOrder(req.Context(), admin.ID, order.OrderID, user.ID)
+	if err != nil && errors.Is(err, db.ErrSameOwner) {
+		http.Error(w, "Order already belongs to user", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *HandlerSet) HandleAdminSetUserRole(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Role types.UserRole `json:"role"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || !data.Role.Valid() {
+		http.Error(w, "Unknown role",
+			http.StatusUnprocessableEntity)
+		return
+	}
+
+	user, err := h.adminTargetUser(w, req, admin)
+	if err != nil {
+		return
+	}
+	if user.ID == admin.ID {
+		http.Error(w, "Cannot change own role", http.StatusBadRequest)
+		return
+	}
+
+	err = h.database.ChangeUserRole(req.Context(), admin.ID, user.ID, data.Role)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
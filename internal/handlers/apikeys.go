@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+const (
+	// сколько действующих ключей может быть у пользователя или магазина
+	maxAPIKeys = 10
+	// размер колонки api_key.name
+	maxAPIKeyNameLength = 64
+)
+
+type apiKeyCreated struct {
+	types.APIKey
+	// Key показывается только при создании, в базе хранится его хеш
+	Key string `json:"key"`
+}
+
+// createAPIKey выпускает ключ пользователя или, если userID nil, ключ магазина.
+// Ключ пользователя выдаётся только после проверки пароля. Лимит запросов не выше maxRateLimit, 0 - без ограничения сверху
+func (h *HandlerSet) createAPIKey(w http.ResponseWriter, req *http.Request, merchantID int, userID *int, role types.UserRole, maxRateLimit int) {
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Name        string   `json:"name"`
+		Scopes      []string `json:"scopes"`
+		RateLimit   int      `json:"rate_limit"`
+		Password    string   `json:"password"`
+		ReauthToken string   `json:"reauth_token"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Name == "" || utf8.RuneCountInString(data.Name) > maxAPIKeyNameLength {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+	if auth.ValidateScopes(role, data.Scopes) != nil {
+		http.Error(w, "Invalid scopes", http.StatusBadRequest)
+		return
+	}
+	if data.RateLimit == 0 {
+		data.RateLimit = h.apiKeyRateLimit
+	}
+	if data.RateLimit < 0 || (maxRateLimit > 0 && data.RateLimit > maxRateLimit) {
+		http.Error(w, "Invalid rate limit", http.StatusBadRequest)
+		return
+	}
+	if userID != nil {
+		if data.Password == "" && data.ReauthToken == "" {
+			http.Error(w, "Password is required", http.StatusBadRequest)
+			return
+		}
+		if !h.confirmUser(w, req, merchantID, *userID, data.Password, data.ReauthToken) {
+			return
+		}
+	}
+
+	keys, err := h.database.GetAPIKeys(req.Context(), merchantID, userID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(keys) >= maxAPIKeys {
+		http.Error(w, "Too many API keys", http.StatusConflict)
+		return
+	}
+
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	id, err := h.database.CreateAPIKey(req.Context(), merchantID, userID, data.Name, prefix, auth.HashAPIKey(key), data.Scopes, data.RateLimit)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(apiKeyCreated{
+		APIKey: types.APIKey{ID: id, Name: data.Name, Prefix: prefix, Scopes: data.Scopes, RateLimit: data.RateLimit},
+		Key:    key,
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+func (h *HandlerSet) writeAPIKeys(w http.ResponseWriter, req *http.Request, merchantID int, userID *int) {
+	keys, err := h.database.GetAPIKeys(req.Context(), merchantID, userID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, keys)
+}
+
+func (h *HandlerSet) revokeAPIKey(w http.ResponseWriter, req *http.Request, merchantID int, userID *int) {
+	keyID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	err = h.database.RevokeAPIKey(req.Context(), merchantID, userID, keyID)
+	if err != nil && errors.Is(err, db.ErrAPIKeyNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleCreateAPIKey выпускает ключ, действующий от имени пользователя, по паролю; лимит запросов не выше настроенного
+func (h *HandlerSet) HandleCreateAPIKey(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+	claims, _ := auth.GetAuthenticatedClaims(req)
+	h.createAPIKey(w, req, claims.MerchantID, &userID, claims.Role, h.apiKeyRateLimit)
+}
+
+func (h *HandlerSet) HandleGetAPIKeys(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+	claims, _ := auth.GetAuthenticatedClaims(req)
+	h.writeAPIKeys(w, req, claims.MerchantID, &userID)
+}
+
+func (h *HandlerSet) HandleRevokeAPIKey(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+	claims, _ := auth.GetAuthenticatedClaims(req)
+	h.revokeAPIKey(w, req, claims.MerchantID, &userID)
+}
+
+// HandleAdminCreateAPIKey выпускает ключ магазина: бэкенд магазина называет пользователя в заголовке X-On-Behalf-Of
+func (h *HandlerSet) HandleAdminCreateAPIKey(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+	h.createAPIKey(w, req, admin.MerchantID, nil, types.RoleUser, 0)
+}
+
+func (h *HandlerSet) HandleAdminGetAPIKeys(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+	h.writeAPIKeys(w, req, admin.MerchantID, nil)
+}
+
+func (h *HandlerSet) HandleAdminRevokeAPIKey(w http.ResponseWriter, req *http.Request) {
+	admin, err := h.handleAuthorizeStaff(w, req)
+	if err != nil {
+		return
+	}
+	h.revokeAPIKey(w, req, admin.MerchantID, nil)
+}
@@ -1,36 +1,142 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/go-chi/chi/v5"
 	logger "github.com/sirupsen/logrus"
 	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/compress"
 	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/events"
+	"github.com/wellywell/bonusy/internal/merchant"
+	"github.com/wellywell/bonusy/internal/notify"
+	"github.com/wellywell/bonusy/internal/oidc"
 	"github.com/wellywell/bonusy/internal/types"
-	"github.com/wellywell/bonusy/internal/validate"
 )
 
 type HandlerSet struct {
 	secret               []byte
-	cookieExpiresSeconds int
+	cookie               auth.CookieOptions
 	database             *db.Database
+	broker               *events.Broker
+	loginThrottle        *auth.LoginThrottle
+	passwordPolicy       auth.PasswordPolicy
+	hasher               *auth.Hasher
+	notifier             notify.Notifier
+	withdrawTwoFactorSum float64
+	oidc                 *oidc.Provider
+	// apiKeyRateLimit - лимит запросов в минуту для ключа по умолчанию и наибольший для ключа пользователя
+	apiKeyRateLimit int
 }
 
+const streamKeepAliveInterval = 15 * time.Second
+
 var (
 	ErrCouldNotParseBody = errors.New("could not parse body")
 	ErrAuthDataEmpty     = errors.New("login or password cannot be empty")
+	ErrReservedLogin     = errors.New("login is reserved")
 )
 
-func NewHandlerSet(secret []byte, cookieExpiresSecs int, database *db.Database) *HandlerSet {
+const maxOrdersBatchSize = 1000
+
+// логины старых пользователей не проверялись, поэтому при входе ограничение - только размер колонки
+const maxLoginLength = 255
+
+func NewHandlerSet(secret []byte, cookie auth.CookieOptions, database *db.Database, broker *events.Broker, loginPolicy auth.LoginPolicy,
+	passwordPolicy auth.PasswordPolicy, hasher *auth.Hasher, notifier notify.Notifier, withdrawTwoFactorSum float64,
+	oidcProvider *oidc.Provider, apiKeyRateLimit int) *HandlerSet {
 	return &HandlerSet{
 		secret:               secret,
-		cookieExpiresSeconds: cookieExpiresSecs,
+		cookie:               cookie,
 		database:             database,
+		broker:               broker,
+		loginThrottle:        auth.NewLoginThrottle(database, loginPolicy),
+		passwordPolicy:       passwordPolicy,
+		hasher:               hasher,
+		notifier:             notifier,
+		withdrawTwoFactorSum: withdrawTwoFactorSum,
+		oidc:                 oidcProvider,
+		apiKeyRateLimit:      apiKeyRateLimit,
+	}
+}
+
+// clientIP - адрес клиента; за доверенным прокси его подставляет request.TrustedProxies
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// readBody читает тело запроса: больше лимита маршрута - 413, повреждённое сжатое - 400
+func readBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(req.Body)
+	if err == nil {
+		return body, nil
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, compress.ErrInvalidGzip):
+		http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+	default:
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
 	}
+	return nil, err
+}
+
+// checkPassword проверяет пароль пользователя и при необходимости пересчитывает хеш с текущими параметрами.
+// Для неизвестного пользователя проверка занимает столько же времени и возвращает false
+func (h *HandlerSet) checkPassword(ctx context.Context, merchantID int, username string, password string) (bool, error) {
+	hash, err := h.database.GetUserHashedPassword(ctx, merchantID, username)
+	var userNotFound *db.UserNotFoundError
+	if err != nil && !errors.As(err, &userNotFound) {
+		return false, err
+	}
+	if err != nil || hash == "" {
+		return false, h.hasher.VerifyDummy(ctx, password)
+	}
+
+	ok, rehash, err := h.hasher.Verify(ctx, password, hash)
+	if err != nil || !ok {
+		return false, err
+	}
+	if rehash {
+		// пароль уже проверен, неудачный пересчёт не должен мешать входу
+		newHash, err := h.hasher.Hash(ctx, password)
+		if err == nil {
+			err = h.database.RehashPassword(ctx, merchantID, username, hash, newHash)
+		}
+		if err != nil {
+			logger.Error(err)
+		}
+	}
+	return true, nil
+}
+
+func (h *HandlerSet) handlePasswordHashError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrHasherBusy) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server is busy, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	logger.Error(err)
+	http.Error(w, "Something went wrong", http.StatusInternalServerError)
 }
 
 func (h *HandlerSet) parseAuthData(body []byte) (username string, password string, err error) {
@@ -45,11 +151,18 @@ func (h *HandlerSet) parseAuthData(body []byte) (username string, password strin
 		return "", "", ErrCouldNotParseBody
 	}
 
-	if data.Username == "" || data.Password == "" {
+	username = auth.NormalizeLogin(data.Username)
+	if username == "" || data.Password == "" {
 		return "", "", ErrAuthDataEmpty
 	}
+	if utf8.RuneCountInString(username) > maxLoginLength {
+		return "", "", fmt.Errorf("%w: too long", auth.ErrInvalidLogin)
+	}
+	if strings.HasPrefix(username, db.DeletedLoginPrefix) {
+		return "", "", ErrReservedLogin
+	}
 
-	return data.Username, data.Password, nil
+	return username, data.Password, nil
 
 }
 
@@ -61,6 +174,12 @@ func (h *HandlerSet) handleAuthErrors(err error, w http.ResponseWriter) {
 	} else if errors.Is(err, ErrAuthDataEmpty) {
 		http.Error(w, "Login and password cannot be empty",
 			http.StatusBadRequest)
+	} else if errors.Is(err, ErrReservedLogin) {
+		http.Error(w, "Login is reserved",
+			http.StatusBadRequest)
+	} else if errors.Is(err, auth.ErrInvalidLogin) {
+		http.Error(w, err.Error(),
+			http.StatusBadRequest)
 	} else {
 		http.Error(w, "Unknown error", http.StatusInternalServerError)
 	}
@@ -68,10 +187,8 @@ func (h *HandlerSet) handleAuthErrors(err error, w http.ResponseWriter) {
 
 func (h *HandlerSet) HandleLogin(w http.ResponseWriter, req *http.Request) {
 
-	body, err := io.ReadAll(req.Body)
+	body, err := readBody(w, req)
 	if err != nil {
-		http.Error(w, "Something went wrong",
-			http.StatusInternalServerError)
 		return
 	}
 
@@ -82,26 +199,128 @@ func (h *HandlerSet) HandleLogin(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	passwordInDB, err := h.database.GetUserHashedPassword(req.Context(), username)
+	m, err := h.requestMerchant(w, req)
 	if err != nil {
-		var userNotFound *db.UserNotFoundError
-		if errors.As(err, &userNotFound) {
-			http.Error(w, "User not found", http.StatusUnauthorized)
-			return
+		return
+	}
+
+	ip := clientIP(req)
+
+	if h.loginThrottled(w, req, m.ID, username, ip) {
+		return
+	}
+
+	// на неизвестный логин и неверный пароль ответ одинаковый, чтобы нельзя было перебирать логины
+	ok, err := h.checkPassword(req.Context(), m.ID, username, password)
+	if err != nil {
+		h.handlePasswordHashError(w, err)
+		return
+	}
+	if !ok {
+		if err := h.loginThrottle.Record(req.Context(), m.ID, username, ip, false); err != nil {
+			logger.Error(err)
 		}
+		http.Error(w, "Wrong login or password", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.database.GetUser(req.Context(), m.ID, username)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if user.Status != types.UserActive {
+		http.Error(w, "User is locked", http.StatusForbidden)
+		return
+	}
 
-	if !auth.CheckPasswordHash(password, passwordInDB) {
-		http.Error(w, "Wrong password", http.StatusUnauthorized)
+	twoFactor, err := h.database.GetTwoFactor(req.Context(), user.ID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
 		return
 	}
+	// успешный вход засчитывается только после второго шага, иначе пароль сбрасывал бы счётчик неверных кодов
+	if twoFactor.Enabled {
+		h.requireSecondFactor(w, user)
+		return
+	}
+
+	h.completeLogin(w, req, user, ip)
+}
 
-	err = auth.SetAuthCookie(username, w, h.secret, h.cookieExpiresSeconds)
+// loginThrottled отвечает 429, если попытки входа для логина или адреса временно заблокированы
+func (h *HandlerSet) loginThrottled(w http.ResponseWriter, req *http.Request, merchantID int, username string, ip string) bool {
+	wait, err := h.loginThrottle.Check(req.Context(), merchantID, username, ip)
 	if err != nil {
+		logger.Error(err)
 		http.Error(w, "Something went wrong",
 			http.StatusInternalServerError)
+		return true
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "Too many login attempts", http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
+
+// recordLoginFailure учитывает неверный пароль или код: они блокируют дальнейшие попытки, как при входе
+func (h *HandlerSet) recordLoginFailure(req *http.Request, merchantID int, username string, ip string) {
+	if err := h.loginThrottle.Record(req.Context(), merchantID, username, ip, false); err != nil {
+		logger.Error(err)
+	}
+}
+
+// confirmUser повторно проверяет вошедшего пользователя перед изменением учётной записи: по паролю или,
+// у кого пароля нет, по токену повторного входа у провайдера (POST /api/user/oidc/reauth).
+// Неверный пароль учитывается как неудачный вход. Ответ при ошибке уже отправлен
+func (h *HandlerSet) confirmUser(w http.ResponseWriter, req *http.Request, merchantID int, userID int, password string, reauthToken string) bool {
+	claims, _ := auth.GetAuthenticatedClaims(req)
+	login := auth.NormalizeLogin(claims.Username)
+	ip := clientIP(req)
+	if h.loginThrottled(w, req, merchantID, login, ip) {
+		return false
+	}
+
+	if password == "" {
+		reauth, err := auth.GetReauthClaims(reauthToken, h.secret)
+		if err != nil || reauth.UserID != userID || reauth.SessionID != claims.SessionID {
+			http.Error(w, "Confirmation expired, sign in again", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	ok, err := h.checkPassword(req.Context(), merchantID, login, password)
+	if err != nil {
+		h.handlePasswordHashError(w, err)
+		return false
+	}
+	if !ok {
+		h.recordLoginFailure(req, merchantID, login, ip)
+		http.Error(w, "Wrong password", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// loginSucceeded засчитывает успешный вход и выдаёт авторизационную куку
+func (h *HandlerSet) loginSucceeded(w http.ResponseWriter, req *http.Request, user *types.User, ip string) error {
+	if err := h.loginThrottle.Record(req.Context(), user.MerchantID, auth.NormalizeLogin(user.Login), ip, true); err != nil {
+		logger.Error(err)
+	}
+	return h.startSession(w, req, user)
+}
+
+func (h *HandlerSet) completeLogin(w http.ResponseWriter, req *http.Request, user *types.User, ip string) {
+	err := h.loginSucceeded(w, req, user, ip)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("content-type", "text/plain")
@@ -115,10 +334,8 @@ func (h *HandlerSet) HandleLogin(w http.ResponseWriter, req *http.Request) {
 
 func (h *HandlerSet) HandleRegisterUser(w http.ResponseWriter, req *http.Request) {
 
-	body, err := io.ReadAll(req.Body)
+	body, err := readBody(w, req)
 	if err != nil {
-		http.Error(w, "Something went wrong",
-			http.StatusInternalServerError)
 		return
 	}
 
@@ -129,14 +346,28 @@ func (h *HandlerSet) HandleRegisterUser(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	hashed, err := auth.HashPassword(password)
+	m, err := h.requestMerchant(w, req)
 	if err != nil {
-		http.Error(w, "Something went wrong",
-			http.StatusInternalServerError)
 		return
 	}
 
-	err = h.database.CreateUser(req.Context(), username, hashed)
+	if err := auth.ValidateLogin(username); err != nil {
+		h.handleAuthErrors(err, w)
+		return
+	}
+
+	if err := h.passwordPolicy.Validate(username, password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashed, err := h.hasher.Hash(req.Context(), password)
+	if err != nil {
+		h.handlePasswordHashError(w, err)
+		return
+	}
+
+	err = h.database.CreateUser(req.Context(), m.ID, username, hashed)
 	if err != nil {
 		var userExists *db.UserExistsError
 		if errors.As(err, &userExists) {
@@ -147,7 +378,13 @@ func (h *HandlerSet) HandleRegisterUser(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	err = auth.SetAuthCookie(username, w, h.secret, h.cookieExpiresSeconds)
+	user, err := h.database.GetUser(req.Context(), m.ID, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.startSession(w, req, user)
 	if err != nil {
 		http.Error(w, "Something went wrong",
 			http.StatusInternalServerError)
@@ -168,10 +405,13 @@ func (h *HandlerSet) HandlePostWithdraw(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	body, err := io.ReadAll(req.Body)
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
 	if err != nil {
-		http.Error(w, "Something went wrong",
-			http.StatusInternalServerError)
 		return
 	}
 
@@ -188,18 +428,27 @@ func (h *HandlerSet) HandlePostWithdraw(w http.ResponseWriter, req *http.Request
 	}
 
 	orderNum := string(data.Order)
-	if !validate.ValidateOrderNumber(orderNum) {
+	if !m.Validator.Validate(orderNum) {
 		http.Error(w, "Invalid order number",
 			http.StatusUnprocessableEntity)
 		return
 	}
 
-	err = h.database.InsertWithdrawAndUpdateBalance(req.Context(), userID, data.Order, data.Sum)
+	limit, ok := h.unconfirmedSpendingLimit(w, req, userID)
+	if !ok {
+		return
+	}
+
+	err = h.database.InsertWithdrawAndUpdateBalance(req.Context(), userID, data.Order, data.Sum, limit)
 	if err != nil && errors.Is(err, db.ErrNotEnoughBalance) {
 		http.Error(w, "Not enough balance",
 			http.StatusPaymentRequired)
 		return
 	}
+	if err != nil && errors.Is(err, db.ErrNeedSecondFactor) {
+		h.secondFactorRequired(w, req, userID)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
@@ -215,15 +464,18 @@ func (h *HandlerSet) HandlePostUserOrder(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	body, err := io.ReadAll(req.Body)
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
 	if err != nil {
-		http.Error(w, "Something went wrong",
-			http.StatusInternalServerError)
 		return
 	}
 
 	orderNum := string(body)
-	if !validate.ValidateOrderNumber(orderNum) {
+	if !m.Validator.Validate(orderNum) {
 		http.Error(w, "Invalid order number",
 			http.StatusUnprocessableEntity)
 		return
@@ -247,15 +499,172 @@ func (h *HandlerSet) HandlePostUserOrder(w http.ResponseWriter, req *http.Reques
 	w.WriteHeader(http.StatusAccepted)
 }
 
+func (h *HandlerSet) HandlePostUserOrdersBatch(w http.ResponseWriter, req *http.Request) {
+
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	numbers, err := parseOrdersBatch(body, req.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, "Could not parse body", http.StatusBadRequest)
+		return
+	}
+	if len(numbers) == 0 {
+		http.Error(w, "No orders in request", http.StatusBadRequest)
+		return
+	}
+	if len(numbers) > maxOrdersBatchSize {
+		http.Error(w, fmt.Sprintf("No more than %d orders per request", maxOrdersBatchSize),
+			http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	results := make([]types.OrderUploadResult, len(numbers))
+	var valid []string
+	for i, number := range numbers {
+		results[i].Number = number
+		if !m.Validator.Validate(number) {
+			results[i].Result = types.UploadInvalid
+			continue
+		}
+		valid = append(valid, number)
+	}
+
+	if len(valid) > 0 {
+		inserted, err := h.database.InsertUserOrders(req.Context(), valid, userID, types.NewStatus)
+		if err != nil {
+			logger.Error(err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		for i := range results {
+			if results[i].Result == "" {
+				results[i].Result, inserted = inserted[0], inserted[1:]
+			}
+		}
+	}
+
+	response, err := json.Marshal(results)
+	if err != nil {
+		http.Error(w, "Could not serialize result",
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	_, err = w.Write(response)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+	}
+}
+
+// parseOrdersBatch принимает JSON-массив номеров либо список номеров по одному на строку
+func parseOrdersBatch(body []byte, contentType string) ([]string, error) {
+
+	if strings.HasPrefix(contentType, "application/json") {
+		var numbers []string
+		err := json.Unmarshal(body, &numbers)
+		if err != nil {
+			return nil, ErrCouldNotParseBody
+		}
+		return numbers, nil
+	}
+
+	var numbers []string
+	for _, line := range strings.Split(string(body), "\n") {
+		number := strings.TrimSpace(line)
+		if number != "" {
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers, nil
+}
+
+func (h *HandlerSet) requestMerchant(w http.ResponseWriter, req *http.Request) (*merchant.Merchant, error) {
+	m, ok := merchant.FromContext(req.Context())
+	if !ok {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return nil, fmt.Errorf("merchant not resolved")
+	}
+	return m, nil
+}
+
+// HandleDeleteUser удаляет учётную запись по запросу самого пользователя, требуется подтверждение паролем
+func (h *HandlerSet) HandleDeleteUser(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Password    string `json:"password"`
+		ReauthToken string `json:"reauth_token"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || (data.Password == "" && data.ReauthToken == "") {
+		http.Error(w, "Password is required",
+			http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+	if !h.confirmUser(w, req, m.ID, userID, data.Password, data.ReauthToken) {
+		return
+	}
+
+	err = h.database.DeleteUser(req.Context(), userID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	auth.ClearAuthCookie(w, h.cookie)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *HandlerSet) handleAuthorizeUser(w http.ResponseWriter, req *http.Request) (int, error) {
-	username, ok := auth.GetAuthenticatedUser(req)
+	claims, ok := auth.GetAuthenticatedClaims(req)
 	if !ok {
 		http.Error(w, "Something went wrong",
 			http.StatusInternalServerError)
 		return 0, fmt.Errorf("authentication error")
 	}
 
-	userID, err := h.database.GetUserID(req.Context(), username)
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return 0, err
+	}
+
+	// токен выдан покупателю другого магазина
+	if claims.MerchantID != m.ID {
+		http.Error(w, "User not authenticated",
+			http.StatusUnauthorized)
+		return 0, fmt.Errorf("token issued for merchant %d", claims.MerchantID)
+	}
+
+	userID, err := h.database.GetUserID(req.Context(), m.ID, claims.Username)
 	if err != nil {
 		http.Error(w, "User not found",
 			http.StatusUnauthorized)
@@ -272,7 +681,17 @@ func (h *HandlerSet) HandleGetUserOrders(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	orders, err := h.database.GetUserOrders(req.Context(), userID)
+	filter, err := parseListFilter(req.URL.Query(), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// запрашиваем на одну запись больше, чтобы понять, есть ли следующая страница
+	pageSize := filter.Limit
+	filter.Limit++
+
+	orders, err := h.database.GetUserOrders(req.Context(), userID, filter)
 	if err != nil {
 		logger.Error(err)
 		http.Error(w, "Error getting data", http.StatusInternalServerError)
@@ -284,6 +703,11 @@ func (h *HandlerSet) HandleGetUserOrders(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
+	if len(orders) > pageSize {
+		orders = orders[:pageSize]
+		setNextPageLink(w, req, orders[pageSize-1].ID)
+	}
+
 	response, err := json.Marshal(orders)
 	if err != nil {
 		http.Error(w, "Could not serialize result",
@@ -298,6 +722,219 @@ func (h *HandlerSet) HandleGetUserOrders(w http.ResponseWriter, req *http.Reques
 	}
 }
 
+func (h *HandlerSet) HandleGetUserOrder(w http.ResponseWriter, req *http.Request) {
+
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	order, err := h.database.GetUserOrder(req.Context(), userID, chi.URLParam(req, "number"))
+	if err != nil {
+		if errors.Is(err, db.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(order)
+	if err != nil {
+		http.Error(w, "Could not serialize result",
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	_, err = w.Write(response)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+	}
+}
+
+func (h *HandlerSet) HandleGetUserOrderHistory(w http.ResponseWriter, req *http.Request) {
+
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	order, err := h.database.GetUserOrder(req.Context(), userID, chi.URLParam(req, "number"))
+	if err != nil {
+		if errors.Is(err, db.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := json.Marshal(order.History)
+	if err != nil {
+		http.Error(w, "Could not serialize result",
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	_, err = w.Write(response)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+	}
+}
+
+func (h *HandlerSet) HandleGetUserOrdersStream(w http.ResponseWriter, req *http.Request) {
+
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	orderEvents, unsubscribe := h.broker.Subscribe(userID)
+	defer unsubscribe()
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-orderEvents:
+			var data []byte
+			data, err = json.Marshal(event)
+			if err != nil {
+				logger.Error(err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: order\ndata: %s\n\n", data)
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (h *HandlerSet) HandlePostTransfer(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Login string  `json:"login"`
+		Sum   float64 `json:"sum"`
+	}
+
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Login == "" || data.Sum <= 0 {
+		http.Error(w, "Could not parse body",
+			http.StatusUnprocessableEntity)
+		return
+	}
+
+	limit, ok := h.unconfirmedSpendingLimit(w, req, userID)
+	if !ok {
+		return
+	}
+
+	err = h.database.TransferPoints(req.Context(), m.ID, userID, auth.NormalizeLogin(data.Login), data.Sum, limit)
+
+	var notFound *db.UserNotFoundError
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusOK)
+	case errors.As(err, &notFound):
+		http.Error(w, "Receiver not found", http.StatusNotFound)
+	case errors.Is(err, db.ErrSelfTransfer):
+		http.Error(w, "Cannot transfer to yourself", http.StatusBadRequest)
+	case errors.Is(err, db.ErrTransferLimit):
+		http.Error(w, "Transfer limit exceeded", http.StatusUnprocessableEntity)
+	case errors.Is(err, db.ErrNotEnoughBalance):
+		http.Error(w, "Not enough balance", http.StatusPaymentRequired)
+	case errors.Is(err, db.ErrNeedSecondFactor):
+		h.secondFactorRequired(w, req, userID)
+	default:
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	}
+}
+
+func (h *HandlerSet) HandleGetUserTransfers(w http.ResponseWriter, req *http.Request) {
+
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	filter, err := parseListFilter(req.URL.Query(), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pageSize := filter.Limit
+	filter.Limit++
+
+	results, err := h.database.GetUserTransfers(req.Context(), userID, filter)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+
+	if len(results) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if len(results) > pageSize {
+		results = results[:pageSize]
+		setNextPageLink(w, req, results[pageSize-1].ID)
+	}
+
+	response, err := json.Marshal(results)
+	if err != nil {
+		http.Error(w, "Could not serialize result",
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	_, err = w.Write(response)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+	}
+}
+
 func (h *HandlerSet) HandleGetUserWithdrawals(w http.ResponseWriter, req *http.Request) {
 
 	userID, err := h.handleAuthorizeUser(w, req)
@@ -305,7 +942,16 @@ func (h *HandlerSet) HandleGetUserWithdrawals(w http.ResponseWriter, req *http.R
 		return
 	}
 
-	results, err := h.database.GetUserWithdrawals(req.Context(), userID)
+	filter, err := parseListFilter(req.URL.Query(), false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pageSize := filter.Limit
+	filter.Limit++
+
+	results, err := h.database.GetUserWithdrawals(req.Context(), userID, filter)
 	if err != nil {
 		logger.Error(err)
 		http.Error(w, "Error getting data", http.StatusInternalServerError)
@@ -317,6 +963,11 @@ func (h *HandlerSet) HandleGetUserWithdrawals(w http.ResponseWriter, req *http.R
 		return
 	}
 
+	if len(results) > pageSize {
+		results = results[:pageSize]
+		setNextPageLink(w, req, results[pageSize-1].ID)
+	}
+
 	response, err := json.Marshal(results)
 	if err != nil {
 		http.Error(w, "Could not serialize result",
@@ -331,6 +982,27 @@ func (h *HandlerSet) HandleGetUserWithdrawals(w http.ResponseWriter, req *http.R
 	}
 }
 
+func (h *HandlerSet) HandleGetMerchant(w http.ResponseWriter, req *http.Request) {
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	response, err := json.Marshal(m.Merchant)
+	if err != nil {
+		http.Error(w, "Could not serialize result",
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	_, err = w.Write(response)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+	}
+}
+
 func (h *HandlerSet) HandleGetUserBalance(w http.ResponseWriter, req *http.Request) {
 
 	userID, err := h.handleAuthorizeUser(w, req)
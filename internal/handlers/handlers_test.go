@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOrdersBatch(t *testing.T) {
+
+	testCases := []struct {
+		name        string
+		body        string
+		contentType string
+		expected    []string
+		wantError   bool
+	}{
+		{name: "json", body: `["49927398716", "0"]`, contentType: "application/json", expected: []string{"49927398716", "0"}},
+		{name: "json charset", body: `["0"]`, contentType: "application/json; charset=utf-8", expected: []string{"0"}},
+		{name: "lines", body: "49927398716\n0", contentType: "text/plain", expected: []string{"49927398716", "0"}},
+		{name: "lines with spaces", body: " 49927398716 \r\n\n 0\n", contentType: "", expected: []string{"49927398716", "0"}},
+		{name: "empty", body: "", contentType: "text/plain", expected: nil},
+		{name: "bad json", body: `[1, 2]`, contentType: "application/json", wantError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			numbers, err := parseOrdersBatch([]byte(tc.body), tc.contentType)
+			if tc.wantError {
+				assert.ErrorIs(t, err, ErrCouldNotParseBody)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, numbers)
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/oidc"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+const (
+	oidcFlowCookie = "_oidc"
+	oidcFlowPath   = "/api/user/oidc"
+	// сколько у пользователя времени на вход у провайдера
+	oidcFlowTTL = 10 * time.Minute
+	// повторный вход засчитывается, только если пользователь вводил данные у провайдера не раньше этого
+	oidcReauthMaxAge = 5 * time.Minute
+	// сколько действует подтверждение повторным входом
+	reauthTokenTTL = 5 * time.Minute
+)
+
+// startOIDCFlow дополняет flow случайными state, nonce и code verifier, запоминает его в подписанной куке
+// и возвращает адрес провайдера
+func (h *HandlerSet) startOIDCFlow(w http.ResponseWriter, flow auth.OIDCFlowClaims) (string, error) {
+	for _, value := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		random, err := oidc.RandomString()
+		if err != nil {
+			return "", err
+		}
+		*value = random
+	}
+
+	token, err := auth.BuildOIDCFlowToken(flow, h.secret, oidcFlowTTL)
+	if err != nil {
+		return "", err
+	}
+	// Lax: кука должна прийти при возврате браузера с сайта провайдера
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    token,
+		Path:     oidcFlowPath,
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+		Secure:   h.cookie.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if flow.ReauthUserID != 0 {
+		return h.oidc.ReauthCodeURL(flow.State, flow.Nonce, flow.Verifier), nil
+	}
+	return h.oidc.AuthCodeURL(flow.State, flow.Nonce, flow.Verifier), nil
+}
+
+// HandleOIDCLogin отправляет браузер на вход у провайдера
+func (h *HandlerSet) HandleOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	url, err := h.startOIDCFlow(w, auth.OIDCFlowClaims{MerchantID: m.ID})
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, req, url, http.StatusFound)
+}
+
+// HandleOIDCLink начинает привязку учётной записи провайдера к вошедшему пользователю
+func (h *HandlerSet) HandleOIDCLink(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	url, err := h.startOIDCFlow(w, auth.OIDCFlowClaims{MerchantID: m.ID, LinkUserID: userID})
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		URL string `json:"url"`
+	}{url})
+}
+
+// HandleOIDCReauth начинает повторный вход у провайдера для пользователя без пароля: полученный токен
+// подтверждает удаление учётной записи, отключение второго фактора, смену пароля и выпуск ключа API
+func (h *HandlerSet) HandleOIDCReauth(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+	claims, _ := auth.GetAuthenticatedClaims(req)
+
+	hash, err := h.database.GetUserHashedPassword(req.Context(), m.ID, claims.Username)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	if hash != "" {
+		http.Error(w, "Confirm with password", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.startOIDCFlow(w, auth.OIDCFlowClaims{MerchantID: m.ID, ReauthUserID: userID, ReauthSessionID: claims.SessionID})
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		URL string `json:"url"`
+	}{url})
+}
+
+// HandleOIDCCallback - возврат от провайдера: проверяет state, меняет код на ID token,
+// затем привязывает учётную запись или входит под пользователем, при необходимости заводя нового
+func (h *HandlerSet) HandleOIDCCallback(w http.ResponseWriter, req *http.Request) {
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	cookie, err := req.Cookie(oidcFlowCookie)
+	if err != nil {
+		http.Error(w, "Login expired, start again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcFlowCookie, Path: oidcFlowPath, MaxAge: -1, Secure: h.cookie.Secure, HttpOnly: true})
+
+	flow, err := auth.GetOIDCFlowClaims(cookie.Value, h.secret)
+	if err != nil || flow.MerchantID != m.ID {
+		http.Error(w, "Login expired, start again", http.StatusBadRequest)
+		return
+	}
+
+	query := req.URL.Query()
+	if query.Get("error") != "" {
+		http.Error(w, "Login rejected by identity provider", http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 || query.Get("code") == "" {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.oidc.Exchange(req.Context(), query.Get("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		logger.Errorf("OIDC exchange failed %s", err.Error())
+		http.Error(w, "Could not verify identity", http.StatusUnauthorized)
+		return
+	}
+
+	if flow.ReauthUserID != 0 {
+		h.finishOIDCReauth(w, req, m.ID, flow, identity)
+		return
+	}
+
+	if flow.LinkUserID != 0 {
+		err = h.database.LinkIdentity(req.Context(), flow.LinkUserID, m.ID, identity.Issuer, identity.Subject, identity.Email)
+		if err != nil && errors.Is(err, db.ErrIdentityLinked) {
+			http.Error(w, "Identity already linked", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error(err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		h.finishOIDC(w, req, "linked")
+		return
+	}
+
+	user, err := h.oidcUser(req.Context(), m.ID, identity)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if user.Status != types.UserActive {
+		http.Error(w, "User is locked", http.StatusForbidden)
+		return
+	}
+
+	// вход через провайдера не заменяет второй фактор
+	twoFactor, err := h.database.GetTwoFactor(req.Context(), user.ID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	if twoFactor.Enabled {
+		h.finishOIDCSecondFactor(w, req, user)
+		return
+	}
+
+	if err := h.loginSucceeded(w, req, user, clientIP(req)); err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	h.finishOIDC(w, req, "success")
+}
+
+// finishOIDCSecondFactor передаёт промежуточный токен для POST /api/user/login/2fa: приложению - во фрагменте адреса,
+// который браузер не отправляет на сервер, иначе так же, как при входе по паролю
+func (h *HandlerSet) finishOIDCSecondFactor(w http.ResponseWriter, req *http.Request, user *types.User) {
+	if h.oidc.AfterLoginURL() == "" {
+		h.requireSecondFactor(w, user)
+		return
+	}
+	token, err := auth.BuildTwoFactorToken(user, h.secret, twoFactorTokenTTL)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, req, h.oidc.AfterLoginURL()+"#two_factor_token="+url.QueryEscape(token), http.StatusFound)
+}
+
+// finishOIDCReauth выдаёт токен подтверждения, если у провайдера только что заново вошёл тот же пользователь.
+// Токен передаётся так же, как промежуточный токен второго фактора
+func (h *HandlerSet) finishOIDCReauth(w http.ResponseWriter, req *http.Request, merchantID int, flow *auth.OIDCFlowClaims, identity *oidc.Identity) {
+	user, err := h.database.GetUserByIdentity(req.Context(), merchantID, identity.Issuer, identity.Subject)
+	var notFound *db.UserNotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if err != nil || user.ID != flow.ReauthUserID {
+		http.Error(w, "Identity is not linked to this user", http.StatusForbidden)
+		return
+	}
+	// провайдер может пропустить prompt=login и пустить по своей сессии
+	if identity.AuthTime.IsZero() || time.Since(identity.AuthTime) > oidcReauthMaxAge {
+		http.Error(w, "Sign in at the identity provider again", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := auth.BuildReauthToken(user.ID, flow.ReauthSessionID, h.secret, reauthTokenTTL)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	if h.oidc.AfterLoginURL() != "" {
+		http.Redirect(w, req, h.oidc.AfterLoginURL()+"#reauth_token="+url.QueryEscape(token), http.StatusFound)
+		return
+	}
+	writeJSON(w, struct {
+		ReauthToken string `json:"reauth_token"`
+	}{token})
+}
+
+// finishOIDC возвращает браузер в приложение, если адрес задан, иначе отвечает как обычный вход
+func (h *HandlerSet) finishOIDC(w http.ResponseWriter, req *http.Request, result string) {
+	if h.oidc.AfterLoginURL() != "" {
+		http.Redirect(w, req, h.oidc.AfterLoginURL(), http.StatusFound)
+		return
+	}
+	w.Header().Set("content-type", "text/plain")
+	if _, err := w.Write([]byte(result)); err != nil {
+		logger.Error(err)
+	}
+}
+
+// oidcUser находит пользователя по учётной записи провайдера или заводит нового.
+// С существующим пользователем с таким же логином не связываем: логин мог занять кто угодно,
+// привязать свою учётную запись пользователь может сам через /api/user/oidc/link
+func (h *HandlerSet) oidcUser(ctx context.Context, merchantID int, identity *oidc.Identity) (*types.User, error) {
+	user, err := h.database.GetUserByIdentity(ctx, merchantID, identity.Issuer, identity.Subject)
+	var notFound *db.UserNotFoundError
+	if err == nil || !errors.As(err, &notFound) {
+		return user, err
+	}
+
+	var userExists *db.UserExistsError
+	for _, login := range oidcLogins(identity) {
+		err = h.database.CreateUserWithIdentity(ctx, merchantID, login, identity.Issuer, identity.Subject, identity.Email)
+		if err == nil || errors.Is(err, db.ErrIdentityLinked) {
+			// при одновременном входе пользователя мог завести параллельный запрос
+			return h.database.GetUserByIdentity(ctx, merchantID, identity.Issuer, identity.Subject)
+		}
+		if !errors.As(err, &userExists) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// oidcLogins - логины для нового пользователя по порядку предпочтения; последний уникален для учётной записи провайдера
+func oidcLogins(identity *oidc.Identity) []string {
+	var logins []string
+	candidates := []string{identity.PreferredUsername}
+	if identity.EmailVerified {
+		candidates = append(candidates, identity.Email)
+	}
+	for _, candidate := range candidates {
+		login := auth.NormalizeLogin(candidate)
+		if auth.ValidateLogin(login) == nil && !strings.HasPrefix(login, db.DeletedLoginPrefix) {
+			logins = append(logins, login)
+		}
+	}
+	sum := sha256.Sum256([]byte(identity.Issuer + "|" + identity.Subject))
+	return append(logins, "oidc-"+hex.EncodeToString(sum[:])[:16])
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/oidc"
+)
+
+func TestOIDCLogins(t *testing.T) {
+
+	fallback := oidcLogins(&oidc.Identity{Issuer: "https://idp", Subject: "1"})[0]
+	assert.Len(t, fallback, len("oidc-")+16)
+
+	testCases := []struct {
+		name     string
+		identity oidc.Identity
+		expected []string
+	}{
+		{name: "username and verified email", identity: oidc.Identity{Issuer: "https://idp", Subject: "1",
+			PreferredUsername: "Alice", Email: "alice@example.com", EmailVerified: true},
+			expected: []string{"alice", "alice@example.com", fallback}},
+		{name: "unverified email", identity: oidc.Identity{Issuer: "https://idp", Subject: "1",
+			Email: "alice@example.com"},
+			expected: []string{fallback}},
+		{name: "invalid username", identity: oidc.Identity{Issuer: "https://idp", Subject: "1",
+			PreferredUsername: "a b"},
+			expected: []string{fallback}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, oidcLogins(&tc.identity))
+		})
+	}
+}
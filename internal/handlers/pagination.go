@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+const maxPageSize = 1000
+
+var ErrInvalidListParams = errors.New("invalid list parameters")
+
+var orderStatuses = map[types.Status]struct{}{
+	types.NewStatus:        {},
+	types.ProcessingStatus: {},
+	types.InvalidStatus:    {},
+	types.ProcessedStatus:  {},
+	types.RegisteredStatus: {},
+}
+
+// parseListFilter разбирает параметры limit, cursor, status, from, to и sort.
+// Без параметров возвращается первая страница максимального размера по возрастанию id
+func parseListFilter(query url.Values, withStatus bool) (types.ListFilter, error) {
+
+	filter := types.ListFilter{Limit: maxPageSize, Sort: types.SortAsc}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > maxPageSize {
+			return filter, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListParams, maxPageSize)
+		}
+		filter.Limit = l
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		id, err := decodeCursor(cursor)
+		if err != nil {
+			return filter, fmt.Errorf("%w: bad cursor", ErrInvalidListParams)
+		}
+		filter.Cursor = id
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		switch types.SortOrder(sort) {
+		case types.SortAsc, types.SortDesc:
+			filter.Sort = types.SortOrder(sort)
+		default:
+			return filter, fmt.Errorf("%w: sort must be asc or desc", ErrInvalidListParams)
+		}
+	}
+
+	for _, param := range query["status"] {
+		if !withStatus {
+			return filter, fmt.Errorf("%w: status filter not supported", ErrInvalidListParams)
+		}
+		for _, status := range strings.Split(param, ",") {
+			status := types.Status(strings.ToUpper(strings.TrimSpace(status)))
+			if _, ok := orderStatuses[status]; !ok {
+				return filter, fmt.Errorf("%w: unknown status %s", ErrInvalidListParams, status)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%w: %s must be RFC3339 time", ErrInvalidListParams, param)
+		}
+		*target = &t
+	}
+
+	return filter, nil
+}
+
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(string(decoded))
+	if err != nil {
+		return 0, err
+	}
+	if id < 1 {
+		return 0, fmt.Errorf("cursor out of range")
+	}
+	return id, nil
+}
+
+// setNextPageLink добавляет заголовок Link на следующую страницу, сохраняя остальные параметры запроса
+func setNextPageLink(w http.ResponseWriter, req *http.Request, lastID int) {
+	query := req.URL.Query()
+	query.Set("cursor", encodeCursor(lastID))
+
+	next := url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+func TestParseListFilter(t *testing.T) {
+
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		query      string
+		withStatus bool
+		wantError  bool
+		expected   types.ListFilter
+	}{
+		{query: "", withStatus: true, expected: types.ListFilter{Limit: maxPageSize, Sort: types.SortAsc}},
+		{query: "limit=10&sort=desc", withStatus: true, expected: types.ListFilter{Limit: 10, Sort: types.SortDesc}},
+		{query: "cursor=" + encodeCursor(42), withStatus: true, expected: types.ListFilter{Cursor: 42, Limit: maxPageSize, Sort: types.SortAsc}},
+		{query: "status=new,processed&status=INVALID", withStatus: true,
+			expected: types.ListFilter{Limit: maxPageSize, Sort: types.SortAsc,
+				Statuses: []types.Status{types.NewStatus, types.ProcessedStatus, types.InvalidStatus}}},
+		{query: "from=2024-06-01T00:00:00Z&to=2024-07-01T00:00:00Z", withStatus: false,
+			expected: types.ListFilter{Limit: maxPageSize, Sort: types.SortAsc, From: &from, To: &to}},
+		{query: "limit=0", withStatus: true, wantError: true},
+		{query: "limit=1001", withStatus: true, wantError: true},
+		{query: "limit=abc", withStatus: true, wantError: true},
+		{query: "cursor=notacursor", withStatus: true, wantError: true},
+		{query: "sort=random", withStatus: true, wantError: true},
+		{query: "status=DONE", withStatus: true, wantError: true},
+		{query: "status=NEW", withStatus: false, wantError: true},
+		{query: "from=yesterday", withStatus: true, wantError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tc.query)
+			assert.NoError(t, err)
+
+			filter, err := parseListFilter(query, tc.withStatus)
+			if tc.wantError {
+				assert.ErrorIs(t, err, ErrInvalidListParams)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, filter)
+		})
+	}
+}
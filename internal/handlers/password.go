@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/notify"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+const passwordResetTTL = time.Hour
+
+// сколько действующих токенов сброса может быть у пользователя, чтобы запросами нельзя было засыпать его письмами
+const maxPasswordResets = 3
+
+// HandleChangePassword меняет пароль; остальные сессии пользователя перестают действовать, текущая получает новый токен
+func (h *HandlerSet) HandleChangePassword(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		OldPassword string `json:"old_password"`
+		ReauthToken string `json:"reauth_token"`
+		NewPassword string `json:"new_password"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || (data.OldPassword == "" && data.ReauthToken == "") || data.NewPassword == "" {
+		http.Error(w, "Could not parse body",
+			http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+	if !h.confirmUser(w, req, m.ID, userID, data.OldPassword, data.ReauthToken) {
+		return
+	}
+	username, _ := auth.GetAuthenticatedUser(req)
+
+	if err := h.passwordPolicy.Validate(username, data.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashed, err := h.hasher.Hash(req.Context(), data.NewPassword)
+	if err != nil {
+		h.handlePasswordHashError(w, err)
+		return
+	}
+
+	if err := h.database.UpdatePassword(req.Context(), userID, hashed); err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.database.GetUser(req.Context(), m.ID, username)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	err = h.startSession(w, req, user)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleRequestPasswordReset отправляет токен сброса пароля через notifier.
+// Ответ не зависит от того, существует ли пользователь
+func (h *HandlerSet) HandleRequestPasswordReset(w http.ResponseWriter, req *http.Request) {
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Login string `json:"login"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Login == "" {
+		http.Error(w, "Could not parse body",
+			http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	// при превышении лимита ответ тот же, чтобы нельзя было понять, существует ли пользователь
+	user, err := h.database.GetUser(req.Context(), m.ID, auth.NormalizeLogin(data.Login))
+	if err == nil && user.Status == types.UserActive {
+		err := h.sendPasswordReset(req, user)
+		if err != nil && errors.Is(err, db.ErrTooManyResets) {
+			logger.Warnf("Too many password resets requested for user %d", user.ID)
+		} else if err != nil {
+			logger.Errorf("Could not send password reset %s", err.Error())
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *HandlerSet) sendPasswordReset(req *http.Request, user *types.User) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	expiresAt := time.Now().Add(passwordResetTTL)
+	if err := h.database.CreatePasswordReset(req.Context(), user.ID, hashResetToken(token), expiresAt, maxPasswordResets); err != nil {
+		return err
+	}
+
+	return h.notifier.Send(req.Context(), notify.Message{
+		To:         user.Login,
+		MerchantID: user.MerchantID,
+		Subject:    "Password reset",
+		Body:       fmt.Sprintf("Your password reset token: %s\nIt expires at %s", token, expiresAt.Format(time.RFC3339)),
+	})
+}
+
+func (h *HandlerSet) HandleConfirmPasswordReset(w http.ResponseWriter, req *http.Request) {
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Token == "" || data.Password == "" {
+		http.Error(w, "Could not parse body",
+			http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := hashResetToken(data.Token)
+	user, err := h.database.GetPasswordResetUser(req.Context(), tokenHash)
+	if err != nil && errors.Is(err, db.ErrResetTokenInvalid) {
+		http.Error(w, "Reset token is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.passwordPolicy.Validate(user.Login, data.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashed, err := h.hasher.Hash(req.Context(), data.Password)
+	if err != nil {
+		h.handlePasswordHashError(w, err)
+		return
+	}
+
+	err = h.database.ResetPassword(req.Context(), tokenHash, hashed)
+	if err != nil && (errors.Is(err, db.ErrResetTokenInvalid) || errors.Is(err, db.ErrUserDeleted)) {
+		http.Error(w, "Reset token is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// размер колонки user_session.user_agent
+const maxUserAgentLength = 512
+
+// describeDevice - короткое название устройства по User-Agent, чтобы пользователь узнал свою сессию в списке
+func describeDevice(userAgent string) string {
+	devices := []struct {
+		marker string
+		name   string
+	}{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Macintosh", "Mac"},
+		{"Linux", "Linux"},
+	}
+	for _, d := range devices {
+		if strings.Contains(userAgent, d.marker) {
+			return d.name
+		}
+	}
+	return "Unknown"
+}
+
+// startSession записывает новую сессию и выдаёт куку с её id
+func (h *HandlerSet) startSession(w http.ResponseWriter, req *http.Request, user *types.User) error {
+	userAgent := req.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	expiresAt := time.Now().Add(time.Duration(h.cookie.MaxAge) * time.Second)
+
+	sessionID, err := h.database.CreateSession(req.Context(), user.ID, describeDevice(userAgent), clientIP(req), userAgent, expiresAt)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	return auth.SetAuthCookie(user, sessionID, w, h.secret, h.cookie)
+}
+
+func (h *HandlerSet) HandleGetUserSessions(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+	claims, _ := auth.GetAuthenticatedClaims(req)
+
+	sessions, err := h.database.GetUserSessions(req.Context(), userID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Error getting data", http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+	writeJSON(w, sessions)
+}
+
+// HandleRevokeSession завершает одну сессию пользователя; если текущую - заодно удаляет куку
+func (h *HandlerSet) HandleRevokeSession(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+	claims, _ := auth.GetAuthenticatedClaims(req)
+
+	sessionID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	err = h.database.RevokeSession(req.Context(), userID, sessionID)
+	if err != nil && errors.Is(err, db.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if sessionID == claims.SessionID {
+		auth.ClearAuthCookie(w, h.cookie)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleRevokeOtherSessions завершает все сессии пользователя, кроме текущей, и отзывает его ключи API
+func (h *HandlerSet) HandleRevokeOtherSessions(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+	claims, _ := auth.GetAuthenticatedClaims(req)
+
+	if err := h.database.RevokeOtherSessions(req.Context(), userID, claims.SessionID); err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
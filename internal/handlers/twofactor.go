@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+// сколько действует промежуточный токен между паролем и кодом
+const twoFactorTokenTTL = 5 * time.Minute
+
+// заголовок с кодом второго фактора для операций, которые его требуют
+const twoFactorHeader = "X-OTP-Code"
+
+// verifySecondFactor проверяет код из приложения или код восстановления; каждый код принимается один раз
+func (h *HandlerSet) verifySecondFactor(ctx context.Context, userID int, code string) (bool, error) {
+	twoFactor, err := h.database.GetTwoFactor(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !twoFactor.Enabled || code == "" {
+		return false, nil
+	}
+
+	if auth.IsRecoveryCode(code) {
+		err = h.database.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(code))
+	} else {
+		step, ok := auth.VerifyTOTP(twoFactor.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		err = h.database.UseTwoFactorStep(ctx, userID, step)
+	}
+	if errors.Is(err, db.ErrTwoFactorInvalid) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// requireSecondFactor вместо авторизационной куки выдаёт промежуточный токен для POST /api/user/login/2fa
+func (h *HandlerSet) requireSecondFactor(w http.ResponseWriter, user *types.User) {
+	token, err := auth.BuildTwoFactorToken(user, h.secret, twoFactorTokenTTL)
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		Token             string `json:"token"`
+	}{true, token})
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// HandleLoginTwoFactor - второй шаг входа: промежуточный токен и код
+func (h *HandlerSet) HandleLoginTwoFactor(w http.ResponseWriter, req *http.Request) {
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Token string `json:"token"`
+		Code  string `json:"code"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Token == "" || data.Code == "" {
+		http.Error(w, "Could not parse body",
+			http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+
+	claims, err := auth.GetTwoFactorClaims(data.Token, h.secret)
+	if err != nil || claims.MerchantID != m.ID {
+		http.Error(w, "Login expired, start again", http.StatusUnauthorized)
+		return
+	}
+
+	ip := clientIP(req)
+	username := auth.NormalizeLogin(claims.Username)
+	if h.loginThrottled(w, req, m.ID, username, ip) {
+		return
+	}
+
+	ok, err := h.verifySecondFactor(req.Context(), claims.UserID, data.Code)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if err := h.loginThrottle.Record(req.Context(), m.ID, username, ip, false); err != nil {
+			logger.Error(err)
+		}
+		http.Error(w, "Wrong code", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.database.GetUser(req.Context(), m.ID, claims.Username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.ID != claims.UserID || user.Status != types.UserActive {
+		http.Error(w, "User is locked", http.StatusForbidden)
+		return
+	}
+
+	h.completeLogin(w, req, user, ip)
+}
+
+// HandleEnrollTwoFactor создаёт новый секрет; защита включается после подтверждения кодом
+func (h *HandlerSet) HandleEnrollTwoFactor(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+	username, _ := auth.GetAuthenticatedUser(req)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+
+	err = h.database.SetTwoFactorSecret(req.Context(), userID, secret)
+	if err != nil && errors.Is(err, db.ErrTwoFactorEnabled) {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{secret, auth.TOTPProvisioningURI(m.Name, username, secret)})
+}
+
+// HandleConfirmTwoFactor включает защиту по первому верному коду и отдаёт коды восстановления
+func (h *HandlerSet) HandleConfirmTwoFactor(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Code string `json:"code"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || data.Code == "" {
+		http.Error(w, "Could not parse body",
+			http.StatusBadRequest)
+		return
+	}
+
+	twoFactor, err := h.database.GetTwoFactor(req.Context(), userID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if twoFactor.Enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+	if twoFactor.Secret == "" {
+		http.Error(w, "Two-factor enrollment not started", http.StatusConflict)
+		return
+	}
+
+	step, ok := auth.VerifyTOTP(twoFactor.Secret, data.Code, time.Now())
+	if !ok {
+		http.Error(w, "Wrong code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Something went wrong",
+			http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+
+	err = h.database.EnableTwoFactor(req.Context(), userID, step, hashes)
+	if err != nil && errors.Is(err, db.ErrTwoFactorEnabled) {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes})
+}
+
+// HandleDisableTwoFactor отключает защиту, нужны пароль и действующий код
+func (h *HandlerSet) HandleDisableTwoFactor(w http.ResponseWriter, req *http.Request) {
+	userID, err := h.handleAuthorizeUser(w, req)
+	if err != nil {
+		return
+	}
+
+	body, err := readBody(w, req)
+	if err != nil {
+		return
+	}
+
+	var data struct {
+		Password    string `json:"password"`
+		ReauthToken string `json:"reauth_token"`
+		Code        string `json:"code"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil || (data.Password == "" && data.ReauthToken == "") || data.Code == "" {
+		http.Error(w, "Could not parse body",
+			http.StatusBadRequest)
+		return
+	}
+
+	m, err := h.requestMerchant(w, req)
+	if err != nil {
+		return
+	}
+	if !h.confirmUser(w, req, m.ID, userID, data.Password, data.ReauthToken) {
+		return
+	}
+	username, _ := auth.GetAuthenticatedUser(req)
+
+	ok, err := h.verifySecondFactor(req.Context(), userID, data.Code)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.recordLoginFailure(req, m.ID, auth.NormalizeLogin(username), clientIP(req))
+		http.Error(w, "Wrong code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.database.DisableTwoFactor(req.Context(), userID); err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// unconfirmedSpendingLimit возвращает, сколько баллов можно списать и перевести за сутки без кода второго фактора,
+// 0 - без ограничения. Верный код из X-OTP-Code снимает ограничение, неверный отклоняет запрос.
+// Сумму за сутки проверяет база под блокировкой баланса. Ответ при ошибке уже отправлен
+func (h *HandlerSet) unconfirmedSpendingLimit(w http.ResponseWriter, req *http.Request, userID int) (float64, bool) {
+	if h.withdrawTwoFactorSum <= 0 {
+		return 0, true
+	}
+	code := req.Header.Get(twoFactorHeader)
+	if code == "" {
+		return h.withdrawTwoFactorSum, true
+	}
+
+	twoFactor, err := h.database.GetTwoFactor(req.Context(), userID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !twoFactor.Enabled {
+		return h.withdrawTwoFactorSum, true
+	}
+	// неверные коды считаются вместе с неудачными входами, иначе код можно перебрать из украденной сессии
+	claims, _ := auth.GetAuthenticatedClaims(req)
+	login := auth.NormalizeLogin(claims.Username)
+	ip := clientIP(req)
+	if h.loginThrottled(w, req, claims.MerchantID, login, ip) {
+		return 0, false
+	}
+
+	ok, err := h.verifySecondFactor(req.Context(), userID, code)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return 0, false
+	}
+	if !ok {
+		h.recordLoginFailure(req, claims.MerchantID, login, ip)
+		http.Error(w, "Two-factor code required", http.StatusForbidden)
+		return 0, false
+	}
+	return 0, true
+}
+
+// secondFactorRequired отвечает на списание или перевод сверх суммы, доступной без кода второго фактора
+func (h *HandlerSet) secondFactorRequired(w http.ResponseWriter, req *http.Request, userID int) {
+	twoFactor, err := h.database.GetTwoFactor(req.Context(), userID)
+	if err != nil {
+		logger.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if !twoFactor.Enabled {
+		http.Error(w, "Enable two-factor authentication to spend this sum", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Two-factor code required", http.StatusForbidden)
+}
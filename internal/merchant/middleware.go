@@ -0,0 +1,33 @@
+package merchant
+
+import (
+	"context"
+	"net/http"
+)
+
+type key string
+
+const contextKey key = "merchant"
+
+type Middleware struct {
+	Registry *Registry
+}
+
+func (m Middleware) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		merchant, err := m.Registry.Resolve(r)
+		if err != nil {
+			http.Error(w, "Unknown merchant", http.StatusNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey, merchant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func FromContext(ctx context.Context) (*Merchant, bool) {
+	merchant, ok := ctx.Value(contextKey).(*Merchant)
+	return merchant, ok
+}
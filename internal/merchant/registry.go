@@ -0,0 +1,131 @@
+package merchant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/wellywell/bonusy/internal/accrual"
+	"github.com/wellywell/bonusy/internal/types"
+	"github.com/wellywell/bonusy/internal/validate"
+)
+
+// Header позволяет явно выбрать магазин, например для запросов сервер-сервер
+const Header = "X-Merchant"
+
+const DefaultCode = "default"
+
+var (
+	ErrUnknownMerchant = errors.New("unknown merchant")
+	ErrNoDefault       = errors.New("default merchant not configured")
+)
+
+type Merchant struct {
+	types.Merchant
+	Validator validate.Validator
+	Accrual   *accrual.AccrualClient
+}
+
+type Store interface {
+	GetMerchants(ctx context.Context) ([]types.Merchant, error)
+}
+
+type Registry struct {
+	byID            map[int]*Merchant
+	byCode          map[string]*Merchant
+	byHost          map[string]*Merchant
+	defaultMerchant *Merchant
+}
+
+// NewRegistry загружает магазины из базы. Адрес системы начислений и схема проверки
+// номеров из конфигурации используются для магазинов, у которых они не заданы
+func NewRegistry(ctx context.Context, store Store, accrualAddress string, orderValidation string) (*Registry, error) {
+
+	merchants, err := store.GetMerchants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		byID:   make(map[int]*Merchant),
+		byCode: make(map[string]*Merchant),
+		byHost: make(map[string]*Merchant),
+	}
+
+	for _, m := range merchants {
+		address := accrualAddress
+		if m.AccrualAddress != nil {
+			address = *m.AccrualAddress
+		}
+		spec := orderValidation
+		if m.OrderValidation != nil {
+			spec = *m.OrderValidation
+		}
+		validator, err := validate.New(spec)
+		if err != nil {
+			return nil, fmt.Errorf("merchant %s: %w", m.Code, err)
+		}
+
+		merchant := &Merchant{
+			Merchant:  m,
+			Validator: validator,
+			Accrual:   accrual.NewAccrualClient(address),
+		}
+		r.byID[m.ID] = merchant
+		r.byCode[m.Code] = merchant
+		if m.Host != nil {
+			r.byHost[strings.ToLower(*m.Host)] = merchant
+		}
+		if m.Code == DefaultCode {
+			r.defaultMerchant = merchant
+		}
+	}
+
+	if r.defaultMerchant == nil {
+		return nil, ErrNoDefault
+	}
+	return r, nil
+}
+
+func (r *Registry) ByID(id int) (*Merchant, bool) {
+	m, ok := r.byID[id]
+	return m, ok
+}
+
+func (r *Registry) ByCode(code string) (*Merchant, bool) {
+	m, ok := r.byCode[code]
+	return m, ok
+}
+
+// AccrualClient возвращает клиента системы начислений магазина, для неизвестного магазина - клиента по умолчанию
+func (r *Registry) AccrualClient(merchantID int) *accrual.AccrualClient {
+	if m, ok := r.byID[merchantID]; ok {
+		return m.Accrual
+	}
+	return r.defaultMerchant.Accrual
+}
+
+// Resolve выбирает магазин по заголовку X-Merchant, затем по Host.
+// Если ни то, ни другое не подошло, запрос относится к магазину по умолчанию
+func (r *Registry) Resolve(req *http.Request) (*Merchant, error) {
+
+	if code := req.Header.Get(Header); code != "" {
+		m, ok := r.byCode[code]
+		if !ok {
+			return nil, fmt.Errorf("%w %s", ErrUnknownMerchant, code)
+		}
+		return m, nil
+	}
+
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if m, ok := r.byHost[strings.ToLower(host)]; ok {
+		return m, nil
+	}
+	return r.defaultMerchant, nil
+}
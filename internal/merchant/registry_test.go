@@ -0,0 +1,87 @@
+package merchant
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wellywell/bonusy/internal/types"
+)
+
+type staticStore []types.Merchant
+
+func (s staticStore) GetMerchants(ctx context.Context) ([]types.Merchant, error) {
+	return s, nil
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestRegistry(t *testing.T) {
+
+	store := staticStore{
+		{ID: 1, Code: DefaultCode, Currency: "points"},
+		{ID: 2, Code: "coffee", Host: strPtr("Coffee.example.com"), AccrualAddress: strPtr("http://coffee-accrual"),
+			OrderValidation: strPtr("mod97"), Currency: "beans"},
+	}
+
+	r, err := NewRegistry(context.Background(), store, "http://accrual", "luhn")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		host         string
+		header       string
+		expectedCode string
+		wantErr      bool
+	}{
+		{name: "default", host: "localhost:8080", expectedCode: DefaultCode},
+		{name: "by host", host: "coffee.example.com:443", expectedCode: "coffee"},
+		{name: "by header", host: "localhost:8080", header: "coffee", expectedCode: "coffee"},
+		{name: "header wins", host: "coffee.example.com", header: DefaultCode, expectedCode: DefaultCode},
+		{name: "unknown header", host: "localhost", header: "tea", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			req.Host = tc.host
+			if tc.header != "" {
+				req.Header.Set(Header, tc.header)
+			}
+
+			m, err := r.Resolve(req)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, ErrUnknownMerchant)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, m.Code)
+		})
+	}
+
+	t.Run("merchant settings", func(t *testing.T) {
+		coffee, ok := r.ByID(2)
+		assert.True(t, ok)
+		assert.True(t, coffee.Validator.Validate("12345678978"))
+		assert.False(t, coffee.Validator.Validate("49927398716"))
+		assert.Equal(t, coffee.Accrual, r.AccrualClient(2))
+
+		def, _ := r.ByCode(DefaultCode)
+		assert.True(t, def.Validator.Validate("49927398716"))
+		assert.Equal(t, def.Accrual, r.AccrualClient(42))
+	})
+
+	t.Run("no default", func(t *testing.T) {
+		_, err := NewRegistry(context.Background(), staticStore{{ID: 2, Code: "coffee"}}, "", "luhn")
+		assert.ErrorIs(t, err, ErrNoDefault)
+	})
+
+	t.Run("bad validation", func(t *testing.T) {
+		_, err := NewRegistry(context.Background(), staticStore{{ID: 1, Code: DefaultCode, OrderValidation: strPtr("crc")}}, "", "luhn")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// Message - уведомление пользователю; To - логин получателя
+type Message struct {
+	To         string    `json:"to"`
+	MerchantID int       `json:"merchant_id"`
+	Subject    string    `json:"subject"`
+	Body       string    `json:"body"`
+	SentAt     time.Time `json:"sent_at"`
+}
+
+// Notifier доставляет уведомления. В проде - почта или мессенджер магазина,
+// для разработки есть LogNotifier и FileNotifier
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type LogNotifier struct{}
+
+func (LogNotifier) Send(_ context.Context, msg Message) error {
+	logger.Infof("Notification to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileNotifier дописывает уведомления в файл по одному JSON на строку
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Send(_ context.Context, msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write notification %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileNotifier(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "notifications.log")
+	n := NewFileNotifier(path)
+
+	assert.NoError(t, n.Send(context.Background(), Message{To: "user1", Subject: "first"}))
+	assert.NoError(t, n.Send(context.Background(), Message{To: "user2", Subject: "second"}))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 2)
+
+	var msg Message
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &msg))
+	assert.Equal(t, "user2", msg.To)
+	assert.Equal(t, "second", msg.Subject)
+	assert.False(t, msg.SentAt.IsZero())
+}
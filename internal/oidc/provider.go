@@ -0,0 +1,322 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid id token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// не чаще этого перечитываем ключи провайдера при неизвестном kid
+const jwksRefreshInterval = time.Minute
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL - адрес /api/user/oidc/callback, зарегистрированный у провайдера
+	RedirectURL string
+	// AfterLoginURL - куда вернуть браузер после входа, пусто - ответить как обычный вход
+	AfterLoginURL string
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider - клиент OpenID Connect провайдера: authorization code flow с PKCE и проверкой ID token по RS256
+type Provider struct {
+	config    Config
+	client    *http.Client
+	endpoints discovery
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+	// refreshing закрывается, когда закончится идущее чтение ключей; nil - ключи не читаются
+	refreshing chan struct{}
+}
+
+// Identity - проверенные данные пользователя из ID token
+type Identity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	// AuthTime - когда пользователь последний раз вводил данные у провайдера, нулевое - провайдер не сообщил
+	AuthTime time.Time
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string           `json:"nonce"`
+	Email             string           `json:"email"`
+	EmailVerified     bool             `json:"email_verified"`
+	PreferredUsername string           `json:"preferred_username"`
+	AuthTime          *jwt.NumericDate `json:"auth_time,omitempty"`
+}
+
+// NewProvider читает настройки провайдера из /.well-known/openid-configuration
+func NewProvider(ctx context.Context, config Config, client *http.Client) (*Provider, error) {
+	p := &Provider{config: config, client: client}
+
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &p.endpoints); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed %w", err)
+	}
+	// иначе подменённый документ мог бы выдать чужие токены за токены нашего провайдера
+	if p.endpoints.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch: %s", p.endpoints.Issuer)
+	}
+	if p.endpoints.AuthorizationEndpoint == "" || p.endpoints.TokenEndpoint == "" || p.endpoints.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery document is incomplete")
+	}
+	return p, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func (p *Provider) AfterLoginURL() string {
+	return p.config.AfterLoginURL
+}
+
+// RandomString - значение для state, nonce и code verifier
+func RandomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// CodeChallenge - S256 от code verifier (RFC 7636)
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL - куда отправить браузер для входа у провайдера
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", "openid email profile")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", CodeChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.endpoints.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.endpoints.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// ReauthCodeURL - как AuthCodeURL, но провайдер должен заново спросить пользователя и вернуть auth_time
+func (p *Provider) ReauthCodeURL(state string, nonce string, verifier string) string {
+	return p.AuthCodeURL(state, nonce, verifier) + "&" + url.Values{"prompt": {"login"}, "max_age": {"0"}}.Encode()
+}
+
+// Exchange меняет код на токены и возвращает проверенную личность; nonce должен совпасть с отправленным
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("client_secret", p.config.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request failed %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token endpoint returned %d", resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("oidc token response %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrInvalidToken)
+	}
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// Verify проверяет подпись RS256, издателя, получателя, срок действия и nonce ID token
+func (p *Provider) Verify(ctx context.Context, idToken string, nonce string) (*Identity, error) {
+	claims := &idTokenClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("%w: issuer %s", ErrInvalidToken, claims.Issuer)
+	case !claims.VerifyAudience(p.config.ClientID, true):
+		return nil, fmt.Errorf("%w: audience", ErrInvalidToken)
+	case claims.ExpiresAt == nil:
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case nonce == "" || claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce", ErrInvalidToken)
+	}
+
+	identity := &Identity{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+	}
+	if claims.AuthTime != nil {
+		identity.AuthTime = claims.AuthTime.Time
+	}
+	return identity, nil
+}
+
+// key ищет ключ провайдера по kid; при ротации ключей перечитывает JWKS, но не чаще jwksRefreshInterval.
+// Ключи читает один запрос, остальные ждут его результата; известные ключи отдаются, не дожидаясь чтения
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	if key, ok := p.keys[kid]; ok {
+		p.mu.Unlock()
+		return key, nil
+	}
+	if refreshing := p.refreshing; refreshing != nil {
+		p.mu.Unlock()
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return p.cachedKey(kid)
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	refreshing := make(chan struct{})
+	p.refreshing = refreshing
+	p.mu.Unlock()
+
+	keys, err := p.fetchKeys(ctx)
+
+	p.mu.Lock()
+	if err == nil {
+		p.keys = keys
+		p.keysFetched = time.Now()
+	}
+	p.refreshing = nil
+	p.mu.Unlock()
+	close(refreshing)
+
+	if err != nil {
+		return nil, err
+	}
+	return p.cachedKey(kid)
+}
+
+func (p *Provider) cachedKey(kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.endpoints.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks request failed %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+	}
+	return keys, nil
+}
@@ -0,0 +1,230 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubProvider - минимальный OIDC провайдер: discovery, JWKS и token endpoint с заранее заданным ID token
+type stubProvider struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	kid     string
+	idToken string
+	form    url.Values
+	// jwksGate, если задан, задерживает ответ JWKS до закрытия; о начале запроса сообщает jwksRequested
+	jwksGate      chan struct{}
+	jwksRequested chan struct{}
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	stub := &stubProvider{key: key, kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 stub.server.URL,
+			"authorization_endpoint": stub.server.URL + "/authorize",
+			"token_endpoint":         stub.server.URL + "/token",
+			"jwks_uri":               stub.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		if stub.jwksGate != nil {
+			stub.jwksRequested <- struct{}{}
+			<-stub.jwksGate
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": stub.kid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(stub.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(stub.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		stub.form = r.PostForm
+		json.NewEncoder(w).Encode(map[string]string{"id_token": stub.idToken, "token_type": "Bearer"})
+	})
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (s *stubProvider) sign(t *testing.T, claims idTokenClaims, key *rsa.PrivateKey) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return signed
+}
+
+func (s *stubProvider) claims() idTokenClaims {
+	return idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.server.URL,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{"gophermart"},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		Nonce:             "nonce",
+		Email:             "alice@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "alice",
+	}
+}
+
+func TestProviderExchange(t *testing.T) {
+
+	stub := newStubProvider(t)
+	config := Config{Issuer: stub.server.URL, ClientID: "gophermart", ClientSecret: "secret", RedirectURL: "http://localhost/callback"}
+	provider, err := NewProvider(context.Background(), config, stub.server.Client())
+	assert.NoError(t, err)
+
+	authURL, err := url.Parse(provider.AuthCodeURL("state", "nonce", "verifier"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/authorize", authURL.Path)
+	assert.Equal(t, "state", authURL.Query().Get("state"))
+	assert.Equal(t, CodeChallenge("verifier"), authURL.Query().Get("code_challenge"))
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		modify  func(c *idTokenClaims)
+		key     *rsa.PrivateKey
+		wantErr bool
+	}{
+		{"valid", func(c *idTokenClaims) {}, nil, false},
+		{"wrong signature", func(c *idTokenClaims) {}, otherKey, true},
+		{"wrong issuer", func(c *idTokenClaims) { c.Issuer = "https://evil.com" }, nil, true},
+		{"wrong audience", func(c *idTokenClaims) { c.Audience = jwt.ClaimStrings{"other"} }, nil, true},
+		{"expired", func(c *idTokenClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }, nil, true},
+		{"wrong nonce", func(c *idTokenClaims) { c.Nonce = "replayed" }, nil, true},
+		{"no subject", func(c *idTokenClaims) { c.Subject = "" }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := stub.claims()
+			tt.modify(&claims)
+			key := stub.key
+			if tt.key != nil {
+				key = tt.key
+			}
+			stub.idToken = stub.sign(t, claims, key)
+
+			identity, err := provider.Exchange(context.Background(), "code", "verifier", "nonce")
+			assert.Equal(t, "verifier", stub.form.Get("code_verifier"))
+			assert.Equal(t, "authorization_code", stub.form.Get("grant_type"))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, &Identity{Issuer: stub.server.URL, Subject: "subject-1", Email: "alice@example.com",
+				EmailVerified: true, PreferredUsername: "alice"}, identity)
+		})
+	}
+}
+
+func TestProviderReauth(t *testing.T) {
+
+	stub := newStubProvider(t)
+	provider, err := NewProvider(context.Background(), Config{Issuer: stub.server.URL, ClientID: "gophermart"}, stub.server.Client())
+	assert.NoError(t, err)
+
+	authURL, err := url.Parse(provider.ReauthCodeURL("state", "nonce", "verifier"))
+	assert.NoError(t, err)
+	assert.Equal(t, "state", authURL.Query().Get("state"))
+	assert.Equal(t, "login", authURL.Query().Get("prompt"))
+	assert.Equal(t, "0", authURL.Query().Get("max_age"))
+
+	authTime := time.Now().Add(-time.Second).Truncate(time.Second)
+	claims := stub.claims()
+	claims.AuthTime = jwt.NewNumericDate(authTime)
+	stub.idToken = stub.sign(t, claims, stub.key)
+
+	identity, err := provider.Exchange(context.Background(), "code", "verifier", "nonce")
+	assert.NoError(t, err)
+	assert.True(t, authTime.Equal(identity.AuthTime))
+}
+
+func TestProviderUnsignedToken(t *testing.T) {
+
+	stub := newStubProvider(t)
+	config := Config{Issuer: stub.server.URL, ClientID: "gophermart"}
+	provider, err := NewProvider(context.Background(), config, stub.server.Client())
+	assert.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, stub.claims())
+	token.Header["kid"] = stub.kid
+	// подпись HS256 открытым ключом - классическая атака на смешение алгоритмов
+	signed, err := token.SignedString(stub.key.N.Bytes())
+	assert.NoError(t, err)
+
+	_, err = provider.Verify(context.Background(), signed, "nonce")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestProviderKeyRefresh(t *testing.T) {
+
+	stub := newStubProvider(t)
+	provider, err := NewProvider(context.Background(), Config{Issuer: stub.server.URL, ClientID: "gophermart"}, stub.server.Client())
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = provider.key(ctx, stub.kid)
+	assert.NoError(t, err)
+
+	// неизвестный kid перечитывает ключи, и чтение зависает
+	provider.keysFetched = time.Time{}
+	stub.jwksGate = make(chan struct{})
+	stub.jwksRequested = make(chan struct{})
+	unknown := make(chan error)
+	go func() {
+		_, err := provider.key(ctx, "key-2")
+		unknown <- err
+	}()
+	<-stub.jwksRequested
+
+	// известный ключ отдаётся, не дожидаясь чтения
+	known := make(chan error)
+	go func() {
+		_, err := provider.key(ctx, stub.kid)
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "cached key lookup waits for JWKS fetch")
+	}
+
+	close(stub.jwksGate)
+	assert.ErrorIs(t, <-unknown, ErrUnknownKey)
+}
+
+func TestProviderIssuerMismatch(t *testing.T) {
+
+	stub := newStubProvider(t)
+	_, err := NewProvider(context.Background(), Config{Issuer: stub.server.URL + "/other"}, stub.server.Client())
+	assert.Error(t, err)
+}
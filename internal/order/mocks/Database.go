@@ -7,6 +7,8 @@ import (
 
 	mock "github.com/stretchr/testify/mock"
 
+	time "time"
+
 	types "github.com/wellywell/bonusy/internal/types"
 )
 
@@ -23,6 +25,67 @@ func (_m *Database) EXPECT() *Database_Expecter {
 	return &Database_Expecter{mock: &_m.Mock}
 }
 
+// GetOrdersForRecheck provides a mock function with given fields: ctx, window, every, limit
+func (_m *Database) GetOrdersForRecheck(ctx context.Context, window time.Duration, every time.Duration, limit int) ([]types.OrderRecord, error) {
+	ret := _m.Called(ctx, window, every, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetOrdersForRecheck")
+	}
+
+	var r0 []types.OrderRecord
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration, time.Duration, int) ([]types.OrderRecord, error)); ok {
+		return rf(ctx, window, every, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Duration, time.Duration, int) []types.OrderRecord); ok {
+		r0 = rf(ctx, window, every, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.OrderRecord)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Duration, time.Duration, int) error); ok {
+		r1 = rf(ctx, window, every, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Database_GetOrdersForRecheck_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetOrdersForRecheck'
+type Database_GetOrdersForRecheck_Call struct {
+	*mock.Call
+}
+
+// GetOrdersForRecheck is a helper method to define mock.On call
+//   - ctx context.Context
+//   - window time.Duration
+//   - every time.Duration
+//   - limit int
+func (_e *Database_Expecter) GetOrdersForRecheck(ctx interface{}, window interface{}, every interface{}, limit interface{}) *Database_GetOrdersForRecheck_Call {
+	return &Database_GetOrdersForRecheck_Call{Call: _e.mock.On("GetOrdersForRecheck", ctx, window, every, limit)}
+}
+
+func (_c *Database_GetOrdersForRecheck_Call) Run(run func(ctx context.Context, window time.Duration, every time.Duration, limit int)) *Database_GetOrdersForRecheck_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(time.Duration), args[2].(time.Duration), args[3].(int))
+	})
+	return _c
+}
+
+func (_c *Database_GetOrdersForRecheck_Call) Return(_a0 []types.OrderRecord, _a1 error) *Database_GetOrdersForRecheck_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Database_GetOrdersForRecheck_Call) RunAndReturn(run func(context.Context, time.Duration, time.Duration, int) ([]types.OrderRecord, error)) *Database_GetOrdersForRecheck_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // GetUnprocessedOrders provides a mock function with given fields: ctx, startID, limit
 func (_m *Database) GetUnprocessedOrders(ctx context.Context, startID int, limit int) ([]types.OrderRecord, error) {
 	ret := _m.Called(ctx, startID, limit)
@@ -83,17 +146,68 @@ func (_c *Database_GetUnprocessedOrders_Call) RunAndReturn(run func(context.Cont
 	return _c
 }
 
-// UpdateUnprocessedOrder provides a mock function with given fields: ctx, orderID, newStatus, accrual
-func (_m *Database) UpdateUnprocessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64) error {
-	ret := _m.Called(ctx, orderID, newStatus, accrual)
+// UpdateProcessedOrder provides a mock function with given fields: ctx, orderID, newStatus, accrual, source, payload
+func (_m *Database) UpdateProcessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64, source types.StatusSource, payload []byte) error {
+	ret := _m.Called(ctx, orderID, newStatus, accrual, source, payload)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateProcessedOrder")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, types.Status, float64, types.StatusSource, []byte) error); ok {
+		r0 = rf(ctx, orderID, newStatus, accrual, source, payload)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Database_UpdateProcessedOrder_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateProcessedOrder'
+type Database_UpdateProcessedOrder_Call struct {
+	*mock.Call
+}
+
+// UpdateProcessedOrder is a helper method to define mock.On call
+//   - ctx context.Context
+//   - orderID int
+//   - newStatus types.Status
+//   - accrual float64
+//   - source types.StatusSource
+//   - payload []byte
+func (_e *Database_Expecter) UpdateProcessedOrder(ctx interface{}, orderID interface{}, newStatus interface{}, accrual interface{}, source interface{}, payload interface{}) *Database_UpdateProcessedOrder_Call {
+	return &Database_UpdateProcessedOrder_Call{Call: _e.mock.On("UpdateProcessedOrder", ctx, orderID, newStatus, accrual, source, payload)}
+}
+
+func (_c *Database_UpdateProcessedOrder_Call) Run(run func(ctx context.Context, orderID int, newStatus types.Status, accrual float64, source types.StatusSource, payload []byte)) *Database_UpdateProcessedOrder_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int), args[2].(types.Status), args[3].(float64), args[4].(types.StatusSource), args[5].([]byte))
+	})
+	return _c
+}
+
+func (_c *Database_UpdateProcessedOrder_Call) Return(_a0 error) *Database_UpdateProcessedOrder_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Database_UpdateProcessedOrder_Call) RunAndReturn(run func(context.Context, int, types.Status, float64, types.StatusSource, []byte) error) *Database_UpdateProcessedOrder_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateUnprocessedOrder provides a mock function with given fields: ctx, orderID, newStatus, accrual, source, payload
+func (_m *Database) UpdateUnprocessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64, source types.StatusSource, payload []byte) error {
+	ret := _m.Called(ctx, orderID, newStatus, accrual, source, payload)
 
 	if len(ret) == 0 {
 		panic("no return value specified for UpdateUnprocessedOrder")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, int, types.Status, float64) error); ok {
-		r0 = rf(ctx, orderID, newStatus, accrual)
+	if rf, ok := ret.Get(0).(func(context.Context, int, types.Status, float64, types.StatusSource, []byte) error); ok {
+		r0 = rf(ctx, orderID, newStatus, accrual, source, payload)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -111,13 +225,15 @@ type Database_UpdateUnprocessedOrder_Call struct {
 //   - orderID int
 //   - newStatus types.Status
 //   - accrual float64
-func (_e *Database_Expecter) UpdateUnprocessedOrder(ctx interface{}, orderID interface{}, newStatus interface{}, accrual interface{}) *Database_UpdateUnprocessedOrder_Call {
-	return &Database_UpdateUnprocessedOrder_Call{Call: _e.mock.On("UpdateUnprocessedOrder", ctx, orderID, newStatus, accrual)}
+//   - source types.StatusSource
+//   - payload []byte
+func (_e *Database_Expecter) UpdateUnprocessedOrder(ctx interface{}, orderID interface{}, newStatus interface{}, accrual interface{}, source interface{}, payload interface{}) *Database_UpdateUnprocessedOrder_Call {
+	return &Database_UpdateUnprocessedOrder_Call{Call: _e.mock.On("UpdateUnprocessedOrder", ctx, orderID, newStatus, accrual, source, payload)}
 }
 
-func (_c *Database_UpdateUnprocessedOrder_Call) Run(run func(ctx context.Context, orderID int, newStatus types.Status, accrual float64)) *Database_UpdateUnprocessedOrder_Call {
+func (_c *Database_UpdateUnprocessedOrder_Call) Run(run func(ctx context.Context, orderID int, newStatus types.Status, accrual float64, source types.StatusSource, payload []byte)) *Database_UpdateUnprocessedOrder_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(int), args[2].(types.Status), args[3].(float64))
+		run(args[0].(context.Context), args[1].(int), args[2].(types.Status), args[3].(float64), args[4].(types.StatusSource), args[5].([]byte))
 	})
 	return _c
 }
@@ -127,7 +243,7 @@ func (_c *Database_UpdateUnprocessedOrder_Call) Return(_a0 error) *Database_Upda
 	return _c
 }
 
-func (_c *Database_UpdateUnprocessedOrder_Call) RunAndReturn(run func(context.Context, int, types.Status, float64) error) *Database_UpdateUnprocessedOrder_Call {
+func (_c *Database_UpdateUnprocessedOrder_Call) RunAndReturn(run func(context.Context, int, types.Status, float64, types.StatusSource, []byte) error) *Database_UpdateUnprocessedOrder_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -20,9 +20,18 @@ type AccrualClient interface {
 	GetOrderStatus(orderNum string) (*accrual.OrderStatus, error)
 }
 
+// AccrualClientPicker возвращает клиента системы начислений магазина, которому принадлежит заказ
+type AccrualClientPicker func(merchantID int) AccrualClient
+
 type Database interface {
 	GetUnprocessedOrders(ctx context.Context, startID int, limit int) ([]types.OrderRecord, error)
-	UpdateUnprocessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64) error
+	UpdateUnprocessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64, source types.StatusSource, payload []byte) error
+	GetOrdersForRecheck(ctx context.Context, window time.Duration, every time.Duration, limit int) ([]types.OrderRecord, error)
+	UpdateProcessedOrder(ctx context.Context, orderID int, newStatus types.Status, accrual float64, source types.StatusSource, payload []byte) error
+}
+
+type Publisher interface {
+	Publish(event types.OrderEvent)
 }
 
 func GenerateStatusTasks(ctx context.Context, database Database) chan types.OrderRecord {
@@ -65,7 +74,50 @@ func GenerateStatusTasks(ctx context.Context, database Database) chan types.Orde
 	return tasks
 }
 
-func CheckAccrualOrders(ctx context.Context, tasks <-chan types.OrderRecord, client AccrualClient) chan OrderUpdate {
+// GenerateRecheckTasks повторно отдаёт на проверку заказы, начисленные не раньше window назад,
+// не чаще раза в every: система начислений может отменить или изменить начисление, например при возврате
+func GenerateRecheckTasks(ctx context.Context, database Database, window time.Duration, every time.Duration) chan types.OrderRecord {
+
+	tasks := make(chan types.OrderRecord)
+
+	go func(ctx context.Context) {
+		defer close(tasks)
+
+		limit := 100
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			records, err := database.GetOrdersForRecheck(ctx, window, every, limit)
+			if err != nil {
+				logger.Errorf("Could not get orders for recheck %s", err.Error())
+			}
+			if len(records) == 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(every / 10):
+				}
+				continue
+			}
+			for _, task := range records {
+				logger.Infof("Rechecking order %v", task)
+				select {
+				case <-ctx.Done():
+					return
+				case tasks <- task:
+				}
+			}
+		}
+	}(ctx)
+
+	return tasks
+}
+
+func CheckAccrualOrders(ctx context.Context, tasks <-chan types.OrderRecord, clients AccrualClientPicker) chan OrderUpdate {
 
 	updates := make(chan OrderUpdate)
 
@@ -80,7 +132,7 @@ func CheckAccrualOrders(ctx context.Context, tasks <-chan types.OrderRecord, cli
 				if !ok {
 					return
 				}
-				result, err := retryThrottle(task.OrderNum, client)
+				result, err := retryThrottle(task.OrderNum, clients(task.MerchantID))
 				if err != nil {
 					if errors.Is(err, accrual.ErrOrderNotExists) {
 						logger.Infof("Order %s not found", task.OrderNum)
@@ -93,7 +145,7 @@ func CheckAccrualOrders(ctx context.Context, tasks <-chan types.OrderRecord, cli
 					logger.Error(err)
 					continue
 				}
-				if result.Status == task.Status {
+				if result.Status == task.Status && result.Accrual == task.Accrual {
 					continue
 				}
 				logger.Infof("Got order update %v", result)
@@ -129,7 +181,7 @@ func retryThrottle(order string, client AccrualClient) (*accrual.OrderStatus, er
 	}
 }
 
-func UpdateStatuses(ctx context.Context, tasks <-chan OrderUpdate, database Database) {
+func UpdateStatuses(ctx context.Context, tasks <-chan OrderUpdate, database Database, publisher Publisher) {
 	go func(ctx context.Context) {
 		for {
 			select {
@@ -139,11 +191,22 @@ func UpdateStatuses(ctx context.Context, tasks <-chan OrderUpdate, database Data
 				if !ok {
 					return
 				}
-				err := database.UpdateUnprocessedOrder(ctx, task.order.OrderID, task.status.Status, task.status.Accrual)
+				var err error
+				if task.order.Status == types.ProcessedStatus {
+					err = database.UpdateProcessedOrder(ctx, task.order.OrderID, task.status.Status, task.status.Accrual, types.SourceRecheck, task.status.Raw)
+				} else {
+					err = database.UpdateUnprocessedOrder(ctx, task.order.OrderID, task.status.Status, task.status.Accrual, types.SourcePoll, task.status.Raw)
+				}
 				if err != nil {
 					logger.Error(err.Error())
 				} else {
 					logger.Infof("Updated order %s in database, new status: %s", task.order.OrderNum, task.status.Status)
+					publisher.Publish(types.OrderEvent{
+						UserID:  task.order.UserID,
+						Number:  task.order.OrderNum,
+						Status:  task.status.Status,
+						Accrual: task.status.Accrual,
+					})
 				}
 			}
 		}
@@ -46,7 +46,7 @@ func TestCheckAccrualOrders(t *testing.T) {
 			c.EXPECT().GetOrderStatus("123").Return(tt.result, tt.wantError).Once()
 
 			inp := make(chan types.OrderRecord)
-			out := CheckAccrualOrders(timeOutCtx, inp, c)
+			out := CheckAccrualOrders(timeOutCtx, inp, func(int) AccrualClient { return c })
 
 			inp <- types.OrderRecord{OrderNum: "123", Status: "NEW", OrderID: 1}
 
@@ -126,11 +126,35 @@ func TestGenerateStatusTasks(t *testing.T) {
 	})
 }
 
+func TestGenerateRecheckTasks(t *testing.T) {
+
+	d := mocks.NewDatabase(t)
+
+	t.Run("test get processed", func(t *testing.T) {
+		ctx := context.Background()
+		timeOutCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+		defer cancel()
+
+		order := types.OrderRecord{OrderNum: "1", Status: types.ProcessedStatus, OrderID: 1, Accrual: 10}
+
+		d.EXPECT().GetOrdersForRecheck(timeOutCtx, 24*time.Hour, time.Hour, 100).Return(
+			[]types.OrderRecord{order}, nil).Once()
+		d.EXPECT().GetOrdersForRecheck(timeOutCtx, 24*time.Hour, time.Hour, 100).Return(
+			[]types.OrderRecord{}, nil).Once()
+		ch := GenerateRecheckTasks(timeOutCtx, d, 24*time.Hour, time.Hour)
+
+		res := <-ch
+		assert.Equal(t, order, res)
+		<-timeOutCtx.Done()
+	})
+}
+
 func TestUpdateStatuses(t *testing.T) {
 
 	inp := make(chan OrderUpdate)
 
 	d := mocks.NewDatabase(t)
+	p := mocks.NewPublisher(t)
 
 	ctx := context.Background()
 	timeOutCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
@@ -138,11 +162,36 @@ func TestUpdateStatuses(t *testing.T) {
 
 	t.Run("update statuses", func(t *testing.T) {
 
-		d.EXPECT().UpdateUnprocessedOrder(timeOutCtx, 1, types.ProcessedStatus, 10.0).Return(nil).Once()
-		UpdateStatuses(timeOutCtx, inp, d)
+		d.EXPECT().UpdateUnprocessedOrder(timeOutCtx, 1, types.ProcessedStatus, 10.0, types.SourcePoll, []byte(`{"status": "PROCESSED"}`)).Return(nil).Once()
+		p.EXPECT().Publish(types.OrderEvent{UserID: 2, Number: "123", Status: types.ProcessedStatus, Accrual: 10}).Once()
+		UpdateStatuses(timeOutCtx, inp, d, p)
+		inp <- OrderUpdate{
+			order:  types.OrderRecord{OrderNum: "123", Status: "NEW", OrderID: 1, UserID: 2},
+			status: accrual.OrderStatus{Order: "123", Status: "PROCESSED", Accrual: 10, Raw: []byte(`{"status": "PROCESSED"}`)}}
+
+		<-timeOutCtx.Done()
+	})
+}
+
+func TestUpdateStatusesReversal(t *testing.T) {
+
+	inp := make(chan OrderUpdate)
+
+	d := mocks.NewDatabase(t)
+	p := mocks.NewPublisher(t)
+
+	ctx := context.Background()
+	timeOutCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	t.Run("reverse processed order", func(t *testing.T) {
+
+		d.EXPECT().UpdateProcessedOrder(timeOutCtx, 1, types.InvalidStatus, 0.0, types.SourceRecheck, []byte(`{"status": "INVALID"}`)).Return(nil).Once()
+		p.EXPECT().Publish(types.OrderEvent{UserID: 2, Number: "123", Status: types.InvalidStatus}).Once()
+		UpdateStatuses(timeOutCtx, inp, d, p)
 		inp <- OrderUpdate{
-			order:  types.OrderRecord{OrderNum: "123", Status: "NEW", OrderID: 1},
-			status: accrual.OrderStatus{Order: "123", Status: "PROCESSED", Accrual: 10}}
+			order:  types.OrderRecord{OrderNum: "123", Status: types.ProcessedStatus, OrderID: 1, UserID: 2, Accrual: 10},
+			status: accrual.OrderStatus{Order: "123", Status: "INVALID", Raw: []byte(`{"status": "INVALID"}`)}}
 
 		<-timeOutCtx.Done()
 	})
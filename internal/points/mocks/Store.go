@@ -0,0 +1,93 @@
+// Code generated by mockery v2.43.2. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// Store is an autogenerated mock type for the Store type
+type Store struct {
+	mock.Mock
+}
+
+type Store_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *Store) EXPECT() *Store_Expecter {
+	return &Store_Expecter{mock: &_m.Mock}
+}
+
+// SettlePoints provides a mock function with given fields: ctx, limit
+func (_m *Store) SettlePoints(ctx context.Context, limit int) (int, error) {
+	ret := _m.Called(ctx, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SettlePoints")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) (int, error)); ok {
+		return rf(ctx, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) int); ok {
+		r0 = rf(ctx, limit)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Store_SettlePoints_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SettlePoints'
+type Store_SettlePoints_Call struct {
+	*mock.Call
+}
+
+// SettlePoints is a helper method to define mock.On call
+//   - ctx context.Context
+//   - limit int
+func (_e *Store_Expecter) SettlePoints(ctx interface{}, limit interface{}) *Store_SettlePoints_Call {
+	return &Store_SettlePoints_Call{Call: _e.mock.On("SettlePoints", ctx, limit)}
+}
+
+func (_c *Store_SettlePoints_Call) Run(run func(ctx context.Context, limit int)) *Store_SettlePoints_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int))
+	})
+	return _c
+}
+
+func (_c *Store_SettlePoints_Call) Return(_a0 int, _a1 error) *Store_SettlePoints_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Store_SettlePoints_Call) RunAndReturn(run func(context.Context, int) (int, error)) *Store_SettlePoints_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewStore creates a new instance of Store. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewStore(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *Store {
+	mock := &Store{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
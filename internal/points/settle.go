@@ -0,0 +1,48 @@
+package points
+
+import (
+	"context"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+const settleBatchSize = 100
+
+type Store interface {
+	SettlePoints(ctx context.Context, limit int) (int, error)
+}
+
+// RunSettlement периодически снимает холд с созревших партий баллов и сжигает просроченные
+func RunSettlement(ctx context.Context, store Store, interval time.Duration) {
+	go func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			settleAll(ctx, store)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}(ctx)
+}
+
+func settleAll(ctx context.Context, store Store) {
+	for {
+		users, err := store.SettlePoints(ctx, settleBatchSize)
+		if err != nil {
+			logger.Errorf("Could not settle points %s", err.Error())
+			return
+		}
+		if users > 0 {
+			logger.Infof("Settled points of %d users", users)
+		}
+		if users < settleBatchSize {
+			return
+		}
+	}
+}
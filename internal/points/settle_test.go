@@ -0,0 +1,39 @@
+package points
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wellywell/bonusy/internal/points/mocks"
+)
+
+func TestRunSettlement(t *testing.T) {
+
+	t.Run("settle in batches", func(t *testing.T) {
+		s := mocks.NewStore(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		s.EXPECT().SettlePoints(ctx, settleBatchSize).Return(settleBatchSize, nil).Twice()
+		s.EXPECT().SettlePoints(ctx, settleBatchSize).Return(3, nil).Once()
+
+		RunSettlement(ctx, s, time.Hour)
+		<-ctx.Done()
+	})
+
+	t.Run("stop on error until next tick", func(t *testing.T) {
+		s := mocks.NewStore(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		s.EXPECT().SettlePoints(ctx, settleBatchSize).Return(0, fmt.Errorf("Some error")).Once()
+		s.EXPECT().SettlePoints(ctx, settleBatchSize).Return(0, nil).Once()
+
+		RunSettlement(ctx, s, 300*time.Millisecond)
+		<-ctx.Done()
+	})
+}
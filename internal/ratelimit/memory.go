@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// как часто выбрасывать закончившиеся окна
+const sweepInterval = time.Minute
+
+type memoryWindow struct {
+	start time.Time
+	end   time.Time
+	count int
+}
+
+// MemoryStore хранит счётчики в памяти процесса; при нескольких репликах каждая считает отдельно
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryWindow
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{windows: make(map[string]*memoryWindow), now: time.Now}
+}
+
+func (s *MemoryStore) Hit(_ context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, w := range s.windows {
+			if !now.Before(w.end) {
+				delete(s.windows, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.end) {
+		w = &memoryWindow{start: now, end: now.Add(window)}
+		s.windows[key] = w
+	}
+	w.count++
+	return w.count, w.end.Sub(now), nil
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	hit := func(key string) (int, time.Duration) {
+		count, reset, err := store.Hit(context.Background(), key, time.Minute)
+		assert.NoError(t, err)
+		return count, reset
+	}
+
+	count, reset := hit("a")
+	assert.Equal(t, 1, count)
+	assert.Equal(t, time.Minute, reset)
+	// у другого ключа свой счётчик
+	count, _ = hit("b")
+	assert.Equal(t, 1, count)
+
+	now = now.Add(40 * time.Second)
+	count, reset = hit("a")
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 20*time.Second, reset)
+
+	now = now.Add(20 * time.Second)
+	count, reset = hit("a")
+	assert.Equal(t, 1, count)
+	assert.Equal(t, time.Minute, reset)
+	// закончившееся окно "b" выброшено при очистке
+	assert.Len(t, store.windows, 1)
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type PGBackend interface {
+	IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error)
+	DeleteRateLimitsBefore(ctx context.Context, before time.Time) error
+}
+
+// PGStore - общие для всех реплик счётчики в Postgres
+type PGStore struct {
+	backend PGBackend
+}
+
+func NewPGStore(backend PGBackend) *PGStore {
+	return &PGStore{backend: backend}
+}
+
+func (s *PGStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error) {
+	return s.backend.IncrementRateLimit(ctx, key, window)
+}
+
+// RunCleanup периодически удаляет счётчики, окна которых старше maxWindow
+func RunCleanup(ctx context.Context, backend PGBackend, maxWindow time.Duration) {
+	go func(ctx context.Context) {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := backend.DeleteRateLimitsBefore(ctx, time.Now().Add(-maxWindow)); err != nil {
+				logger.Errorf("Could not clean up rate limits %s", err.Error())
+			}
+		}
+	}(ctx)
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// Store считает запросы по ключу в окне, начатом первым запросом
+type Store interface {
+	// Hit учитывает запрос и возвращает число запросов в текущем окне и время до его конца
+	Hit(ctx context.Context, key string, window time.Duration) (int, time.Duration, error)
+}
+
+// Rule - не больше Limit запросов за Window; Limit <= 0 - без ограничения
+type Rule struct {
+	// Name отделяет счётчики разных групп маршрутов
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// KeyFunc - чьи запросы считать вместе
+type KeyFunc func(r *http.Request) string
+
+// ByIP - по адресу клиента; за доверенным прокси его подставляет request.TrustedProxies
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+type Limiter struct {
+	store Store
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store}
+}
+
+// Allow учитывает запрос и возвращает, сколько ждать до следующего разрешённого, 0 - запрос разрешён.
+// Если счётчик недоступен, запрос пропускается: ограничение не должно останавливать сервис
+func (l *Limiter) Allow(ctx context.Context, rule Rule, key string) time.Duration {
+	if rule.Limit <= 0 {
+		return 0
+	}
+	count, reset, err := l.store.Hit(ctx, rule.Name+"|"+key, rule.Window)
+	if err != nil {
+		logger.Errorf("Rate limit store failed %s", err.Error())
+		return 0
+	}
+	if count > rule.Limit {
+		return max(reset, time.Second)
+	}
+	return 0
+}
+
+// Handler отвечает 429 с Retry-After, когда запросы с одним ключом превышают правило
+func (l *Limiter) Handler(rule Rule, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if wait := l.Allow(r.Context(), rule, key(r)); wait > 0 {
+				TooManyRequests(w, wait)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStore struct{}
+
+func (failingStore) Hit(context.Context, string, time.Duration) (int, time.Duration, error) {
+	return 0, 0, fmt.Errorf("unavailable")
+}
+
+func TestLimiterHandler(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		store      Store
+		rule       Rule
+		requests   int
+		wantStatus int
+	}{
+		{"within limit", NewMemoryStore(), Rule{Name: "test", Limit: 3, Window: time.Minute}, 3, http.StatusOK},
+		{"over limit", NewMemoryStore(), Rule{Name: "test", Limit: 3, Window: time.Minute}, 4, http.StatusTooManyRequests},
+		{"no limit", NewMemoryStore(), Rule{Name: "test", Window: time.Minute}, 10, http.StatusOK},
+		{"store unavailable", failingStore{}, Rule{Name: "test", Limit: 1, Window: time.Minute}, 2, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewLimiter(tt.store).Handler(tt.rule, ByIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			var w *httptest.ResponseRecorder
+			for range tt.requests {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = "10.0.0.1:1234"
+				w = httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+			}
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusTooManyRequests {
+				assert.Equal(t, "60", w.Header().Get("Retry-After"))
+			}
+
+			// с другого адреса запросы считаются отдельно
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.0.0.2:1234"
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
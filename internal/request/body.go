@@ -0,0 +1,53 @@
+package request
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+)
+
+// MaxBytes ограничивает тело запроса n байтами. Стоит после распаковки, поэтому считает распакованные байты;
+// чтение сверх лимита возвращает *http.MaxBytesError
+func MaxBytes(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Body пропускает тело не больше limit байт одного из типов contentTypes. Запросы без тела не проверяются.
+// Неразборчивый Content-Type - 400, отсутствующий или другой - 415, заявленный Content-Length больше лимита - 413
+func Body(limit int64, contentTypes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			header := r.Header.Get("Content-Type")
+			if header == "" {
+				http.Error(w, "Content-Type required", http.StatusUnsupportedMediaType)
+				return
+			}
+			mediaType, _, err := mime.ParseMediaType(header)
+			if err != nil {
+				http.Error(w, "Invalid Content-Type", http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(contentTypes, mediaType) {
+				http.Error(w, "Unsupported Content-Type", http.StatusUnsupportedMediaType)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
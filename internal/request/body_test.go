@@ -0,0 +1,76 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readAll отвечает 413, если тело оказалось больше лимита, как handlers.readBody
+var readAll = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestBody(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		// chunked - длина тела заранее не известна
+		chunked    bool
+		wantStatus int
+	}{
+		{name: "json", body: `{"login": "user"}`, contentType: "application/json", wantStatus: http.StatusOK},
+		{name: "json with charset", body: `{}`, contentType: "Application/JSON; charset=utf-8", wantStatus: http.StatusOK},
+		{name: "no body", body: "", contentType: "", wantStatus: http.StatusOK},
+		{name: "no content type", body: `{}`, contentType: "", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "wrong content type", body: `{}`, contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "form", body: `a=b`, contentType: "application/x-www-form-urlencoded", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "malformed content type", body: `{}`, contentType: "application/json; charset", wantStatus: http.StatusBadRequest},
+		{name: "too large", body: strings.Repeat("a", 65), contentType: "application/json", wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "too large chunked", body: strings.Repeat("a", 65), contentType: "application/json", chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "at limit chunked", body: strings.Repeat("a", 64), contentType: "application/json", chunked: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+
+			Body(64, "application/json")(readAll).ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+
+	for _, tt := range []struct {
+		size       int
+		wantStatus int
+	}{{10, http.StatusOK}, {11, http.StatusRequestEntityTooLarge}} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", tt.size)))
+		w := httptest.NewRecorder()
+
+		MaxBytes(10)(readAll).ServeHTTP(w, req)
+		assert.Equal(t, tt.wantStatus, w.Code)
+	}
+}
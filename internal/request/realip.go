@@ -0,0 +1,59 @@
+package request
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies подставляет в RemoteAddr адрес клиента из X-Forwarded-For, если запрос пришёл от доверенного прокси.
+// Заголовок разбирается справа налево до первого недоверенного адреса: левее него адреса мог записать сам клиент.
+// Лимиты по адресу и учёт неудачных входов берут адрес из RemoteAddr
+type TrustedProxies struct {
+	Prefixes []netip.Prefix
+}
+
+func (p TrustedProxies) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(p.Prefixes) == 0 || !p.trusted(remoteHost(r.RemoteAddr)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(forwarded[i])
+			if _, err := netip.ParseAddr(addr); err != nil {
+				break
+			}
+			r.RemoteAddr = addr
+			if !p.trusted(addr) {
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p TrustedProxies) trusted(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.Prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,46 @@
+package request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxies(t *testing.T) {
+
+	proxies := TrustedProxies{Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		wantAddr   string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:1234", wantAddr: "203.0.113.7:1234"},
+		{name: "header from untrusted peer", remoteAddr: "203.0.113.7:1234", forwarded: []string{"198.51.100.1"}, wantAddr: "203.0.113.7:1234"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", forwarded: []string{"198.51.100.1"}, wantAddr: "198.51.100.1"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.1:1234", wantAddr: "10.0.0.1:1234"},
+		{name: "spoofed left part", remoteAddr: "10.0.0.1:1234", forwarded: []string{"1.1.1.1, 198.51.100.1"}, wantAddr: "198.51.100.1"},
+		{name: "proxy chain", remoteAddr: "10.0.0.1:1234", forwarded: []string{"198.51.100.1", "10.0.0.2"}, wantAddr: "198.51.100.1"},
+		{name: "garbage", remoteAddr: "10.0.0.1:1234", forwarded: []string{"unknown, 10.0.0.2"}, wantAddr: "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			var got string
+			proxies.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			})).ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.wantAddr, got)
+		})
+	}
+}
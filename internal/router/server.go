@@ -1,7 +1,9 @@
 package router
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
@@ -10,10 +12,28 @@ import (
 	"github.com/wellywell/bonusy/internal/auth"
 	"github.com/wellywell/bonusy/internal/config"
 	"github.com/wellywell/bonusy/internal/handlers"
+	"github.com/wellywell/bonusy/internal/merchant"
+	"github.com/wellywell/bonusy/internal/ratelimit"
+	"github.com/wellywell/bonusy/internal/request"
 )
 
 const (
 	compressLevel = 5
+	// окно, за которое считаются лимиты запросов из настроек
+	rateLimitWindow = time.Minute
+
+	// лимиты тела запроса после распаковки
+	jsonBodyLimit  = 16 << 10
+	orderBodyLimit = 256
+	// handlers.maxOrdersBatchSize номеров с запасом на кавычки и переводы строк
+	batchBodyLimit = 128 << 10
+	// для всех маршрутов, в том числе без своего лимита: не больше самого большого из них
+	maxBodyLimit = batchBodyLimit
+)
+
+const (
+	jsonType = "application/json"
+	textType = "text/plain"
 )
 
 type Middleware interface {
@@ -25,34 +45,129 @@ type Router struct {
 	router  *chi.Mux
 }
 
-func NewRouter(conf *config.ServerConfig, h *handlers.HandlerSet, middlewares ...Middleware) *Router {
+func NewRouter(conf *config.ServerConfig, h *handlers.HandlerSet, merchants *merchant.Registry, users auth.UserStore, keys auth.APIKeyStore,
+	limiter *ratelimit.Limiter, middlewares ...Middleware) *Router {
 
 	r := chi.NewRouter()
 
 	for _, m := range middlewares {
 		r.Use(m.Handle)
 	}
+	// после распаковки: сжатое тело не может развернуться больше лимита
+	r.Use(request.MaxBytes(maxBodyLimit))
+	r.Use(limiter.Handler(ratelimit.Rule{Name: "ip", Limit: conf.RateLimitIP, Window: rateLimitWindow}, ratelimit.ByIP))
+	r.Use(merchant.Middleware{Registry: merchants}.Handle)
 	//r.Use(middleware.Logger)
 	r.Use(middleware.Compress(compressLevel)) // TODO test
 
-	r.Post("/api/user/register", h.HandleRegisterUser)
-	r.Post("/api/user/login", h.HandleLogin)
+	jsonBody := request.Body(jsonBodyLimit, jsonType)
+	orderBody := request.Body(orderBodyLimit, textType)
+	batchBody := request.Body(batchBodyLimit, jsonType, textType)
 
-	authMiddleware := &auth.AuthenticateMiddleware{Secret: conf.Secret}
+	r.Get("/api/merchant", h.HandleGetMerchant)
 
+	// вход и регистрация - по адресу, пользователь ещё не известен
 	r.Group(func(r chi.Router) {
 
+		r.Use(limiter.Handler(ratelimit.Rule{Name: "auth", Limit: conf.RateLimitAuth, Window: rateLimitWindow}, ratelimit.ByIP))
+		r.Use(jsonBody)
+		r.Post("/api/user/register", h.HandleRegisterUser)
+		r.Post("/api/user/login", h.HandleLogin)
+		r.Post("/api/user/login/2fa", h.HandleLoginTwoFactor)
+		r.Post("/api/user/password/reset", h.HandleRequestPasswordReset)
+		r.Post("/api/user/password/reset/confirm", h.HandleConfirmPasswordReset)
+		if conf.OIDCIssuer != "" {
+			r.Get("/api/user/oidc/login", h.HandleOIDCLogin)
+			r.Get("/api/user/oidc/callback", h.HandleOIDCCallback)
+		}
+	})
+
+	authMiddleware := &auth.AuthenticateMiddleware{Secret: conf.Secret, Users: users}
+	apiKeys := auth.NewAPIKeyMiddleware(keys, users, limiter)
+	userLimit := limiter.Handler(ratelimit.Rule{Name: "user", Limit: conf.RateLimitUser, Window: rateLimitWindow}, userKey)
+	adminLimit := limiter.Handler(ratelimit.Rule{Name: "admin", Limit: conf.RateLimitAdmin, Window: rateLimitWindow}, userKey)
+	csrf := auth.CSRFMiddleware{TrustedOrigins: conf.TrustedOrigins()}
+
+	read := auth.RequirePermission(auth.PermAccountRead)
+	write := auth.RequirePermission(auth.PermAccountWrite)
+	uploadOrders := auth.RequirePermission(auth.PermOrdersWrite)
+
+	// просмотр счёта и загрузка заказов доступны и по API ключу
+	r.Group(func(r chi.Router) {
+
+		r.Use(apiKeys.Handle)
+		r.Use(csrf.Handle)
 		r.Use(authMiddleware.Handle)
-		r.Post("/api/user/orders", h.HandlePostUserOrder)
-		r.Get("/api/user/orders", h.HandleGetUserOrders)
-		r.Get("/api/user/balance", h.HandleGetUserBalance)
-		r.Post("/api/user/balance/withdraw", h.HandlePostWithdraw)
-		r.Get("/api/user/withdrawals", h.HandleGetUserWithdrawals)
+		r.Use(userLimit)
+		r.With(uploadOrders, orderBody).Post("/api/user/orders", h.HandlePostUserOrder)
+		r.With(uploadOrders, batchBody).Post("/api/user/orders/batch", h.HandlePostUserOrdersBatch)
+		r.With(read).Get("/api/user/orders", h.HandleGetUserOrders)
+		r.With(read).Get("/api/user/orders/stream", h.HandleGetUserOrdersStream)
+		r.With(read).Get("/api/user/orders/{number}", h.HandleGetUserOrder)
+		r.With(read).Get("/api/user/orders/{number}/history", h.HandleGetUserOrderHistory)
+		r.With(read).Get("/api/user/balance", h.HandleGetUserBalance)
+		r.With(read).Get("/api/user/withdrawals", h.HandleGetUserWithdrawals)
+		r.With(read).Get("/api/user/transfers", h.HandleGetUserTransfers)
+	})
+
+	// списания, переводы и управление учётной записью - только из сессии, утёкший ключ не должен давать к ним доступ
+	r.Group(func(r chi.Router) {
+
+		r.Use(csrf.Handle)
+		r.Use(authMiddleware.Handle)
+		r.Use(userLimit)
+		r.Use(jsonBody)
+		r.With(write).Post("/api/user/balance/withdraw", h.HandlePostWithdraw)
+		r.With(write).Post("/api/user/balance/transfer", h.HandlePostTransfer)
+		r.With(write).Delete("/api/user", h.HandleDeleteUser)
+		r.With(write).Post("/api/user/password", h.HandleChangePassword)
+		r.With(write).Post("/api/user/2fa", h.HandleEnrollTwoFactor)
+		r.With(write).Post("/api/user/2fa/confirm", h.HandleConfirmTwoFactor)
+		r.With(write).Delete("/api/user/2fa", h.HandleDisableTwoFactor)
+		r.With(read).Get("/api/user/sessions", h.HandleGetUserSessions)
+		r.With(write).Delete("/api/user/sessions", h.HandleRevokeOtherSessions)
+		r.With(write).Delete("/api/user/sessions/{id}", h.HandleRevokeSession)
+		r.With(write).Post("/api/user/keys", h.HandleCreateAPIKey)
+		r.With(read).Get("/api/user/keys", h.HandleGetAPIKeys)
+		r.With(write).Delete("/api/user/keys/{id}", h.HandleRevokeAPIKey)
+		if conf.OIDCIssuer != "" {
+			r.With(write).Post("/api/user/oidc/link", h.HandleOIDCLink)
+			r.With(write).Post("/api/user/oidc/reauth", h.HandleOIDCReauth)
+		}
+	})
+
+	r.Route("/api/admin", func(r chi.Router) {
+
+		r.Use(csrf.Handle)
+		r.Use(authMiddleware.Handle)
+		r.Use(adminLimit)
+		r.Use(jsonBody)
+		r.With(auth.RequirePermission(auth.PermUsersRead)).Get("/users/{login}", h.HandleAdminGetUser)
+		r.With(auth.RequirePermission(auth.PermUsersRead)).Get("/users/{login}/audit", h.HandleAdminGetUserAudit)
+		r.With(auth.RequirePermission(auth.PermBalanceAdjust)).Post("/users/{login}/balance", h.HandleAdminAdjustBalance)
+		r.With(auth.RequirePermission(auth.PermUsersWrite)).Post("/users/{login}/lock", h.HandleAdminLockUser)
+		r.With(auth.RequirePermission(auth.PermUsersWrite)).Post("/users/{login}/unlock", h.HandleAdminUnlockUser)
+		r.With(auth.RequirePermission(auth.PermUsersWrite)).Delete("/users/{login}", h.HandleAdminDeleteUser)
+		r.With(auth.RequirePermission(auth.PermRolesManage)).Post("/users/{login}/role", h.HandleAdminSetUserRole)
+		r.With(auth.RequirePermission(auth.PermOrdersManage)).Post("/orders/{number}/recheck", h.HandleAdminRecheckOrder)
+		r.With(auth.RequirePermission(auth.PermOrdersManage)).Post("/orders/{number}/reassign", h.HandleAdminReassignOrder)
+		r.With(auth.RequirePermission(auth.PermAPIKeysManage)).Post("/keys", h.HandleAdminCreateAPIKey)
+		r.With(auth.RequirePermission(auth.PermAPIKeysManage)).Get("/keys", h.HandleAdminGetAPIKeys)
+		r.With(auth.RequirePermission(auth.PermAPIKeysManage)).Delete("/keys/{id}", h.HandleAdminRevokeAPIKey)
 	})
 
 	return &Router{router: r, address: conf.RunAddress}
 }
 
+// userKey - счётчик на пользователя, какой бы сессией или ключом он ни пришёл
+func userKey(r *http.Request) string {
+	claims, ok := auth.GetAuthenticatedClaims(r)
+	if !ok {
+		return ratelimit.ByIP(r)
+	}
+	return fmt.Sprintf("user:%d:%s", claims.MerchantID, claims.Username)
+}
+
 func (r *Router) ListenAndServe() error {
 	err := http.ListenAndServe(r.address, r.router)
 	return err
@@ -6,26 +6,51 @@
 package router
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/jackc/pgx/v5"
 	logger "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/wellywell/bonusy/internal/auth"
+	"github.com/wellywell/bonusy/internal/compress"
 	"github.com/wellywell/bonusy/internal/config"
 	"github.com/wellywell/bonusy/internal/db"
+	"github.com/wellywell/bonusy/internal/events"
 	"github.com/wellywell/bonusy/internal/handlers"
+	"github.com/wellywell/bonusy/internal/merchant"
+	"github.com/wellywell/bonusy/internal/notify"
+	"github.com/wellywell/bonusy/internal/oidc"
+	"github.com/wellywell/bonusy/internal/ratelimit"
 	"github.com/wellywell/bonusy/internal/testutils"
 	"github.com/wellywell/bonusy/internal/types"
+	"golang.org/x/crypto/bcrypt"
 )
 
+var notificationsFile string
+
+const withdrawTwoFactorSum = 100
+
+const apiKeyRateLimit = 5
+
 var DBDSN string
 
 func TestMain(m *testing.M) {
@@ -48,11 +73,29 @@ func runMain(m *testing.M) (int, error) {
 
 	DBDSN = databaseDSN
 
-	database, err := db.NewDatabase(DBDSN)
+	database, err := db.NewDatabase(DBDSN, types.PointsPolicy{})
+	if err != nil {
+		return 1, err
+	}
+	notificationsFile = filepath.Join(os.TempDir(), fmt.Sprintf("bonusy-notifications-%d.log", os.Getpid()))
+	handlerSet, err := newHandlerSet(database, nil)
+	if err != nil {
+		return 1, err
+	}
+
+	conn, err := pgx.Connect(context.Background(), DBDSN)
+	if err != nil {
+		return 1, err
+	}
+	_, err = conn.Exec(context.Background(), "INSERT INTO merchant (code, name, order_validation, currency) VALUES ('coffee', 'Coffee', 'mod97', 'beans')")
+	if err != nil {
+		return 1, err
+	}
+
+	merchants, err := merchant.NewRegistry(context.Background(), database, "", "luhn")
 	if err != nil {
 		return 1, err
 	}
-	handlerSet := handlers.NewHandlerSet([]byte("secret"), 1, database)
 
 	config := config.ServerConfig{
 		Secret:      []byte("secret"),
@@ -60,7 +103,7 @@ func runMain(m *testing.M) (int, error) {
 		DatabaseDSN: DBDSN,
 	}
 
-	r := NewRouter(&config, handlerSet)
+	r := NewRouter(&config, handlerSet, merchants, database, database, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), compress.RequestUngzipper{})
 
 	go r.ListenAndServe()
 
@@ -69,6 +112,18 @@ func runMain(m *testing.M) (int, error) {
 
 }
 
+func newHandlerSet(database *db.Database, oidcProvider *oidc.Provider) (*handlers.HandlerSet, error) {
+	loginPolicy := auth.LoginPolicy{MaxAttempts: 3, MaxIPAttempts: 1000, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+	// в тестах пароли короткие
+	passwordPolicy := auth.PasswordPolicy{MinLength: 1}
+	hasher, err := auth.NewHasher(auth.HashPolicy{Algorithm: auth.HashBcrypt, BcryptCost: bcrypt.MinCost, MaxConcurrent: 4, QueueTimeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return handlers.NewHandlerSet([]byte("secret"), auth.CookieOptions{MaxAge: 3600, SameSite: http.SameSiteLaxMode}, database, events.NewBroker(), loginPolicy,
+		passwordPolicy, hasher, notify.NewFileNotifier(notificationsFile), withdrawTwoFactorSum, oidcProvider, apiKeyRateLimit), nil
+}
+
 func TestRegisterUser(t *testing.T) {
 
 	cleanUp(t)
@@ -101,6 +156,7 @@ func TestRegisterUser(t *testing.T) {
 			req.Method = tc.method
 			req.URL = "http://localhost:8080/api/user/register"
 			req.SetBody([]byte(tc.body))
+			req.SetHeader("Content-Type", "application/json")
 
 			resp, err := req.Send()
 			assert.NoError(t, err, "error making HTTP request")
@@ -166,7 +222,7 @@ func TestLoginUserNotExists(t *testing.T) {
 		{method: http.MethodPost, body: wrongBody, expectedCode: http.StatusBadRequest, expectedBody: "Could not parse body\n"},
 		{method: http.MethodPost, body: emptyData1, expectedCode: http.StatusBadRequest, expectedBody: "Login and password cannot be empty\n"},
 		{method: http.MethodPost, body: emptyData2, expectedCode: http.StatusBadRequest, expectedBody: "Login and password cannot be empty\n"},
-		{method: http.MethodPost, body: goodBody, expectedCode: http.StatusUnauthorized, expectedBody: "User not found\n"},
+		{method: http.MethodPost, body: goodBody, expectedCode: http.StatusUnauthorized, expectedBody: "Wrong login or password\n"},
 	}
 
 	for _, tc := range testCases {
@@ -176,6 +232,7 @@ func TestLoginUserNotExists(t *testing.T) {
 			req.Method = tc.method
 			req.URL = "http://localhost:8080/api/user/login"
 			req.SetBody([]byte(tc.body))
+			req.SetHeader("Content-Type", "application/json")
 
 			resp, err := req.Send()
 			assert.NoError(t, err, "error making HTTP request")
@@ -205,7 +262,7 @@ func TestRegisterAndLogin(t *testing.T) {
 		{method: http.MethodPost, body: wrongBody, expectedCode: http.StatusBadRequest, expectedBody: "Could not parse body\n"},
 		{method: http.MethodPost, body: emptyData1, expectedCode: http.StatusBadRequest, expectedBody: "Login and password cannot be empty\n"},
 		{method: http.MethodPost, body: emptyData2, expectedCode: http.StatusBadRequest, expectedBody: "Login and password cannot be empty\n"},
-		{method: http.MethodPost, body: wrongPassword, expectedCode: http.StatusUnauthorized, expectedBody: "Wrong password\n"},
+		{method: http.MethodPost, body: wrongPassword, expectedCode: http.StatusUnauthorized, expectedBody: "Wrong login or password\n"},
 		{method: http.MethodPost, body: goodBody, expectedCode: http.StatusOK, expectedBody: "success"},
 	}
 
@@ -214,6 +271,7 @@ func TestRegisterAndLogin(t *testing.T) {
 	req.Method = http.MethodPost
 	req.URL = "http://localhost:8080/api/user/register"
 	req.SetBody([]byte(goodBody))
+	req.SetHeader("Content-Type", "application/json")
 
 	_, err := req.Send()
 	assert.NoError(t, err, "error making HTTP request")
@@ -225,6 +283,7 @@ func TestRegisterAndLogin(t *testing.T) {
 			req.Method = tc.method
 			req.URL = "http://localhost:8080/api/user/login"
 			req.SetBody([]byte(tc.body))
+			req.SetHeader("Content-Type", "application/json")
 
 			resp, err := req.Send()
 			assert.NoError(t, err, "error making HTTP request")
@@ -258,6 +317,7 @@ func TestNotAuthenticated(t *testing.T) {
 	}{
 		{method: http.MethodPost, path: "http://localhost:8080/api/user/orders"},
 		{method: http.MethodGet, path: "http://localhost:8080/api/user/orders"},
+		{method: http.MethodGet, path: "http://localhost:8080/api/user/orders/stream"},
 		{method: http.MethodGet, path: "http://localhost:8080/api/user/balance"},
 		{method: http.MethodPost, path: "http://localhost:8080/api/user/balance/withdraw"},
 		{method: http.MethodGet, path: "http://localhost:8080/api/user/withdrawals"},
@@ -320,6 +380,56 @@ func TestPostUser(t *testing.T) {
 	}
 }
 
+func TestPostUserOrdersBatch(t *testing.T) {
+
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+	otherUserCookie := getAuthCookie(t, "user2", "passw")
+
+	req := resty.New().R()
+	req.Method = http.MethodPost
+	req.SetCookie(otherUserCookie)
+	req.URL = "http://localhost:8080/api/user/orders"
+	req.SetBody([]byte("79927398713"))
+	req.Send()
+
+	testCases := []struct {
+		name         string
+		contentType  string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "json", contentType: "application/json", body: `["49927398716", "1", "79927398713", "49927398716"]`, expectedCode: http.StatusOK,
+			expectedBody: `[{"number": "49927398716", "result": "accepted"}, {"number": "1", "result": "invalid"},
+				{"number": "79927398713", "result": "conflict"}, {"number": "49927398716", "result": "already_yours"}]`},
+		{name: "lines", contentType: "text/plain", body: "49927398716\n\n0\n", expectedCode: http.StatusOK,
+			expectedBody: `[{"number": "49927398716", "result": "already_yours"}, {"number": "0", "result": "accepted"}]`},
+		{name: "bad json", contentType: "application/json", body: `{"number": 1}`, expectedCode: http.StatusBadRequest},
+		{name: "empty", contentType: "text/plain", body: "", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := resty.New().R()
+			req.Method = http.MethodPost
+			req.SetCookie(cookie)
+			req.SetHeader("Content-Type", tc.contentType)
+			req.URL = "http://localhost:8080/api/user/orders/batch"
+			req.SetBody([]byte(tc.body))
+
+			resp, err := req.Send()
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+			if tc.expectedCode == http.StatusOK {
+				assert.JSONEq(t, tc.expectedBody, string(resp.Body()))
+			}
+		})
+	}
+}
+
 func TestGetUserOrders(t *testing.T) {
 
 	cleanUp(t)
@@ -379,6 +489,180 @@ func TestGetUserOrders(t *testing.T) {
 	}
 }
 
+func TestGetUserOrdersPagination(t *testing.T) {
+
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+
+	for _, num := range []string{"49927398716", "79927398713", "0"} {
+		req := resty.New().R()
+		req.Method = http.MethodPost
+		req.SetCookie(cookie)
+		req.URL = "http://localhost:8080/api/user/orders"
+		req.SetBody([]byte(num))
+		req.Send()
+	}
+
+	getNumbers := func(url string) ([]string, string) {
+		req := resty.New().R()
+		req.Method = http.MethodGet
+		req.SetCookie(cookie)
+		req.SetResult([]types.OrderInfo{})
+		req.URL = url
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+		var numbers []string
+		for _, o := range *resp.Result().(*[]types.OrderInfo) {
+			numbers = append(numbers, o.Number)
+		}
+		return numbers, resp.Header().Get("Link")
+	}
+
+	numbers, link := getNumbers("http://localhost:8080/api/user/orders?limit=2&sort=desc")
+	assert.Equal(t, []string{"0", "79927398713"}, numbers)
+	assert.Contains(t, link, `rel="next"`)
+
+	next := link[1:strings.Index(link, ">")]
+	numbers, link = getNumbers("http://localhost:8080" + next)
+	assert.Equal(t, []string{"49927398716"}, numbers)
+	assert.Empty(t, link)
+
+	numbers, _ = getNumbers("http://localhost:8080/api/user/orders?status=NEW&limit=1")
+	assert.Equal(t, []string{"49927398716"}, numbers)
+
+	req := resty.New().R()
+	req.Method = http.MethodGet
+	req.SetCookie(cookie)
+	req.URL = "http://localhost:8080/api/user/orders?status=PROCESSED"
+	resp, err := req.Send()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode())
+
+	req.URL = "http://localhost:8080/api/user/orders?limit=-1"
+	resp, err = req.Send()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+}
+
+func TestGetUserOrder(t *testing.T) {
+
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+	otherUserCookie := getAuthCookie(t, "user2", "passw")
+
+	req := resty.New().R()
+	req.Method = http.MethodPost
+	req.SetCookie(cookie)
+	req.URL = "http://localhost:8080/api/user/orders"
+	req.SetBody([]byte("49927398716"))
+	req.Send()
+
+	database, err := db.NewDatabase(DBDSN, types.PointsPolicy{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	database.UpdateUnprocessedOrder(context.Background(), 1, types.ProcessedStatus, 100, types.SourcePoll, []byte(`{"order": "49927398716", "status": "PROCESSED", "accrual": 100}`))
+
+	testCases := []struct {
+		number       string
+		cookie       *http.Cookie
+		expectedCode int
+	}{
+		{number: "49927398716", cookie: cookie, expectedCode: http.StatusOK},
+		{number: "49927398716", cookie: otherUserCookie, expectedCode: http.StatusNotFound},
+		{number: "79927398713", cookie: cookie, expectedCode: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.number, func(t *testing.T) {
+			req := resty.New().R()
+			req.Method = http.MethodGet
+			req.SetCookie(tc.cookie)
+			req.SetResult(&types.OrderDetail{})
+			req.URL = "http://localhost:8080/api/user/orders/" + tc.number
+			resp, err := req.Send()
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+			if tc.expectedCode == http.StatusOK {
+				order := resp.Result().(*types.OrderDetail)
+				assert.Equal(t, tc.number, order.Number)
+				assert.Equal(t, types.ProcessedStatus, order.Status)
+				assert.Equal(t, 100.0, *order.Accrual)
+				assert.Len(t, order.History, 2)
+				assert.Equal(t, types.NewStatus, order.History[0].Status)
+				assert.Equal(t, types.ProcessedStatus, order.History[1].Status)
+				assert.Equal(t, types.SourceUpload, order.History[0].Source)
+				assert.Equal(t, types.SourcePoll, order.History[1].Source)
+				assert.Nil(t, order.History[1].Payload)
+			}
+		})
+	}
+}
+
+func TestMerchants(t *testing.T) {
+
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+
+	authData := []byte(`{"login" : "user1", "password" : "passw"}`)
+	req := resty.New().R()
+	req.Method = http.MethodPost
+	req.SetHeader(merchant.Header, "coffee")
+	req.URL = "http://localhost:8080/api/user/register"
+	req.SetBody(authData)
+	req.SetHeader("Content-Type", "application/json")
+	resp, err := req.Send()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode(), "same login is allowed in another merchant")
+	coffeeCookie := resp.Cookies()[0]
+
+	testCases := []struct {
+		name         string
+		merchant     string
+		cookie       *http.Cookie
+		body         string
+		expectedCode int
+	}{
+		{name: "default merchant", merchant: "", cookie: cookie, body: "49927398716", expectedCode: http.StatusAccepted},
+		{name: "other merchant validation", merchant: "coffee", cookie: coffeeCookie, body: "49927398716", expectedCode: http.StatusUnprocessableEntity},
+		{name: "other merchant", merchant: "coffee", cookie: coffeeCookie, body: "12345678978", expectedCode: http.StatusAccepted},
+		{name: "same number in default merchant", merchant: "", cookie: cookie, body: "12345678978", expectedCode: http.StatusAccepted},
+		{name: "token of other merchant", merchant: "coffee", cookie: cookie, body: "12345678978", expectedCode: http.StatusUnauthorized},
+		{name: "unknown merchant", merchant: "tea", cookie: cookie, body: "49927398716", expectedCode: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := resty.New().R()
+			req.Method = http.MethodPost
+			req.SetCookie(tc.cookie)
+			if tc.merchant != "" {
+				req.SetHeader(merchant.Header, tc.merchant)
+			}
+			req.URL = "http://localhost:8080/api/user/orders"
+			req.SetBody([]byte(tc.body))
+
+			resp, err := req.Send()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, resp.StatusCode(), "Response code didn't match expected")
+		})
+	}
+
+	req = resty.New().R()
+	req.Method = http.MethodGet
+	req.SetHeader(merchant.Header, "coffee")
+	req.URL = "http://localhost:8080/api/merchant"
+	resp, err = req.Send()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"code": "coffee", "name": "Coffee", "currency": "beans"}`, string(resp.Body()))
+}
+
 func TestPostUserWithdraw(t *testing.T) {
 	cleanUp(t)
 
@@ -403,6 +687,7 @@ func TestPostUserWithdraw(t *testing.T) {
 			req := resty.New().R()
 			req.Method = http.MethodPost
 			req.SetBody([]byte(fmt.Sprintf(`{"order": "%s", "sum": %f}`, tc.order, tc.wantsToWithdraw)))
+			req.SetHeader("Content-Type", "application/json")
 			req.SetCookie(cookie)
 			req.URL = "http://localhost:8080/api/user/balance/withdraw"
 			resp, err := req.Send()
@@ -438,6 +723,66 @@ func TestPostUserWithdraw(t *testing.T) {
 	}
 }
 
+func TestPostUserTransfer(t *testing.T) {
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+	getAuthCookie(t, "user2", "passw")
+
+	testCases := []struct {
+		name               string
+		currentBalance     float64
+		login              string
+		sum                float64
+		responseStatusCode int
+	}{
+		{"not enough balance", 0, "user2", 10, http.StatusPaymentRequired},
+		{"ok", 10, "user2", 4, http.StatusOK},
+		{"to yourself", 10, "user1", 4, http.StatusBadRequest},
+		{"unknown receiver", 10, "nobody", 4, http.StatusNotFound},
+		{"negative sum", 10, "user2", -4, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.currentBalance > 0 {
+				setBalance(1, tc.currentBalance)
+			}
+			req := resty.New().R()
+			req.Method = http.MethodPost
+			req.SetBody([]byte(fmt.Sprintf(`{"login": "%s", "sum": %f}`, tc.login, tc.sum)))
+			req.SetHeader("Content-Type", "application/json")
+			req.SetCookie(cookie)
+			req.URL = "http://localhost:8080/api/user/balance/transfer"
+			resp, err := req.Send()
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.responseStatusCode, resp.StatusCode(), "Response code didn't match expected")
+		})
+	}
+
+	conn, err := pgx.Connect(context.Background(), DBDSN)
+	assert.NoError(t, err)
+
+	var current float64
+	assert.NoError(t, conn.QueryRow(context.Background(), "SELECT current FROM balance WHERE user_id = 2").Scan(&current))
+	assert.Equal(t, 4.0, current)
+
+	req := resty.New().R()
+	req.Method = http.MethodGet
+	req.SetCookie(cookie)
+	req.URL = "http://localhost:8080/api/user/transfers"
+	resp, err := req.Send()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	var transfers []types.Transfer
+	assert.NoError(t, json.Unmarshal(resp.Body(), &transfers))
+	assert.Len(t, transfers, 1)
+	assert.Equal(t, types.TransferOut, transfers[0].Direction)
+	assert.Equal(t, "user2", transfers[0].Login)
+	assert.Equal(t, 4.0, transfers[0].Sum)
+}
+
 func TestGetUserWithdrawals(t *testing.T) {
 
 	cleanUp(t)
@@ -498,29 +843,29 @@ func TestGetUserBalance(t *testing.T) {
 	cookie := getAuthCookie(t, "user1", "passw")
 
 	ctx := context.Background()
-	database, err := db.NewDatabase(DBDSN)
+	database, err := db.NewDatabase(DBDSN, types.PointsPolicy{})
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	testCases := []struct {
-		addUserBalance int
+		addUserBalance float64
 		newStatus      types.Status
 		expectedBody   string
 	}{
-		{0, "NEW", `{"current": 0, "withdrawn": 0}`},
-		{500, "REGISTERED", `{"current": 500, "withdrawn": 0}`},
-		{1, "PROCESSED", `{"current": 501, "withdrawn": 0}`},
-		{100, "PROCESSED", `{"current": 501, "withdrawn": 0}`},
+		{0, "NEW", `{"current": 0, "pending": 0, "withdrawn": 0, "expiring_soon": 0, "debt": 0}`},
+		{500, "REGISTERED", `{"current": 500, "pending": 0, "withdrawn": 0, "expiring_soon": 0, "debt": 0}`},
+		{1, "PROCESSED", `{"current": 501, "pending": 0, "withdrawn": 0, "expiring_soon": 0, "debt": 0}`},
+		{100, "PROCESSED", `{"current": 501, "pending": 0, "withdrawn": 0, "expiring_soon": 0, "debt": 0}`},
 	}
 
 	for _, tc := range testCases {
 		t.Run("expectedBody", func(t *testing.T) {
 
 			if tc.addUserBalance > 0 {
-				userID, _ := database.GetUserID(ctx, "user1")
+				userID, _ := database.GetUserID(ctx, 1, "user1")
 				database.InsertUserOrder(ctx, "0", userID, "NEW")
-				database.UpdateUnprocessedOrder(ctx, 1, tc.newStatus, tc.addUserBalance)
+				database.UpdateUnprocessedOrder(ctx, 1, tc.newStatus, tc.addUserBalance, types.SourcePoll, nil)
 			}
 
 			req := resty.New().R()
@@ -545,8 +890,10 @@ func cleanUp(t *testing.T) {
 		}
 		conn.Exec(context.Background(), "TRUNCATE TABLE auth_user RESTART IDENTITY CASCADE")
 		conn.Exec(context.Background(), "TRUNCATE TABLE user_order RESTART IDENTITY CASCADE")
+		conn.Exec(context.Background(), "TRUNCATE TABLE order_status_history RESTART IDENTITY CASCADE")
 		conn.Exec(context.Background(), "TRUNCATE TABLE balance RESTART IDENTITY CASCADE")
 		conn.Exec(context.Background(), "TRUNCATE TABLE withdrawal RESTART IDENTITY CASCADE")
+		conn.Exec(context.Background(), "TRUNCATE TABLE login_attempt RESTART IDENTITY CASCADE")
 	})
 
 }
@@ -584,15 +931,957 @@ func getAuthCookie(t *testing.T, login string, password string) *http.Cookie {
 	req.Method = http.MethodPost
 	req.URL = "http://localhost:8080/api/user/register"
 	req.SetBody(authData)
+	req.SetHeader("Content-Type", "application/json")
 	req.Send()
 
 	req = resty.New().R()
 	req.Method = http.MethodPost
 	req.URL = "http://localhost:8080/api/user/login"
 	req.SetBody(authData)
+	req.SetHeader("Content-Type", "application/json")
 
 	resp, _ := req.Send()
 	cookie := resp.Cookies()[0]
 	return cookie
 
 }
+
+func TestAdmin(t *testing.T) {
+	cleanUp(t)
+
+	userCookie := getAuthCookie(t, "user1", "passw")
+	getAuthCookie(t, "admin1", "passw")
+	getAuthCookie(t, "support1", "passw")
+
+	conn, err := pgx.Connect(context.Background(), DBDSN)
+	assert.NoError(t, err)
+	_, err = conn.Exec(context.Background(), "UPDATE auth_user SET role = 'admin' WHERE username = 'admin1'")
+	assert.NoError(t, err)
+	_, err = conn.Exec(context.Background(), "UPDATE auth_user SET role = 'support' WHERE username = 'support1'")
+	assert.NoError(t, err)
+
+	// роль попадает в токен при входе
+	adminCookie := getAuthCookie(t, "admin1", "passw")
+	supportCookie := getAuthCookie(t, "support1", "passw")
+
+	send := func(cookie *http.Cookie, method string, path string, body string) *resty.Response {
+		req := resty.New().R()
+		req.Method = method
+		req.SetCookie(cookie)
+		if body != "" {
+			req.SetBody([]byte(body))
+			req.SetHeader("Content-Type", "application/json")
+		}
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	testCases := []struct {
+		name               string
+		cookie             *http.Cookie
+		method             string
+		path               string
+		body               string
+		responseStatusCode int
+	}{
+		{"not admin", userCookie, http.MethodGet, "/api/admin/users/user1", "", http.StatusForbidden},
+		{"lookup", adminCookie, http.MethodGet, "/api/admin/users/user1", "", http.StatusOK},
+		{"support lookup", supportCookie, http.MethodGet, "/api/admin/users/user1", "", http.StatusOK},
+		{"support adjust", supportCookie, http.MethodPost, "/api/admin/users/user1/balance", `{"sum": 50, "reason": "compensation"}`, http.StatusForbidden},
+		{"lookup unknown", adminCookie, http.MethodGet, "/api/admin/users/nobody", "", http.StatusNotFound},
+		{"adjust without reason", adminCookie, http.MethodPost, "/api/admin/users/user1/balance", `{"sum": 50}`, http.StatusUnprocessableEntity},
+		{"adjust", adminCookie, http.MethodPost, "/api/admin/users/user1/balance", `{"sum": 50, "reason": "compensation"}`, http.StatusOK},
+		{"adjust below zero", adminCookie, http.MethodPost, "/api/admin/users/user1/balance", `{"sum": -100, "reason": "fraud"}`, http.StatusPaymentRequired},
+		{"lock", adminCookie, http.MethodPost, "/api/admin/users/user1/lock", "", http.StatusOK},
+		{"locked user", userCookie, http.MethodGet, "/api/user/balance", "", http.StatusForbidden},
+		{"unlock", adminCookie, http.MethodPost, "/api/admin/users/user1/unlock", "", http.StatusOK},
+		{"unlocked user", userCookie, http.MethodGet, "/api/user/balance", "", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := send(tc.cookie, tc.method, tc.path, tc.body)
+			assert.Equal(t, tc.responseStatusCode, resp.StatusCode(), "Response code didn't match expected")
+		})
+	}
+
+	resp := send(adminCookie, http.MethodGet, "/api/admin/users/user1", "")
+	var summary types.UserSummary
+	assert.NoError(t, json.Unmarshal(resp.Body(), &summary))
+	assert.Equal(t, 50.0, summary.Balance.Current)
+
+	resp = send(adminCookie, http.MethodGet, "/api/admin/users/user1/audit", "")
+	var audit []types.AuditEntry
+	assert.NoError(t, json.Unmarshal(resp.Body(), &audit))
+	// просмотры тоже попадают в журнал
+	assert.Len(t, audit, 6)
+	assert.Equal(t, types.AuditUserView, audit[0].Action)
+	assert.Equal(t, "admin1", audit[0].Admin)
+	assert.Equal(t, types.AuditUserView, audit[1].Action)
+	assert.Equal(t, "support1", audit[1].Admin)
+	assert.Equal(t, types.AuditBalanceAdjust, audit[2].Action)
+	assert.Equal(t, "admin1", audit[2].Admin)
+	assert.Equal(t, types.AuditUserView, audit[5].Action)
+}
+
+func TestDeleteUser(t *testing.T) {
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+	setBalance(1, 10)
+
+	send := func(body string) *resty.Response {
+		req := resty.New().R()
+		req.Method = http.MethodDelete
+		req.SetCookie(cookie)
+		req.SetBody([]byte(body))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080/api/user"
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send(`{"password": "wrong"}`).StatusCode())
+	assert.Equal(t, http.StatusOK, send(`{"password": "passw"}`).StatusCode())
+
+	conn, err := pgx.Connect(context.Background(), DBDSN)
+	assert.NoError(t, err)
+
+	var sessions int
+	assert.NoError(t, conn.QueryRow(context.Background(), "SELECT COUNT(*) FROM user_session WHERE user_id = 1").Scan(&sessions))
+	assert.Equal(t, 0, sessions)
+
+	var attempts int
+	assert.NoError(t, conn.QueryRow(context.Background(), "SELECT COUNT(*) FROM login_attempt WHERE username = 'user1'").Scan(&attempts))
+	assert.Equal(t, 0, attempts)
+
+	// старый токен больше не действует, войти нельзя
+	assert.Equal(t, http.StatusUnauthorized, send(`{"password": "passw"}`).StatusCode())
+
+	req := resty.New().R()
+	req.Method = http.MethodPost
+	req.SetBody([]byte(`{"login": "user1", "password": "passw"}`))
+	req.SetHeader("Content-Type", "application/json")
+	req.URL = "http://localhost:8080/api/user/login"
+	resp, err := req.Send()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+	var username string
+	var current float64
+	row := conn.QueryRow(context.Background(), "SELECT username, current FROM auth_user JOIN balance ON balance.user_id = auth_user.id WHERE auth_user.id = 1")
+	assert.NoError(t, row.Scan(&username, &current))
+	assert.Equal(t, "deleted-1", username)
+	assert.Equal(t, 10.0, current)
+}
+
+func TestLoginLockout(t *testing.T) {
+	cleanUp(t)
+
+	getAuthCookie(t, "bruteforce", "passw")
+
+	login := func(password string) *resty.Response {
+		req := resty.New().R()
+		req.Method = http.MethodPost
+		req.SetBody([]byte(fmt.Sprintf(`{"login": "bruteforce", "password": "%s"}`, password)))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080/api/user/login"
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	for range 3 {
+		assert.Equal(t, http.StatusUnauthorized, login("wrong").StatusCode())
+	}
+
+	// даже верный пароль не принимается, пока действует блокировка
+	resp := login("passw")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+	conn, err := pgx.Connect(context.Background(), DBDSN)
+	assert.NoError(t, err)
+
+	var failed int
+	assert.NoError(t, conn.QueryRow(context.Background(), "SELECT COUNT(*) FROM login_attempt WHERE username = 'bruteforce' AND NOT success").Scan(&failed))
+	assert.Equal(t, 3, failed)
+}
+
+func TestChangeAndResetPassword(t *testing.T) {
+	cleanUp(t)
+
+	oldCookie := getAuthCookie(t, "user1", "passw")
+
+	send := func(cookie *http.Cookie, method string, path string, body string) *resty.Response {
+		req := resty.New().R()
+		req.Method = method
+		if cookie != nil {
+			req.SetCookie(cookie)
+		}
+		req.SetBody([]byte(body))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp := send(oldCookie, http.MethodPost, "/api/user/password", `{"old_password": "wrong", "new_password": "passw2"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+	resp = send(oldCookie, http.MethodPost, "/api/user/password", `{"old_password": "passw", "new_password": "passw2"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	// токены, выданные до смены пароля, отозваны
+	assert.Equal(t, http.StatusUnauthorized, send(oldCookie, http.MethodGet, "/api/user/balance", "").StatusCode())
+	var newCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "_user" {
+			newCookie = c
+		}
+	}
+	assert.NotNil(t, newCookie)
+	assert.Equal(t, http.StatusOK, send(newCookie, http.MethodGet, "/api/user/balance", "").StatusCode())
+
+	assert.Equal(t, http.StatusAccepted, send(nil, http.MethodPost, "/api/user/password/reset", `{"login": "nobody"}`).StatusCode())
+	assert.Equal(t, http.StatusAccepted, send(nil, http.MethodPost, "/api/user/password/reset", `{"login": "user1"}`).StatusCode())
+
+	data, err := os.ReadFile(notificationsFile)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var msg notify.Message
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &msg))
+	assert.Equal(t, "user1", msg.To)
+	token := strings.TrimPrefix(strings.Split(msg.Body, "\n")[0], "Your password reset token: ")
+
+	resp = send(nil, http.MethodPost, "/api/user/password/reset/confirm", `{"token": "bad", "password": "passw3"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	// пароль не может совпадать с логином владельца токена
+	resp = send(nil, http.MethodPost, "/api/user/password/reset/confirm", fmt.Sprintf(`{"token": "%s", "password": "USER1"}`, token))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	resp = send(nil, http.MethodPost, "/api/user/password/reset/confirm", fmt.Sprintf(`{"token": "%s", "password": "passw3"}`, token))
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	// токен одноразовый
+	resp = send(nil, http.MethodPost, "/api/user/password/reset/confirm", fmt.Sprintf(`{"token": "%s", "password": "passw4"}`, token))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	assert.Equal(t, http.StatusUnauthorized, send(newCookie, http.MethodGet, "/api/user/balance", "").StatusCode())
+	assert.Equal(t, http.StatusOK, send(nil, http.MethodPost, "/api/user/login", `{"login": "user1", "password": "passw3"}`).StatusCode())
+}
+
+func TestPasswordCheckThrottle(t *testing.T) {
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+
+	send := func(cookie *http.Cookie, method string, path string, body string) *resty.Response {
+		req := resty.New().R()
+		req.Method = method
+		if cookie != nil {
+			req.SetCookie(cookie)
+		}
+		req.SetBody([]byte(body))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send(cookie, http.MethodPost, "/api/user/password", `{"old_password": "wrong", "new_password": "passw2"}`).StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, send(cookie, http.MethodDelete, "/api/user", `{"password": "wrong"}`).StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, send(cookie, http.MethodPost, "/api/user/password", `{"old_password": "wrong", "new_password": "passw2"}`).StatusCode())
+
+	// неверные пароли при смене пароля и удалении учитываются вместе с неудачными входами
+	resp := send(cookie, http.MethodPost, "/api/user/password", `{"old_password": "passw", "new_password": "passw2"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTooManyRequests, send(cookie, http.MethodDelete, "/api/user", `{"password": "passw"}`).StatusCode())
+	assert.Equal(t, http.StatusTooManyRequests, send(nil, http.MethodPost, "/api/user/login", `{"login": "user1", "password": "passw"}`).StatusCode())
+
+	getAuthCookie(t, "user2", "passw")
+	sent := func() int {
+		// до первого письма файла может не быть
+		data, _ := os.ReadFile(notificationsFile)
+		count := 0
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var msg notify.Message
+			if json.Unmarshal([]byte(line), &msg) == nil && msg.To == "user2" {
+				count++
+			}
+		}
+		return count
+	}
+	before := sent()
+	for range 5 {
+		assert.Equal(t, http.StatusAccepted, send(nil, http.MethodPost, "/api/user/password/reset", `{"login": "user2"}`).StatusCode())
+	}
+	assert.Equal(t, before+3, sent())
+}
+
+func TestLoginNormalisation(t *testing.T) {
+	cleanUp(t)
+
+	send := func(path string, login string) *resty.Response {
+		req := resty.New().R()
+		req.Method = http.MethodPost
+		req.SetBody([]byte(fmt.Sprintf(`{"login": "%s", "password": "passw"}`, login)))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, send("/api/user/register", "  Carol ").StatusCode())
+	assert.Equal(t, http.StatusConflict, send("/api/user/register", "CAROL").StatusCode())
+	assert.Equal(t, http.StatusConflict, send("/api/user/register", "ｃａｒｏｌ").StatusCode())
+	assert.Equal(t, http.StatusOK, send("/api/user/login", "cArOl").StatusCode())
+
+	assert.Equal(t, http.StatusBadRequest, send("/api/user/register", "ab").StatusCode())
+	assert.Equal(t, http.StatusBadRequest, send("/api/user/register", "carol smith").StatusCode())
+	assert.Equal(t, http.StatusBadRequest, send("/api/user/login", strings.Repeat("a", 1000)).StatusCode())
+}
+
+func TestTwoFactor(t *testing.T) {
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+
+	send := func(cookie *http.Cookie, path string, body string, headers map[string]string) *resty.Response {
+		req := resty.New().R()
+		req.Method = http.MethodPost
+		if cookie != nil {
+			req.SetCookie(cookie)
+		}
+		req.SetHeaders(headers)
+		req.SetBody([]byte(body))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp := send(cookie, "/api/user/2fa", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var enrollment struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body(), &enrollment))
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/"))
+
+	now := time.Now()
+	code, err := auth.TOTPCode(enrollment.Secret, now)
+	assert.NoError(t, err)
+	// следующий интервал тоже принимается, а один код дважды - нет
+	nextCode, err := auth.TOTPCode(enrollment.Secret, now.Add(30*time.Second))
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusBadRequest, send(cookie, "/api/user/2fa/confirm", `{"code": "000000x"}`, nil).StatusCode())
+	resp = send(cookie, "/api/user/2fa/confirm", fmt.Sprintf(`{"code": "%s"}`, code), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var recovery struct {
+		Codes []string `json:"recovery_codes"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body(), &recovery))
+	assert.Len(t, recovery.Codes, 10)
+	assert.Equal(t, http.StatusConflict, send(cookie, "/api/user/2fa", "", nil).StatusCode())
+
+	// после пароля куки нет, только промежуточный токен
+	resp = send(nil, "/api/user/login", `{"login": "user1", "password": "passw"}`, nil)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode())
+	assert.Empty(t, resp.Cookies())
+	var pending struct {
+		Token string `json:"token"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body(), &pending))
+
+	assert.Equal(t, http.StatusUnauthorized, send(nil, "/api/user/login/2fa", fmt.Sprintf(`{"token": "%s", "code": "%s"}`, pending.Token, code), nil).StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, send(nil, "/api/user/login/2fa", fmt.Sprintf(`{"token": "bad", "code": "%s"}`, nextCode), nil).StatusCode())
+	resp = send(nil, "/api/user/login/2fa", fmt.Sprintf(`{"token": "%s", "code": "%s"}`, pending.Token, nextCode), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.NotEmpty(t, resp.Cookies())
+
+	// списания и переводы больше порога за сутки - только с кодом
+	setBalance(1, 500)
+	getAuthCookie(t, "user2", "passw")
+	small := `{"order": "2377225624", "sum": 60}`
+	assert.Equal(t, http.StatusOK, send(cookie, "/api/user/balance/withdraw", small, nil).StatusCode())
+	assert.Equal(t, http.StatusForbidden, send(cookie, "/api/user/balance/withdraw", small, nil).StatusCode())
+
+	withdraw := `{"order": "2377225624", "sum": 200}`
+	assert.Equal(t, http.StatusForbidden, send(cookie, "/api/user/balance/withdraw", withdraw, nil).StatusCode())
+	assert.Equal(t, http.StatusOK, send(cookie, "/api/user/balance/withdraw", withdraw, map[string]string{"X-OTP-Code": recovery.Codes[0]}).StatusCode())
+	assert.Equal(t, http.StatusForbidden, send(cookie, "/api/user/balance/withdraw", withdraw, map[string]string{"X-OTP-Code": recovery.Codes[0]}).StatusCode())
+
+	transfer := `{"login": "user2", "sum": 10}`
+	assert.Equal(t, http.StatusForbidden, send(cookie, "/api/user/balance/transfer", transfer, nil).StatusCode())
+	assert.Equal(t, http.StatusOK, send(cookie, "/api/user/balance/transfer", transfer, map[string]string{"X-OTP-Code": recovery.Codes[2]}).StatusCode())
+
+	req := resty.New().R()
+	req.Method = http.MethodDelete
+	req.SetCookie(cookie)
+	req.SetBody([]byte(fmt.Sprintf(`{"password": "passw", "code": "%s"}`, recovery.Codes[1])))
+	req.SetHeader("Content-Type", "application/json")
+	req.URL = "http://localhost:8080/api/user/2fa"
+	resp, err = req.Send()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	assert.Equal(t, http.StatusOK, send(nil, "/api/user/login", `{"login": "user1", "password": "passw"}`, nil).StatusCode())
+}
+
+func TestTwoFactorThrottle(t *testing.T) {
+	cleanUp(t)
+
+	send := func(cookie *http.Cookie, method string, path string, body string, headers map[string]string) *resty.Response {
+		req := resty.New().R()
+		req.Method = method
+		req.SetCookie(cookie)
+		req.SetHeaders(headers)
+		req.SetBody([]byte(body))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+	enable := func(cookie *http.Cookie) string {
+		resp := send(cookie, http.MethodPost, "/api/user/2fa", "", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode())
+		var enrollment struct {
+			Secret string `json:"secret"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body(), &enrollment))
+		code, err := auth.TOTPCode(enrollment.Secret, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, send(cookie, http.MethodPost, "/api/user/2fa/confirm", fmt.Sprintf(`{"code": "%s"}`, code), nil).StatusCode())
+		return enrollment.Secret
+	}
+
+	t.Run("withdraw code", func(t *testing.T) {
+		cookie := getAuthCookie(t, "user1", "passw")
+		secret := enable(cookie)
+		withdraw := `{"order": "2377225624", "sum": 200}`
+
+		for range 3 {
+			assert.Equal(t, http.StatusForbidden, send(cookie, http.MethodPost, "/api/user/balance/withdraw", withdraw, map[string]string{"X-OTP-Code": "123"}).StatusCode())
+		}
+		// верный код тоже не принимается, пока действует блокировка
+		code, err := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+		assert.NoError(t, err)
+		resp := send(cookie, http.MethodPost, "/api/user/balance/withdraw", withdraw, map[string]string{"X-OTP-Code": code})
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	})
+
+	t.Run("disable code", func(t *testing.T) {
+		cookie := getAuthCookie(t, "user2", "passw")
+		secret := enable(cookie)
+
+		for range 3 {
+			assert.Equal(t, http.StatusUnauthorized, send(cookie, http.MethodDelete, "/api/user/2fa", `{"password": "passw", "code": "123"}`, nil).StatusCode())
+		}
+		code, err := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+		assert.NoError(t, err)
+		resp := send(cookie, http.MethodDelete, "/api/user/2fa", fmt.Sprintf(`{"password": "passw", "code": "%s"}`, code), nil)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+	})
+}
+
+func TestSessions(t *testing.T) {
+	cleanUp(t)
+
+	getAuthCookie(t, "user1", "passw")
+
+	login := func(userAgent string) *http.Cookie {
+		req := resty.New().R()
+		req.Method = http.MethodPost
+		req.SetHeader("User-Agent", userAgent)
+		req.SetBody([]byte(`{"login": "user1", "password": "passw"}`))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080/api/user/login"
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp.Cookies()[0]
+	}
+	send := func(cookie *http.Cookie, method string, path string) *resty.Response {
+		req := resty.New().R()
+		req.Method = method
+		req.SetCookie(cookie)
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	phone := login("Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)")
+	laptop := login("Mozilla/5.0 (Windows NT 10.0; Win64; x64)")
+	tablet := login("Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)")
+
+	resp := send(laptop, http.MethodGet, "/api/user/sessions")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var sessions []types.Session
+	assert.NoError(t, json.Unmarshal(resp.Body(), &sessions))
+	// первая сессия - от регистрации
+	assert.Len(t, sessions, 5)
+	devices := map[string]bool{}
+	var phoneID int
+	for _, s := range sessions {
+		devices[s.Device] = s.Current
+		if s.Device == "iPhone" {
+			phoneID = s.ID
+		}
+	}
+	assert.Equal(t, map[string]bool{"iPhone": false, "Windows": true, "iPad": false, "Unknown": false}, devices)
+
+	assert.Equal(t, http.StatusOK, send(laptop, http.MethodDelete, fmt.Sprintf("/api/user/sessions/%d", phoneID)).StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, send(phone, http.MethodGet, "/api/user/balance").StatusCode())
+	assert.Equal(t, http.StatusNotFound, send(laptop, http.MethodDelete, fmt.Sprintf("/api/user/sessions/%d", phoneID)).StatusCode())
+
+	assert.Equal(t, http.StatusOK, send(laptop, http.MethodDelete, "/api/user/sessions").StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, send(tablet, http.MethodGet, "/api/user/balance").StatusCode())
+	assert.Equal(t, http.StatusOK, send(laptop, http.MethodGet, "/api/user/balance").StatusCode())
+
+	resp = send(laptop, http.MethodGet, "/api/user/sessions")
+	sessions = nil
+	assert.NoError(t, json.Unmarshal(resp.Body(), &sessions))
+	assert.Len(t, sessions, 1)
+
+	resp = send(laptop, http.MethodDelete, fmt.Sprintf("/api/user/sessions/%d", sessions[0].ID))
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, send(laptop, http.MethodGet, "/api/user/balance").StatusCode())
+}
+
+func TestCrossSiteRequests(t *testing.T) {
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, "/", cookie.Path)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	withdraw := func(origin string) int {
+		req := resty.New().R()
+		req.Method = http.MethodPost
+		req.SetCookie(cookie)
+		if origin != "" {
+			req.SetHeader("Origin", origin)
+		}
+		req.SetBody([]byte(`{"order": "2377225624", "sum": 10}`))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8080/api/user/balance/withdraw"
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp.StatusCode()
+	}
+
+	assert.Equal(t, http.StatusForbidden, withdraw("https://evil.com"))
+	assert.Equal(t, http.StatusPaymentRequired, withdraw("http://localhost:8080"))
+	assert.Equal(t, http.StatusPaymentRequired, withdraw(""))
+}
+
+func TestAPIKeys(t *testing.T) {
+	cleanUp(t)
+
+	userCookie := getAuthCookie(t, "user1", "passw")
+	getAuthCookie(t, "admin1", "passw")
+
+	conn, err := pgx.Connect(context.Background(), DBDSN)
+	assert.NoError(t, err)
+	_, err = conn.Exec(context.Background(), "UPDATE auth_user SET role = 'admin' WHERE username = 'admin1'")
+	assert.NoError(t, err)
+	adminCookie := getAuthCookie(t, "admin1", "passw")
+
+	send := func(cookie *http.Cookie, method string, path string, body string) *resty.Response {
+		req := resty.New().R()
+		req.Method = method
+		req.SetCookie(cookie)
+		if body != "" {
+			req.SetBody([]byte(body))
+			req.SetHeader("Content-Type", "application/json")
+		}
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+	sendWithKey := func(key string, onBehalfOf string, method string, path string, body string) *resty.Response {
+		req := resty.New().R()
+		req.Method = method
+		req.SetHeader("X-API-Key", key)
+		if onBehalfOf != "" {
+			req.SetHeader(auth.OnBehalfOfHeader, onBehalfOf)
+		}
+		if body != "" {
+			req.SetBody([]byte(body))
+		}
+		req.URL = "http://localhost:8080" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+	var created struct {
+		ID  int    `json:"id"`
+		Key string `json:"key"`
+	}
+
+	resp := send(userCookie, http.MethodPost, "/api/user/keys", `{"name": "shop", "scopes": ["account:read"], "rate_limit": 100, "password": "passw"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	resp = send(userCookie, http.MethodPost, "/api/user/keys", `{"name": "shop", "scopes": ["users:read"], "password": "passw"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	// ключ выдаётся только после проверки пароля
+	resp = send(userCookie, http.MethodPost, "/api/user/keys", `{"name": "shop", "scopes": ["account:read"]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	resp = send(userCookie, http.MethodPost, "/api/user/keys", `{"name": "shop", "scopes": ["account:read"], "password": "wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode())
+
+	resp = send(userCookie, http.MethodPost, "/api/user/keys", `{"name": "shop", "scopes": ["account:read"], "password": "passw"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.NoError(t, json.Unmarshal(resp.Body(), &created))
+	userKey, userKeyID := created.Key, created.ID
+
+	assert.Equal(t, http.StatusOK, sendWithKey(userKey, "", http.MethodGet, "/api/user/balance", "").StatusCode())
+	assert.Equal(t, http.StatusForbidden, sendWithKey(userKey, "", http.MethodPost, "/api/user/orders", "49927398716").StatusCode())
+	// управление учётной записью по ключу недоступно
+	assert.Equal(t, http.StatusUnauthorized, sendWithKey(userKey, "", http.MethodGet, "/api/user/keys", "").StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, sendWithKey(userKey[:len(userKey)-1]+"x", "", http.MethodGet, "/api/user/balance", "").StatusCode())
+
+	resp = send(userCookie, http.MethodGet, "/api/user/keys", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	var keys []types.APIKey
+	assert.NoError(t, json.Unmarshal(resp.Body(), &keys))
+	assert.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].LastUsedAt)
+	assert.NotContains(t, resp.String(), userKey)
+
+	// лимит ключа - apiKeyRateLimit запросов в минуту, два уже потрачены
+	for range apiKeyRateLimit - 2 {
+		assert.Equal(t, http.StatusOK, sendWithKey(userKey, "", http.MethodGet, "/api/user/balance", "").StatusCode())
+	}
+	resp = sendWithKey(userKey, "", http.MethodGet, "/api/user/balance", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode())
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, send(userCookie, http.MethodDelete, fmt.Sprintf("/api/user/keys/%d", userKeyID), "").StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, sendWithKey(userKey, "", http.MethodGet, "/api/user/balance", "").StatusCode())
+
+	// завершение других сессий отзывает и ключи: их мог выпустить захвативший сессию
+	resp = send(userCookie, http.MethodPost, "/api/user/keys", `{"name": "shop", "scopes": ["account:read"], "password": "passw"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.NoError(t, json.Unmarshal(resp.Body(), &created))
+	assert.Equal(t, http.StatusOK, send(userCookie, http.MethodDelete, "/api/user/sessions", "").StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, sendWithKey(created.Key, "", http.MethodGet, "/api/user/balance", "").StatusCode())
+
+	assert.Equal(t, http.StatusForbidden, send(userCookie, http.MethodPost, "/api/admin/keys", `{"name": "shop", "scopes": ["orders:write"]}`).StatusCode())
+	resp = send(adminCookie, http.MethodPost, "/api/admin/keys", `{"name": "shop", "scopes": ["account:write"]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	resp = send(adminCookie, http.MethodPost, "/api/admin/keys", `{"name": "shop", "scopes": ["account:read", "orders:write"], "rate_limit": 100}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode())
+	assert.NoError(t, json.Unmarshal(resp.Body(), &created))
+
+	assert.Equal(t, http.StatusBadRequest, sendWithKey(created.Key, "", http.MethodPost, "/api/user/orders", "49927398716").StatusCode())
+	assert.Equal(t, http.StatusNotFound, sendWithKey(created.Key, "nobody", http.MethodPost, "/api/user/orders", "49927398716").StatusCode())
+	assert.Equal(t, http.StatusAccepted, sendWithKey(created.Key, "User1", http.MethodPost, "/api/user/orders", "49927398716").StatusCode())
+	// списывать и переводить баллы ключом магазина нельзя
+	assert.Equal(t, http.StatusUnauthorized, sendWithKey(created.Key, "User1", http.MethodPost, "/api/user/balance/withdraw", `{"order": "2377225624", "sum": 1}`).StatusCode())
+	assert.Equal(t, http.StatusUnauthorized, sendWithKey(created.Key, "User1", http.MethodPost, "/api/user/balance/transfer", `{"to": "user2", "sum": 1}`).StatusCode())
+
+	resp = send(userCookie, http.MethodGet, "/api/user/orders", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Contains(t, resp.String(), "49927398716")
+}
+
+func TestPostgresRateLimit(t *testing.T) {
+
+	database, err := db.NewDatabase(DBDSN, types.PointsPolicy{})
+	assert.NoError(t, err)
+	limiter := ratelimit.NewLimiter(ratelimit.NewPGStore(database))
+	rule := ratelimit.Rule{Name: "test", Limit: 2, Window: time.Minute}
+	ctx := context.Background()
+
+	assert.Zero(t, limiter.Allow(ctx, rule, "ip:10.0.0.1"))
+	assert.Zero(t, limiter.Allow(ctx, rule, "ip:10.0.0.1"))
+	wait := limiter.Allow(ctx, rule, "ip:10.0.0.1")
+	assert.Greater(t, wait, time.Duration(0))
+	assert.LessOrEqual(t, wait, time.Minute)
+	assert.Zero(t, limiter.Allow(ctx, rule, "ip:10.0.0.2"))
+
+	// удалённый счётчик начинает новое окно
+	assert.NoError(t, database.DeleteRateLimitsBefore(ctx, time.Now().Add(time.Hour)))
+	assert.Zero(t, limiter.Allow(ctx, rule, "ip:10.0.0.1"))
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	cleanUp(t)
+
+	cookie := getAuthCookie(t, "user1", "passw")
+
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		assert.NoError(t, err)
+		assert.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	testCases := []struct {
+		name         string
+		path         string
+		contentType  string
+		encoding     string
+		body         []byte
+		expectedCode int
+	}{
+		{name: "login too large", path: "/api/user/login", contentType: "application/json",
+			body: []byte(fmt.Sprintf(`{"login": "user1", "password": "%s"}`, strings.Repeat("a", 20<<10))), expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "login as text", path: "/api/user/login", contentType: "text/plain",
+			body: []byte(`{"login": "user1", "password": "passw"}`), expectedCode: http.StatusUnsupportedMediaType},
+		{name: "login malformed content type", path: "/api/user/login", contentType: "application/json; =",
+			body: []byte(`{"login": "user1", "password": "passw"}`), expectedCode: http.StatusBadRequest},
+		{name: "order as json", path: "/api/user/orders", contentType: "application/json",
+			body: []byte(`"49927398716"`), expectedCode: http.StatusUnsupportedMediaType},
+		{name: "order too large", path: "/api/user/orders", contentType: "text/plain",
+			body: []byte(strings.Repeat("4", 1000)), expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "gzip order", path: "/api/user/orders", contentType: "text/plain", encoding: "gzip",
+			body: gzipped([]byte("49927398716")), expectedCode: http.StatusAccepted},
+		{name: "gzip bomb", path: "/api/user/orders/batch", contentType: "text/plain", encoding: "gzip",
+			body: gzipped(bytes.Repeat([]byte("49927398716\n"), 1<<20)), expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "gzip bomb to json route", path: "/api/user/balance/withdraw", contentType: "application/json", encoding: "gzip",
+			body: gzipped(make([]byte, 16<<20)), expectedCode: http.StatusRequestEntityTooLarge},
+		{name: "broken gzip", path: "/api/user/orders", contentType: "text/plain", encoding: "gzip",
+			body: []byte("49927398716"), expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := resty.New().R()
+			req.Method = http.MethodPost
+			req.SetCookie(cookie)
+			req.SetHeader("Content-Type", tc.contentType)
+			if tc.encoding != "" {
+				req.SetHeader("Content-Encoding", tc.encoding)
+			}
+			req.SetBody(tc.body)
+			req.URL = "http://localhost:8080" + tc.path
+			resp, err := req.Send()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, resp.StatusCode())
+		})
+	}
+}
+
+func TestOIDCLoginTwoFactor(t *testing.T) {
+	cleanUp(t)
+
+	ctx := context.Background()
+
+	// провайдер-заглушка подписывает ID token с nonce из последнего входа и auth_time, если он задан
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	var nonce string
+	var authTime int64
+	var idp *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "key-1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims := jwt.MapClaims{
+			"iss":                idp.URL,
+			"sub":                "subject-1",
+			"aud":                "gophermart",
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"nonce":              nonce,
+			"preferred_username": "alice",
+		}
+		if authTime != 0 {
+			claims["auth_time"] = authTime
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key-1"
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+	idp = httptest.NewServer(mux)
+	defer idp.Close()
+
+	// отдельный сервер с включённым входом через провайдера
+	database, err := db.NewDatabase(DBDSN, types.PointsPolicy{})
+	assert.NoError(t, err)
+	provider, err := oidc.NewProvider(ctx, oidc.Config{Issuer: idp.URL, ClientID: "gophermart", RedirectURL: "http://localhost:8081/api/user/oidc/callback"}, idp.Client())
+	assert.NoError(t, err)
+	handlerSet, err := newHandlerSet(database, provider)
+	assert.NoError(t, err)
+	merchants, err := merchant.NewRegistry(ctx, database, "", "luhn")
+	assert.NoError(t, err)
+	conf := config.ServerConfig{Secret: []byte("secret"), RunAddress: "localhost:8081", DatabaseDSN: DBDSN, OIDCIssuer: idp.URL}
+	r := NewRouter(&conf, handlerSet, merchants, database, database, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), compress.RequestUngzipper{})
+	go r.ListenAndServe()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	var resp *http.Response
+	for range 50 {
+		if resp, err = client.Get("http://localhost:8081/api/merchant"); err == nil {
+			resp.Body.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.NoError(t, err)
+
+	callback := func(authURL string, cookies []*http.Cookie) *http.Response {
+		location, err := url.Parse(authURL)
+		assert.NoError(t, err)
+		nonce = location.Query().Get("nonce")
+
+		callback := "http://localhost:8081/api/user/oidc/callback?" + url.Values{"state": {location.Query().Get("state")}, "code": {"code"}}.Encode()
+		req, err := http.NewRequest(http.MethodGet, callback, nil)
+		assert.NoError(t, err)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		return resp
+	}
+	oidcLogin := func() *http.Response {
+		resp, err := client.Get("http://localhost:8081/api/user/oidc/login")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+		return callback(resp.Header.Get("Location"), resp.Cookies())
+	}
+	authCookie := func(resp *http.Response) *http.Cookie {
+		for _, c := range resp.Cookies() {
+			if c.Name == "_user" && c.Value != "" {
+				return c
+			}
+		}
+		return nil
+	}
+	send := func(cookie *http.Cookie, path string, body string) *resty.Response {
+		req := resty.New().R()
+		req.Method = http.MethodPost
+		if cookie != nil {
+			req.SetCookie(cookie)
+		}
+		req.SetBody([]byte(body))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8081" + path
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp
+	}
+
+	resp = oidcLogin()
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	cookie := authCookie(resp)
+	assert.NotNil(t, cookie)
+
+	enrollment := send(cookie, "/api/user/2fa", "")
+	assert.Equal(t, http.StatusOK, enrollment.StatusCode())
+	var secret struct {
+		Secret string `json:"secret"`
+	}
+	assert.NoError(t, json.Unmarshal(enrollment.Body(), &secret))
+	now := time.Now()
+	code, err := auth.TOTPCode(secret.Secret, now)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, send(cookie, "/api/user/2fa/confirm", fmt.Sprintf(`{"code": "%s"}`, code)).StatusCode())
+
+	// с включённым вторым фактором провайдер даёт только промежуточный токен
+	resp = oidcLogin()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Nil(t, authCookie(resp))
+	var pending struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		Token             string `json:"token"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&pending))
+	assert.True(t, pending.TwoFactorRequired)
+
+	nextCode, err := auth.TOTPCode(secret.Secret, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	login := send(nil, "/api/user/login/2fa", fmt.Sprintf(`{"token": "%s", "code": "%s"}`, pending.Token, nextCode))
+	assert.Equal(t, http.StatusOK, login.StatusCode())
+	assert.NotEmpty(t, login.Cookies())
+
+	// вход через провайдера засчитывается, как вход по паролю
+	conn, err := pgx.Connect(ctx, DBDSN)
+	assert.NoError(t, err)
+	var succeeded int
+	assert.NoError(t, conn.QueryRow(ctx, "SELECT COUNT(*) FROM login_attempt WHERE username = 'alice' AND success").Scan(&succeeded))
+	assert.Equal(t, 2, succeeded)
+
+	// у пользователя без пароля удаление учётной записи подтверждает свежий вход у провайдера
+	for _, c := range login.Cookies() {
+		if c.Name == "_user" {
+			cookie = c
+		}
+	}
+	reauth := func() *http.Response {
+		start := send(cookie, "/api/user/oidc/reauth", "")
+		assert.Equal(t, http.StatusOK, start.StatusCode())
+		var flow struct {
+			URL string `json:"url"`
+		}
+		assert.NoError(t, json.Unmarshal(start.Body(), &flow))
+		location, err := url.Parse(flow.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, "login", location.Query().Get("prompt"))
+		return callback(flow.URL, start.Cookies())
+	}
+	deleteUser := func(body string) int {
+		req := resty.New().R()
+		req.Method = http.MethodDelete
+		req.SetCookie(cookie)
+		req.SetBody([]byte(body))
+		req.SetHeader("Content-Type", "application/json")
+		req.URL = "http://localhost:8081/api/user"
+		resp, err := req.Send()
+		assert.NoError(t, err)
+		return resp.StatusCode()
+	}
+
+	// провайдер не сообщил, когда пользователь входил
+	resp = reauth()
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	authTime = time.Now().Unix()
+	resp = reauth()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var confirmation struct {
+		ReauthToken string `json:"reauth_token"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&confirmation))
+	assert.NotEmpty(t, confirmation.ReauthToken)
+
+	assert.Equal(t, http.StatusBadRequest, deleteUser(`{}`))
+	assert.Equal(t, http.StatusUnauthorized, deleteUser(`{"reauth_token": "forged"}`))
+	assert.Equal(t, http.StatusOK, deleteUser(fmt.Sprintf(`{"reauth_token": "%s"}`, confirmation.ReauthToken)))
+}
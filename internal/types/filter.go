@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+// ListFilter описывает страницу выборки заказов или списаний пользователя
+type ListFilter struct {
+	// Cursor - id последней записи предыдущей страницы, 0 для первой страницы
+	Cursor   int
+	Limit    int
+	Statuses []Status
+	From     *time.Time
+	To       *time.Time
+	Sort     SortOrder
+}
@@ -0,0 +1,13 @@
+package types
+
+type Merchant struct {
+	ID   int    `db:"id" json:"-"`
+	Code string `db:"code" json:"code"`
+	Name string `db:"name" json:"name"`
+	// Host - домен, по которому приходят запросы покупателей магазина
+	Host *string `db:"host" json:"-"`
+	// AccrualAddress и OrderValidation не заданы - используются значения из конфигурации
+	AccrualAddress  *string `db:"accrual_address" json:"-"`
+	OrderValidation *string `db:"order_validation" json:"-"`
+	Currency        string  `db:"currency" json:"currency"`
+}
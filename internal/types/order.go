@@ -1,6 +1,9 @@
 package types
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Status string
 
@@ -12,25 +15,98 @@ const (
 	RegisteredStatus Status = "REGISTERED"
 )
 
+// StatusSource - откуда пришла смена статуса заказа
+type StatusSource string
+
+const (
+	SourceUpload StatusSource = "upload"
+	SourcePoll   StatusSource = "poll"
+	SourceAdmin  StatusSource = "admin"
+	// SourceRecheck - повторная проверка уже начисленного заказа
+	SourceRecheck StatusSource = "recheck"
+)
+
 type OrderRecord struct {
-	OrderNum string `db:"order_number"`
-	Status   Status `db:"status"`
-	OrderID  int    `db:"id"`
+	OrderNum   string  `db:"order_number"`
+	Status     Status  `db:"status"`
+	OrderID    int     `db:"id"`
+	UserID     int     `db:"user_id"`
+	MerchantID int     `db:"merchant_id"`
+	Accrual    float64 `db:"accrual"`
 }
 
 type OrderInfo struct {
+	ID         int       `db:"id" json:"-"`
 	Number     string    `db:"order_number" json:"number"`
 	Status     Status    `db:"status" json:"status"`
 	Accrual    *float64  `db:"accrual" json:"accrual"`
 	UploadedAt time.Time `db:"uploaded_at" json:"uploaded_at"`
 }
 
+type StatusChange struct {
+	Status    Status          `db:"status" json:"status"`
+	Source    StatusSource    `db:"source" json:"source"`
+	Payload   json.RawMessage `db:"payload" json:"-"`
+	ChangedAt time.Time       `db:"created_at" json:"changed_at"`
+}
+
+type OrderDetail struct {
+	OrderInfo
+	CheckedAt *time.Time     `db:"checked_at" json:"checked_at"`
+	History   []StatusChange `db:"-" json:"history"`
+}
+
+type UploadResult string
+
+const (
+	UploadAccepted     UploadResult = "accepted"
+	UploadAlreadyYours UploadResult = "already_yours"
+	UploadConflict     UploadResult = "conflict"
+	UploadInvalid      UploadResult = "invalid"
+)
+
+type OrderUploadResult struct {
+	Number string       `json:"number"`
+	Result UploadResult `json:"result"`
+}
+
+// OrderEvent отправляется подписчикам при смене статуса заказа
+type OrderEvent struct {
+	UserID  int     `json:"-"`
+	Number  string  `json:"number"`
+	Status  Status  `json:"status"`
+	Accrual float64 `json:"accrual"`
+}
+
 type Balance struct {
-	Current   float64 `db:"current" json:"current"`
-	Withdrawn float64 `db:"withdrawn" json:"withdrawn"`
+	// Current - доступные для списания баллы
+	Current float64 `db:"current" json:"current"`
+	// Pending - начисленные баллы, ожидающие окончания холда
+	Pending      float64 `db:"pending" json:"pending"`
+	Withdrawn    float64 `db:"withdrawn" json:"withdrawn"`
+	ExpiringSoon float64 `db:"expiring_soon" json:"expiring_soon"`
+	// Debt - отменённые начисления, которые не удалось списать с баланса
+	Debt float64 `db:"debt" json:"debt"`
+}
+
+type TransferDirection string
+
+const (
+	TransferIn  TransferDirection = "in"
+	TransferOut TransferDirection = "out"
+)
+
+// Transfer - перевод баллов с точки зрения пользователя: Login - второй участник перевода
+type Transfer struct {
+	ID        int               `db:"id" json:"-"`
+	Direction TransferDirection `db:"direction" json:"direction"`
+	Login     string            `db:"login" json:"login"`
+	Sum       float64           `db:"sum" json:"sum"`
+	CreatedAt time.Time         `db:"created_at" json:"processed_at"`
 }
 
 type Withdrawal struct {
+	ID          int       `db:"id" json:"-"`
 	Order       string    `db:"order_name" json:"order"`
 	Sum         float64   `db:"sum" json:"sum"`
 	ProcessedAt time.Time `db:"processed_at" json:"processed_at"`
@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// PointsPolicy - правила жизни начисленных баллов
+type PointsPolicy struct {
+	// ExpireMonths - через сколько месяцев после начисления сгорают баллы, 0 - не сгорают
+	ExpireMonths int
+	// ExpiryNotice - за какой срок до сгорания баллы попадают в expiring_soon баланса
+	ExpiryNotice time.Duration
+	// HoldPeriod - сколько начисленные баллы остаются в ожидании, прежде чем их можно потратить
+	HoldPeriod time.Duration
+	// TransferMaxSum - максимальная сумма одного перевода, 0 - без ограничения
+	TransferMaxSum float64
+	// TransferDailyLimit - сколько пользователь может перевести за сутки, 0 - без ограничения
+	TransferDailyLimit float64
+}
@@ -0,0 +1,118 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type UserRole string
+
+const (
+	RoleUser UserRole = "user"
+	// RoleSupport - сотрудник поддержки, только просматривает данные пользователей
+	RoleSupport UserRole = "support"
+	RoleAdmin   UserRole = "admin"
+)
+
+func (r UserRole) Valid() bool {
+	switch r {
+	case RoleUser, RoleSupport, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+type UserStatus string
+
+const (
+	UserActive UserStatus = "active"
+	// UserLocked - пользователь заблокирован администратором и не может войти
+	UserLocked UserStatus = "locked"
+	// UserDeleted - пользователь удалён, личные данные обезличены, финансовые записи сохранены
+	UserDeleted UserStatus = "deleted"
+)
+
+type User struct {
+	ID         int        `db:"id" json:"-"`
+	Login      string     `db:"username" json:"login"`
+	MerchantID int        `db:"merchant_id" json:"-"`
+	Role       UserRole   `db:"role" json:"role"`
+	Status     UserStatus `db:"status" json:"status"`
+	// TokenVersion увеличивается при смене пароля
+	TokenVersion int `db:"token_version" json:"-"`
+}
+
+// UserSummary - сведения о пользователе для администратора
+type UserSummary struct {
+	User
+	Balance Balance `db:"-" json:"balance"`
+	Orders  int     `db:"orders" json:"orders"`
+}
+
+type AuditAction string
+
+const (
+	AuditBalanceAdjust AuditAction = "balance_adjust"
+	AuditOrderRecheck  AuditAction = "order_recheck"
+	AuditOrderReassign AuditAction = "order_reassign"
+	AuditUserLock      AuditAction = "user_lock"
+	AuditUserUnlock    AuditAction = "user_unlock"
+	AuditRoleChange    AuditAction = "role_change"
+	AuditUserDelete    AuditAction = "user_delete"
+	// AuditUserView - администратор просмотрел сведения о пользователе
+	AuditUserView AuditAction = "user_view"
+)
+
+// AuditEntry - действие администратора над пользователем
+type AuditEntry struct {
+	ID           int             `db:"id" json:"-"`
+	AdminID      int             `db:"admin_id" json:"-"`
+	Admin        string          `db:"admin" json:"admin"`
+	Action       AuditAction     `db:"action" json:"action"`
+	TargetUserID int             `db:"target_user_id" json:"-"`
+	Details      json.RawMessage `db:"details" json:"details,omitempty"`
+	CreatedAt    time.Time       `db:"created_at" json:"created_at"`
+}
+
+// LoginFailures - неудачные попытки входа по логину (с последнего успешного входа) и по адресу
+type LoginFailures struct {
+	Account     int        `db:"account"`
+	AccountLast *time.Time `db:"account_last"`
+	IP          int        `db:"ip"`
+	IPLast      *time.Time `db:"ip_last"`
+}
+
+// TwoFactor - состояние TOTP пользователя; Secret непустой и до подтверждения
+type TwoFactor struct {
+	Secret   string `db:"totp_secret"`
+	Enabled  bool   `db:"totp_enabled"`
+	LastStep int64  `db:"totp_last_step"`
+}
+
+// Session - вход пользователя с одного устройства
+type Session struct {
+	ID         int       `db:"id" json:"id"`
+	Device     string    `db:"device" json:"device"`
+	IP         string    `db:"ip" json:"ip"`
+	UserAgent  string    `db:"user_agent" json:"user_agent"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	LastSeenAt time.Time `db:"last_seen_at" json:"last_seen_at"`
+	// Current - сессия, из которой пришёл запрос
+	Current bool `db:"-" json:"current"`
+}
+
+// APIKey - ключ для запросов сервер-сервер; UserID nil - ключ магазина
+type APIKey struct {
+	ID         int        `db:"id" json:"id"`
+	MerchantID int        `db:"merchant_id" json:"-"`
+	UserID     *int       `db:"user_id" json:"-"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	Hash       string     `db:"key_hash" json:"-"`
+	Scopes     []string   `db:"scopes" json:"scopes"`
+	RateLimit  int        `db:"rate_limit" json:"rate_limit"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	// Login - владелец ключа пользователя, пусто для ключа магазина
+	Login string `db:"username" json:"-"`
+}
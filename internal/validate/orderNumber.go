@@ -1,35 +1,63 @@
 package validate
 
-import (
-	"strconv"
-)
-
+// ValidateOrderNumber проверяет номер по алгоритму Луна, схема по умолчанию
 func ValidateOrderNumber(number string) bool {
+	return Luhn(number)
+}
 
-	i, err := strconv.ParseInt(number, 10, 64)
+// Luhn проверяет контрольную сумму номера произвольной длины
+func Luhn(number string) bool {
 
-	if err != nil {
+	if !isDigits(number) {
 		return false
 	}
 
-	return (i%10+checksum(i/10))%10 == 0
-}
-
-func checksum(number int64) int64 {
-	var luhn int64
-
-	for i := 0; number > 0; i++ {
-		cur := number % 10
+	var sum int
+	for i := 0; i < len(number); i++ {
+		cur := int(number[len(number)-1-i] - '0')
 
-		if i%2 == 0 { // even
+		if i%2 == 1 {
 			cur = cur * 2
 			if cur > 9 {
 				cur = cur%10 + cur/10
 			}
 		}
+		sum += cur
+	}
+	return sum%10 == 0
+}
+
+// Mod97 проверяет номер по ISO 7064 MOD 97-10. Буквы латинского алфавита
+// заменяются числами 10-35, как в IBAN
+func Mod97(number string) bool {
+
+	if len(number) < 2 {
+		return false
+	}
+
+	var remainder int
+	for i := 0; i < len(number); i++ {
+		c := number[i]
+		switch {
+		case c >= '0' && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
 
-		luhn += cur
-		number = number / 10
+func isDigits(number string) bool {
+	if number == "" {
+		return false
+	}
+	for i := 0; i < len(number); i++ {
+		if number[i] < '0' || number[i] > '9' {
+			return false
+		}
 	}
-	return luhn % 10
+	return true
 }
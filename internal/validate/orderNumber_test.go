@@ -1,6 +1,7 @@
 package validate
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,6 +34,10 @@ func TestVaildateOrderNumber(t *testing.T) {
 		{"0", true},
 		{"", false},
 		{"letter", false},
+		{"-0", false},
+		{"+49927398716", false},
+		{"12345678123456781234567812345672", true},
+		{"12345678123456781234567812345670", false},
 	}
 
 	for _, tc := range testCases {
@@ -43,3 +48,78 @@ func TestVaildateOrderNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestMod97(t *testing.T) {
+
+	testCases := []struct {
+		number string
+		result bool
+	}{
+		{"12345678978", true},
+		{"12345678979", false},
+		{"3214282912345698765432161182", true}, // IBAN GB82WEST12345698765432 после перестановки
+		{"WEST12345698765432GB82", true},
+		{"1", false},
+		{"", false},
+		{"west12345698765432GB82", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.number, func(t *testing.T) {
+			assert.Equal(t, tc.result, Mod97(tc.number))
+		})
+	}
+}
+
+// legacyValidateOrderNumber - прежняя реализация через int64, эталон для фаззинга
+func legacyValidateOrderNumber(number string) bool {
+
+	i, err := strconv.ParseInt(number, 10, 64)
+
+	if err != nil {
+		return false
+	}
+
+	return (i%10+legacyChecksum(i/10))%10 == 0
+}
+
+func legacyChecksum(number int64) int64 {
+	var luhn int64
+
+	for i := 0; number > 0; i++ {
+		cur := number % 10
+
+		if i%2 == 0 { // even
+			cur = cur * 2
+			if cur > 9 {
+				cur = cur%10 + cur/10
+			}
+		}
+
+		luhn += cur
+		number = number / 10
+	}
+	return luhn % 10
+}
+
+func FuzzLuhn(f *testing.F) {
+
+	for _, seed := range []string{"0", "8", "49927398716", "79927398713", "1234567812345670", "9223372036854775807", "0049927398716"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, number string) {
+		result := Luhn(number)
+
+		// прежняя реализация ограничена int64 и принимала знак, сравниваем только там, где она корректна
+		if isDigits(number) {
+			if _, err := strconv.ParseInt(number, 10, 64); err == nil {
+				if legacy := legacyValidateOrderNumber(number); legacy != result {
+					t.Fatalf("Luhn(%q) = %v, legacy implementation returned %v", number, result, legacy)
+				}
+			}
+		} else if result {
+			t.Fatalf("Luhn(%q) accepted non-digit number", number)
+		}
+	})
+}
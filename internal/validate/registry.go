@@ -0,0 +1,131 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Validator interface {
+	Validate(number string) bool
+}
+
+type Func func(number string) bool
+
+func (f Func) Validate(number string) bool {
+	return f(number)
+}
+
+var ErrUnknownScheme = errors.New("unknown validation scheme")
+
+var (
+	mu      sync.RWMutex
+	schemes = map[string]Validator{
+		"luhn":  Func(Luhn),
+		"mod97": Func(Mod97),
+	}
+)
+
+// Register добавляет схему проверки, которую затем можно выбрать в конфигурации
+func Register(name string, v Validator) {
+	mu.Lock()
+	defer mu.Unlock()
+	schemes[name] = v
+}
+
+// Rules дополняет схему ограничениями на префикс и длину номера
+type Rules struct {
+	Scheme    Validator
+	Prefixes  []string
+	MinLength int
+	MaxLength int
+}
+
+func (r Rules) Validate(number string) bool {
+
+	if r.MinLength > 0 && len(number) < r.MinLength {
+		return false
+	}
+	if r.MaxLength > 0 && len(number) > r.MaxLength {
+		return false
+	}
+	if len(r.Prefixes) > 0 {
+		matched := false
+		for _, prefix := range r.Prefixes {
+			if strings.HasPrefix(number, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return r.Scheme.Validate(number)
+}
+
+// New собирает валидатор по описанию вида "luhn;prefix=12,34;length=8-20".
+// Первой указывается схема, далее необязательные правила
+func New(spec string) (Validator, error) {
+
+	parts := strings.Split(spec, ";")
+
+	name := strings.TrimSpace(parts[0])
+	mu.RLock()
+	scheme, ok := schemes[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownScheme, name)
+	}
+	if len(parts) == 1 {
+		return scheme, nil
+	}
+
+	rules := Rules{Scheme: scheme}
+	for _, option := range parts[1:] {
+		key, value, found := strings.Cut(strings.TrimSpace(option), "=")
+		if !found {
+			return nil, fmt.Errorf("bad validation rule %q", option)
+		}
+		switch key {
+		case "prefix":
+			for _, prefix := range strings.Split(value, ",") {
+				if prefix = strings.TrimSpace(prefix); prefix != "" {
+					rules.Prefixes = append(rules.Prefixes, prefix)
+				}
+			}
+		case "length":
+			minLength, maxLength, err := parseLength(value)
+			if err != nil {
+				return nil, fmt.Errorf("bad validation rule %q: %w", option, err)
+			}
+			rules.MinLength, rules.MaxLength = minLength, maxLength
+		default:
+			return nil, fmt.Errorf("unknown validation rule %q", key)
+		}
+	}
+	return rules, nil
+}
+
+// parseLength разбирает длину вида "16" или "8-20"
+func parseLength(value string) (int, int, error) {
+	from, to, isRange := strings.Cut(value, "-")
+
+	minLength, err := strconv.Atoi(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return minLength, minLength, nil
+	}
+	maxLength, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if minLength > maxLength {
+		return 0, 0, fmt.Errorf("min length greater than max")
+	}
+	return minLength, maxLength, nil
+}
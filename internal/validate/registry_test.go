@@ -0,0 +1,47 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+
+	Register("any", Func(func(string) bool { return true }))
+
+	testCases := []struct {
+		spec    string
+		valid   []string
+		invalid []string
+		wantErr bool
+	}{
+		{spec: "luhn", valid: []string{"49927398716", "12345678123456781234567812345672"}, invalid: []string{"49927398717"}},
+		{spec: "mod97", valid: []string{"12345678978"}, invalid: []string{"49927398716"}},
+		{spec: "luhn;prefix=4,79", valid: []string{"49927398716", "79927398713"}, invalid: []string{"1234567812345670"}},
+		{spec: "luhn; length=16", valid: []string{"1234567812345670"}, invalid: []string{"49927398716"}},
+		{spec: "any;length=2-4;prefix=A", valid: []string{"AB", "ABCD"}, invalid: []string{"A", "ABCDE", "BA"}},
+		{spec: "unknown", wantErr: true},
+		{spec: "luhn;prefix", wantErr: true},
+		{spec: "luhn;length=5-2", wantErr: true},
+		{spec: "luhn;length=a", wantErr: true},
+		{spec: "luhn;color=red", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			v, err := New(tc.spec)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			for _, number := range tc.valid {
+				assert.True(t, v.Validate(number), number)
+			}
+			for _, number := range tc.invalid {
+				assert.False(t, v.Validate(number), number)
+			}
+		})
+	}
+}